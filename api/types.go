@@ -1,5 +1,12 @@
 package api
 
+import (
+  "encoding/hex"
+  "time"
+
+  "github.com/kelby/go-grin/core/core"
+)
+
 /// The state of the current fork tip
 // #[derive(Serialize, Deserialize, Debug, Clone)]
 struct Tip struct {
@@ -70,8 +77,45 @@ type OutputPrintable struct {
   Proof string
   /// Rangeproof hash (as hex string)
   Proof_hash string
+  /// Merkle proof of the output inclusion (as hex string), only set for
+  /// unspent coinbase outputs
+  Merkle_proof string
+}
+
+/// Sets the Merkle proof of the printable output, serialized as hex.
+func (self *OutputPrintable) Set_merkle_proof(proof *core.MerkleProof) {
+  self.Merkle_proof = proof.To_hex()
+}
+
+/// The Merkle proof of the printable output, if any.
+func (self *OutputPrintable) Get_merkle_proof() (*core.MerkleProof, error) {
+  if self.Merkle_proof == "" {
+    return nil, nil
+  }
+  proof, err := core.Merkle_proof_from_hex(self.Merkle_proof)
+  if err != nil {
+    return nil, err
+  }
+  return &proof, nil
+}
 
-  Merkle_proof MerkleProof
+/// The printable representation of a block header, hashes and keys as hex.
+func Block_header_printable_from_header(h *core.BlockHeader) BlockHeaderPrintable {
+  return BlockHeaderPrintable{
+    Hash:                h.Hash().To_hex(),
+    Version:             h.Version,
+    Height:              h.Height,
+    Previous:            h.Previous.To_hex(),
+    Timestamp:           h.Timestamp.Format(time.RFC3339),
+    Output_root:         h.Output_root.To_hex(),
+    Range_proof_root:    h.Range_proof_root.To_hex(),
+    Kernel_root:         h.Kernel_root.To_hex(),
+    Nonce:               h.Nonce,
+    Cuckoo_size:         h.Pow.Cuckoo_sizeshift,
+    Cuckoo_solution:     h.Pow.Nonces,
+    Total_difficulty:    h.Total_difficulty.To_num(),
+    Total_kernel_offset: hex.EncodeToString(h.Total_kernel_offset[:]),
+  }
 }
 
 // Printable representation of a block
@@ -287,11 +287,6 @@ type Chain interface {
   /// Get the tip of the header chain.
   pub fn get_header_head(&self) -> Result<Tip, Error>
 
-  /// Builds an iterator on blocks starting from the current chain head and
-  /// running backward. Specialized to return information pertaining to block
-  /// difficulty calculation (timestamp and previous difficulties).
-  pub fn difficulty_iter(&self) -> store::DifficultyIter
-
   /// Check whether we have a block without reading it
   Block_exists(&self, h: Hash) -> Result<bool, Error>
 }
@@ -307,3 +302,10 @@ func (self *Chain) Verify_tx_lock_height(tx *Transaction) error {
     errors.New("ErrorKind TxLockHeight")
   }
 }
+
+/// Builds an iterator on blocks starting from the current chain head and
+/// running backward. Specialized to return information pertaining to block
+/// difficulty calculation (timestamp and previous difficulties).
+func (self *Chain) Difficulty_iter() *DifficultyIter {
+  return Difficulty_iter_from(self.Head.Last_block_h, self.Store)
+}
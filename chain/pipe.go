@@ -18,9 +18,9 @@ type BlockContext struct {
 }
 
 func update_header_head(bh: &BlockHeader, ctx: &mut BlockContext, batch: &mut store::Batch, ) (Tip, error) {
-  tip := Tip.From_block(bh)
+  tip := Tip_from_block(bh)
 
-  if tip.Total_difficulty > ctx.Head.Total_difficulty {
+  if tip.Total_difficulty.Cmp(ctx.Head.Total_difficulty) > 0 {
     batch.Save_header_head(&tip)
 
     ctx.Head = tip
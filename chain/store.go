@@ -1,6 +1,11 @@
 package chain
 
-import "store"
+import (
+  "store"
+
+  consensus "github.com/kelby/go-grin/core"
+  "github.com/kelby/go-grin/core/core"
+)
 
 const (
   STORE_SUBPATH string = "chain"
@@ -58,3 +63,86 @@ func (self *ChainStore) Is_on_current_chain(header *BlockHeader) error {
     errors.New("header.hash == header_at_height.hash")
   }
 }
+
+/// The part of the store the difficulty iterator reads headers from.
+type HeaderStore interface {
+  Get_block_header(h *core.Hash) (core.BlockHeader, error)
+}
+
+/// An iterator on blocks, from latest to earliest, specialized to return
+/// information pertaining to block difficulty calculation (timestamp and
+/// previous difficulties). Mostly used by the consensus next difficulty
+/// calculation.
+type DifficultyIter struct {
+  Start core.Hash
+  Store HeaderStore
+
+  // maintain state for both the "next" header in this iteration
+  // and its previous header in the chain ("next next" in the iteration)
+  // so we effectively read-ahead as we iterate through the chain back
+  // toward the genesis block (while maintaining current state)
+  Header      *core.BlockHeader
+  Prev_header *core.BlockHeader
+  started     bool
+}
+
+/// Build a new iterator using the provided chain store and starting from
+/// the provided block hash.
+func Difficulty_iter_from(start core.Hash, store HeaderStore) *DifficultyIter {
+  return &DifficultyIter{Start: start, Store: store}
+}
+
+/// Implements consensus.DifficultyCursor, the data being the timestamp of
+/// each header and the difficulty it added to the total. The iteration ends
+/// with the genesis header, failing to read any header from the store is an
+/// error.
+func (self *DifficultyIter) Next() (consensus.DifficultyData, bool, error) {
+  // Get both header and previous_header if this is the initial iteration.
+  // Otherwise move prev_header to header and get the next prev_header.
+  if !self.started {
+    header, err := self.get_header(&self.Start)
+    if err != nil {
+      return consensus.DifficultyData{}, false, err
+    }
+    self.started = true
+    self.Header = &header
+  } else {
+    self.Header = self.Prev_header
+  }
+
+  // If we have a header we can do this iteration.
+  // Otherwise we are done.
+  if self.Header == nil {
+    return consensus.DifficultyData{}, false, nil
+  }
+
+  // the genesis header has no previous header
+  self.Prev_header = nil
+  if self.Header.Height > 0 {
+    prev_header, err := self.get_header(&self.Header.Previous)
+    if err != nil {
+      return consensus.DifficultyData{}, false, err
+    }
+    self.Prev_header = &prev_header
+  }
+
+  prev_difficulty := core.Difficulty_zero()
+  if self.Prev_header != nil {
+    prev_difficulty = self.Prev_header.Total_difficulty
+  }
+  difficulty := self.Header.Total_difficulty.Sub(prev_difficulty)
+
+  data := consensus.DifficultyData{
+    Timestamp:  uint64(self.Header.Timestamp.Unix()),
+    Difficulty: difficulty.To_num(),
+  }
+  return data, true, nil
+}
+
+func (self *DifficultyIter) get_header(h *core.Hash) (core.BlockHeader, error) {
+  header, err := self.Store.Get_block_header(h)
+  if err != nil {
+    return core.BlockHeader{}, Error{Kind: StoreErr, Msg: err.Error()}
+  }
+  return header, nil
+}
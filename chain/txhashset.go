@@ -1,9 +1,15 @@
 package chain
 
-import "store"
+import (
+	"fmt"
+
+	"github.com/kelby/go-grin/core/core"
+	"github.com/kelby/go-grin/secp"
+	"store"
+)
 
 type PMMRHandle struct {
-	Backend  PMMRBackend
+	Backend  core.Backend
 	Last_pos uint64
 }
 
@@ -52,7 +58,7 @@ type TxHashSet interface {
 	Roots() (Hash, Hash, Hash)
 
 	/// build a new merkle proof for the given position
-	Merkle_proof(commit Commitment) (MerkleProof, string)
+	Merkle_proof(commit Commitment) (MerkleProof, error)
 
 	/// Compact the MMR data files and flush the rm logs
 	Compact() error
@@ -77,3 +83,63 @@ func (self *TxHashSet) Is_unspent(output_id *OutputIdentifier) (Hash, error) {
 				}
 			}
 }
+
+/// Build a Merkle proof for the output with the given commitment, valid for
+/// the current state of the output MMR.
+func (self *TxHashSet) Merkle_proof(commit secp.Commitment) (core.MerkleProof, error) {
+	pos, err := self.Commit_index.Get_output_pos(&commit)
+	if err != nil {
+		return core.MerkleProof{}, Error{Kind: OutputNotFound}
+	}
+
+	output_pmmr := core.Pmmr_at(self.Output_pmmr_h.Backend, self.Output_pmmr_h.Last_pos)
+	proof, err := output_pmmr.Merkle_proof(pos)
+	if err != nil {
+		return core.MerkleProof{}, Error{Kind: TxHashSetErr, Msg: err.Error()}
+	}
+	return proof, nil
+}
+
+/// Number of range proofs verified together when validating the whole set.
+const RANGEPROOF_BATCH_SIZE = 1000
+
+/// Verify the range proofs of all the unspent outputs. Each output commitment
+/// is matched with the proof at the same position in the range proof MMR and
+/// the proofs are verified in batches.
+func (self *TxHashSet) Verify_rangeproofs() error {
+	commits := make([]secp.Commitment, 0, RANGEPROOF_BATCH_SIZE)
+	proofs := make([]secp.RangeProof, 0, RANGEPROOF_BATCH_SIZE)
+
+	verify := func() error {
+		if err := core.Batch_verify_commit_proofs(commits, proofs); err != nil {
+			return Error{Kind: InvalidRangeProof, Msg: err.Error()}
+		}
+		commits = commits[:0]
+		proofs = proofs[:0]
+		return nil
+	}
+
+	for pos := uint64(1); pos <= self.Output_pmmr_h.Last_pos; pos++ {
+		if !core.Is_leaf(pos) {
+			continue
+		}
+		var output core.OutputIdentifier
+		if !self.Output_pmmr_h.Backend.Get_data(pos, &output) {
+			// pruned, spent output
+			continue
+		}
+		var proof core.RangeProof
+		if !self.Rproof_pmmr_h.Backend.Get_data(pos, &proof) {
+			return Error{Kind: TxHashSetErr, Msg: fmt.Sprintf("missing range proof at pos %d", pos)}
+		}
+		commits = append(commits, output.Commit)
+		proofs = append(proofs, proof.RangeProof)
+
+		if len(proofs) == RANGEPROOF_BATCH_SIZE {
+			if err := verify(); err != nil {
+				return err
+			}
+		}
+	}
+	return verify()
+}
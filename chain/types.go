@@ -4,7 +4,7 @@ package chain
 
 import "fmt"
 
-import "github.com/kelby/go-grin/core"
+import "github.com/kelby/go-grin/core/core"
 
 /// Options for block validation
 // type Options uint32
@@ -83,10 +83,57 @@ type Tip struct {
   Total_difficulty core.Difficulty
 }
 
-type Tip interface {
-  /// Creates a new tip at height zero and the provided genesis hash.
-  New(gbh Hash) Tip
+/// Creates a new tip at height zero and the provided genesis hash.
+func New_tip(gbh core.Hash) Tip {
+  return Tip{
+    Height:           0,
+    Last_block_h:     gbh,
+    Prev_block_h:     gbh,
+    Total_difficulty: core.Difficulty_one(),
+  }
+}
+
+/// Append a new block to this tip, returning a new updated tip.
+func Tip_from_block(bh *core.BlockHeader) Tip {
+  return Tip{
+    Height:           bh.Height,
+    Last_block_h:     bh.Hash(),
+    Prev_block_h:     bh.Previous,
+    Total_difficulty: bh.Total_difficulty,
+  }
+}
+
+type ErrorKind int
+
+/// Chain error definitions
+const (
+  /// Output not found
+  OutputNotFound ErrorKind = iota
+  /// One of the range proofs is invalid
+  InvalidRangeProof
+  /// Internal issue when trying to save or load data from the TxHashSet
+  TxHashSetErr
+  /// Internal issue when trying to save or load data from store
+  StoreErr
+)
+
+/// Error returned by the chain, the Kind can be used to tell the different
+/// failure cases apart.
+type Error struct {
+  Kind ErrorKind
+  Msg string
+}
 
-  /// Append a new block to this tip, returning a new updated tip.
-  From_block(bh *BlockHeader) Tip
+func (self Error) Error() string {
+  switch self.Kind {
+  case OutputNotFound:
+    return "output not found"
+  case InvalidRangeProof:
+    return fmt.Sprintf("invalid range proof: %s", self.Msg)
+  case TxHashSetErr:
+    return fmt.Sprintf("txhashset error: %s", self.Msg)
+  case StoreErr:
+    return fmt.Sprintf("store error: %s", self.Msg)
+  }
+  return "unknown chain error"
 }
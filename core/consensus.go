@@ -1,6 +1,140 @@
+package core
+
+import (
+  "fmt"
+  "sort"
+)
+
+/// A grin is divisible to 10^9, following the SI prefixes
+const GRIN_BASE uint64 = 1000000000
+
+/// Milligrin, a thousand of a grin
+const MILLI_GRIN uint64 = GRIN_BASE / 1000
+
+/// Microgrin, a thousand of a milligrin
+const MICRO_GRIN uint64 = MILLI_GRIN / 1000
+
+/// Nanogrin, smallest unit, takes a billion to make a grin
+const NANO_GRIN uint64 = 1
+
+/// The block subsidy amount, one grin per second on average
+const REWARD uint64 = 60 * GRIN_BASE
+
+/// Actual block reward for a given total fee amount
+func Reward(fee uint64) uint64 {
+  return REWARD + fee
+}
+
+/// Number of blocks before a coinbase matures and can be spent
+const COINBASE_MATURITY = 1000
+
+/// Default number of nonces in a Cuckoo Cycle proof of work
+const PROOFSIZE = 42
+
+/// Default Cuckoo Cycle size shift used for mining and validating.
+const DEFAULT_SIZESHIFT uint8 = 30
+
+/// Default minimum Cuckoo Cycle size shift allowed for production chains.
+const DEFAULT_MIN_SIZESHIFT uint8 = 30
+
+/// Reference Cuckoo Cycle size shift the difficulty of the proofs of work
+/// of larger graphs is adjusted against.
+const REFERENCE_SIZESHIFT uint8 = 30
+
+/// Default Cuckoo Cycle easiness, high enough to have good likeliness to find
+/// a solution.
+const EASINESS uint32 = 50
+
+/// Cut-through horizon, the number of blocks below which we don't keep
+/// spent outputs around, 48 hours worth of blocks.
+const CUT_THROUGH_HORIZON uint32 = 48 * 3600 / uint32(BLOCK_TIME_SEC)
+
+/// Weight of an input when counted against the max block weight capacity
+const BLOCK_INPUT_WEIGHT = 1
+
+/// Weight of an output when counted against the max block weight capacity
+const BLOCK_OUTPUT_WEIGHT = 10
+
+/// Weight of a kernel when counted against the max block weight capacity
+const BLOCK_KERNEL_WEIGHT = 2
+
+/// Total maximum block weight. At current sizes, this means a maximum
+/// theoretical size of:
+/// * `(1 + 33 + 8 + 675) * 8000 = 5_736_000` for a block with only outputs
+/// * `(1 + 8 + 8 + 33 + 64) * 40_000 = 4_560_000` for a block with only kernels
+/// * `(1 + 33) * 80_000 = 2_720_000` for a block with only inputs
+///
+/// Given that a block needs to have at least one kernel for the coinbase,
+/// and one kernel per transaction, practical maximum size is about 5.7MB.
+const MAX_BLOCK_WEIGHT = 80000
+
+/// Computes the weight of a body (transaction or block) with the given
+/// number of inputs, outputs and kernels.
+func Body_weight(num_inputs int, num_outputs int, num_kernels int) int {
+  return num_inputs*BLOCK_INPUT_WEIGHT + num_outputs*BLOCK_OUTPUT_WEIGHT + num_kernels*BLOCK_KERNEL_WEIGHT
+}
+
+/// Block interval, in seconds, the network will tune its next_target for.
+/// Note that we may reduce this value in the future as we get more data on
+/// mining with Cuckoo Cycle, networks improve and block propagation is
+/// optimized (adjusting the reward accordingly).
+const BLOCK_TIME_SEC uint64 = 60
+
+/// Number of blocks used to calculate difficulty adjustments
+const DIFFICULTY_ADJUST_WINDOW uint64 = 60
+
+/// Average time span of the difficulty adjustment window
+const BLOCK_TIME_WINDOW uint64 = DIFFICULTY_ADJUST_WINDOW * BLOCK_TIME_SEC
+
+/// Maximum size time window used for difficulty adjustments
+const UPPER_TIME_BOUND uint64 = BLOCK_TIME_WINDOW * 2
+
+/// Minimum size time window used for difficulty adjustments
+const LOWER_TIME_BOUND uint64 = BLOCK_TIME_WINDOW / 2
+
+/// Dampening factor to use for difficulty adjustment
+const DAMP_FACTOR uint64 = 3
+
+/// Size of the median window used to compare block times
+const MEDIAN_TIME_WINDOW uint64 = 11
+
+/// Index at half the desired median
+const MEDIAN_TIME_INDEX uint64 = MEDIAN_TIME_WINDOW / 2
+
+/// The initial difficulty at launch. This should be over-estimated
+/// and difficulty should come down at launch rather than up
+/// Currently grossly over-estimated at 10% of current
+/// ethereum GPUs (assuming 1GPU can solve a block at diff 1
+/// in one block interval)
+const INITIAL_DIFFICULTY uint64 = 1000000
+
+/// Error when computing the next difficulty adjustment.
+type TargetError struct {
+  Msg string
+}
+
+func (self TargetError) Error() string {
+  return fmt.Sprintf("error computing new difficulty: %s", self.Msg)
+}
+
+/// Timestamp (in seconds) and difficulty of a block, the data the
+/// difficulty adjustment is based on.
+type DifficultyData struct {
+  Timestamp  uint64
+  Difficulty uint64
+}
+
+/// Cursor over the difficulty data of past blocks, running from the latest
+/// (highest height) to the oldest (lowest height).
+type DifficultyCursor interface {
+  /// Data of the next (older) block, ok being false once there are no more
+  /// blocks.
+  Next() (data DifficultyData, ok bool, err error)
+}
+
 /// Computes the proof-of-work difficulty that the next block should comply
-/// with. Takes an iterator over past blocks, from latest (highest height) to
-/// oldest (lowest height). The iterator produces pairs of timestamp and
+/// with. Takes a cursor over past blocks, from latest (highest height) to
+/// oldest (lowest height). The cursor produces pairs of timestamp and
 /// difficulty for each block.
 ///
 /// The difficulty calculation is based on both Digishield and GravityWave
@@ -9,64 +143,128 @@
 /// DIFFICULTY_ADJUST_WINDOW blocks. The corresponding timespan is calculated
 /// by using the difference between the median timestamps at the beginning
 /// and the end of the window.
-func Next_difficulty<T>(cursor: T) -> Result<Difficulty, TargetError>
-where
-  T: IntoIterator<Item = Result<(u64, Difficulty), TargetError>>,
-{
+func Next_difficulty(cursor DifficultyCursor) (uint64, error) {
   // Create vector of difficulty data running from earliest
   // to latest, and pad with simulated pre-genesis data to allow earlier
   // adjustment if there isn't enough window data
   // length will be DIFFICULTY_ADJUST_WINDOW+MEDIAN_TIME_WINDOW
-  let diff_data = global::difficulty_data_to_vector(cursor);
+  diff_data, err := difficulty_data_to_vector(cursor)
+  if err != nil {
+    return 0, err
+  }
 
   // Obtain the median window for the earlier time period
   // the first MEDIAN_TIME_WINDOW elements
-  let mut window_earliest: Vec<u64> = diff_data
-    .iter()
-    .take(MEDIAN_TIME_WINDOW as usize)
-    .map(|n| n.clone().unwrap().0)
-    .collect();
+  window_earliest := make([]uint64, 0, MEDIAN_TIME_WINDOW)
+  for _, d := range diff_data[:MEDIAN_TIME_WINDOW] {
+    window_earliest = append(window_earliest, d.Timestamp)
+  }
   // pick median
-  window_earliest.sort();
-  let earliest_ts = window_earliest[MEDIAN_TIME_INDEX as usize];
+  sort.Slice(window_earliest, func(i, j int) bool { return window_earliest[i] < window_earliest[j] })
+  earliest_ts := window_earliest[MEDIAN_TIME_INDEX]
 
   // Obtain the median window for the latest time period
   // i.e. the last MEDIAN_TIME_WINDOW elements
-  let mut window_latest: Vec<u64> = diff_data
-    .iter()
-    .skip(DIFFICULTY_ADJUST_WINDOW as usize)
-    .map(|n| n.clone().unwrap().0)
-    .collect();
+  window_latest := make([]uint64, 0, MEDIAN_TIME_WINDOW)
+  for _, d := range diff_data[DIFFICULTY_ADJUST_WINDOW:] {
+    window_latest = append(window_latest, d.Timestamp)
+  }
   // pick median
-  window_latest.sort();
-  let latest_ts = window_latest[MEDIAN_TIME_INDEX as usize];
+  sort.Slice(window_latest, func(i, j int) bool { return window_latest[i] < window_latest[j] })
+  latest_ts := window_latest[MEDIAN_TIME_INDEX]
 
   // median time delta
-  let ts_delta = latest_ts - earliest_ts;
+  ts_delta := latest_ts - earliest_ts
 
   // Get the difficulty sum of the last DIFFICULTY_ADJUST_WINDOW elements
-  let diff_sum = diff_data
-    .iter()
-    .skip(MEDIAN_TIME_WINDOW as usize)
-    .fold(0, |sum, d| sum + d.clone().unwrap().1.to_num());
+  var diff_sum uint64
+  for _, d := range diff_data[MEDIAN_TIME_WINDOW:] {
+    diff_sum += d.Difficulty
+  }
 
   // Apply dampening except when difficulty is near 1
-  let ts_damp = if diff_sum < DAMP_FACTOR * DIFFICULTY_ADJUST_WINDOW {
-    ts_delta
-  } else {
-    (1 * ts_delta + (DAMP_FACTOR - 1) * BLOCK_TIME_WINDOW) / DAMP_FACTOR
-  };
+  ts_damp := ts_delta
+  if diff_sum >= DAMP_FACTOR*DIFFICULTY_ADJUST_WINDOW {
+    ts_damp = (1*ts_delta + (DAMP_FACTOR-1)*BLOCK_TIME_WINDOW) / DAMP_FACTOR
+  }
 
   // Apply time bounds
-  let adj_ts = if ts_damp < LOWER_TIME_BOUND {
-    LOWER_TIME_BOUND
+  adj_ts := ts_damp
+  if ts_damp < LOWER_TIME_BOUND {
+    adj_ts = LOWER_TIME_BOUND
   } else if ts_damp > UPPER_TIME_BOUND {
-    UPPER_TIME_BOUND
-  } else {
-    ts_damp
-  };
+    adj_ts = UPPER_TIME_BOUND
+  }
+
+  difficulty := diff_sum * BLOCK_TIME_SEC / adj_ts
+  if difficulty < 1 {
+    difficulty = 1
+  }
+  return difficulty, nil
+}
+
+/// Converts the cursor to a vector running from earliest to latest, padded
+/// with simulated pre-genesis data when there isn't enough, so it always has
+/// MEDIAN_TIME_WINDOW + DIFFICULTY_ADJUST_WINDOW elements.
+func difficulty_data_to_vector(cursor DifficultyCursor) ([]DifficultyData, error) {
+  // Convert cursor to vector, so we can append to it if necessary
+  needed_block_count := int(MEDIAN_TIME_WINDOW + DIFFICULTY_ADJUST_WINDOW)
+  last_n := make([]DifficultyData, 0, needed_block_count)
+  for len(last_n) < needed_block_count {
+    data, ok, err := cursor.Next()
+    if err != nil {
+      return nil, err
+    }
+    if !ok {
+      break
+    }
+    last_n = append(last_n, data)
+  }
+  if len(last_n) == 0 {
+    return nil, TargetError{Msg: "no difficulty data"}
+  }
+
+  // Sort blocks from earliest to latest (to keep conceptually easier)
+  for i, j := 0, len(last_n)-1; i < j; i, j = i+1, j-1 {
+    last_n[i], last_n[j] = last_n[j], last_n[i]
+  }
 
-  let difficulty = diff_sum * BLOCK_TIME_SEC / adj_ts;
+  // Only needed just after blockchain launch... basically ensures there's
+  // always enough data by simulating perfectly timed pre-genesis
+  // blocks at the genesis difficulty as needed.
+  block_count_difference := needed_block_count - len(last_n)
+  if block_count_difference > 0 {
+    // Collect any real data we have
+    live_intervals := append([]DifficultyData{}, last_n...)
+    for i := len(live_intervals) - 1; i > 0; i-- {
+      // prevents issues with very fast automated test chains
+      if live_intervals[i-1].Timestamp > live_intervals[i].Timestamp {
+        live_intervals[i].Timestamp = 0
+      } else {
+        live_intervals[i].Timestamp -= live_intervals[i-1].Timestamp
+      }
+    }
+    // Remove genesis "interval"
+    if len(live_intervals) > 1 {
+      live_intervals = live_intervals[1:]
+    } else {
+      // if it's just genesis, adjust the interval
+      live_intervals[0].Timestamp = BLOCK_TIME_SEC
+    }
+    last_interval := live_intervals[len(live_intervals)-1]
+    last_ts := last_n[0].Timestamp
 
-  Ok(Difficulty::from_num(max(difficulty, 1)))
+    // fill in simulated blocks with values from the previous real block
+    padding := make([]DifficultyData, block_count_difference)
+    for i := block_count_difference - 1; i >= 0; i-- {
+      if last_ts > last_interval.Timestamp {
+        last_ts -= last_interval.Timestamp
+      } else {
+        last_ts = 0
+      }
+      padding[i] = DifficultyData{Timestamp: last_ts, Difficulty: last_interval.Difficulty}
+    }
+    last_n = append(padding, last_n...)
+  }
+  return last_n, nil
 }
@@ -0,0 +1,110 @@
+package core
+
+import (
+  "errors"
+  "testing"
+)
+
+/// Cursor over a fixed list of difficulty data, latest first.
+type vec_cursor struct {
+  data []DifficultyData
+  err  error
+}
+
+func (self *vec_cursor) Next() (DifficultyData, bool, error) {
+  if len(self.data) == 0 {
+    return DifficultyData{}, false, self.err
+  }
+  d := self.data[0]
+  self.data = self.data[1:]
+  return d, true, nil
+}
+
+const CUR_TIME uint64 = 1500000000
+
+/// Builds the data for next difficulty calculation with the provided
+/// constant time interval, difficulty and total length, latest first.
+func repeat(interval uint64, diff uint64, len uint64, cur_time uint64) []DifficultyData {
+  data := []DifficultyData{}
+  for n := len; n > 0; n-- {
+    data = append(data, DifficultyData{Timestamp: cur_time + (n-1)*interval, Difficulty: diff})
+  }
+  return data
+}
+
+func Test_next_difficulty(t *testing.T) {
+  just_enough := DIFFICULTY_ADJUST_WINDOW + MEDIAN_TIME_WINDOW
+
+  // checking averaging works, the second half of the window mined at 1500
+  // and the first at 500
+  sec := DIFFICULTY_ADJUST_WINDOW/2 + MEDIAN_TIME_WINDOW
+  averaging := append(
+    repeat(60, 1500, DIFFICULTY_ADJUST_WINDOW/2, CUR_TIME+sec*60),
+    repeat(60, 500, sec, CUR_TIME)...)
+
+  tests := []struct {
+    name     string
+    data     []DifficultyData
+    expected uint64
+  }{
+    // genesis only, padded with perfectly timed pre-genesis blocks
+    {"genesis", []DifficultyData{{CUR_TIME, 1}}, 1},
+    // short chains, padded repeating their last interval
+    {"short chain", repeat(60, 1000, 20, CUR_TIME), 1000},
+    {"short window", repeat(60, 1, DIFFICULTY_ADJUST_WINDOW, CUR_TIME), 1},
+    // don't get stuck on difficulty 1
+    {"fast short window", repeat(1, 10, DIFFICULTY_ADJUST_WINDOW, CUR_TIME), 14},
+    // just enough data, right interval, should stay constant
+    {"steady", repeat(60, 1000, just_enough, CUR_TIME), 1000},
+    {"more than enough", repeat(60, 1000, just_enough*2, CUR_TIME), 1000},
+    {"averaging", averaging, 1000},
+    // too slow, diff goes down
+    {"slow 90", repeat(90, 1000, just_enough, CUR_TIME), 857},
+    {"slow 120", repeat(120, 1000, just_enough, CUR_TIME), 750},
+    // too fast, diff goes up
+    {"fast 55", repeat(55, 1000, just_enough, CUR_TIME), 1028},
+    {"fast 45", repeat(45, 1000, just_enough, CUR_TIME), 1090},
+    // lowest time delta, dampened above LOWER_TIME_BOUND
+    {"fast 0", repeat(0, 1000, just_enough, CUR_TIME), 1500},
+    // hitting higher time bound, should always get the same result above
+    {"upper bound 300", repeat(300, 1000, just_enough, CUR_TIME), 500},
+    {"upper bound 400", repeat(400, 1000, just_enough, CUR_TIME), 500},
+    // we should never drop below 1
+    {"zero difficulty", repeat(90, 0, just_enough, CUR_TIME), 1},
+  }
+  for _, test := range tests {
+    diff, err := Next_difficulty(&vec_cursor{data: test.data})
+    if err != nil {
+      t.Errorf("%s: %s", test.name, err)
+      continue
+    }
+    if diff != test.expected {
+      t.Errorf("%s: next difficulty %d, expected %d", test.name, diff, test.expected)
+    }
+  }
+}
+
+func Test_next_difficulty_lower_bound(t *testing.T) {
+  // without dampening (difficulty near 1) the time delta can go below the
+  // lower bound and gets clamped
+  diff, err := Next_difficulty(&vec_cursor{data: repeat(10, 2, DIFFICULTY_ADJUST_WINDOW+MEDIAN_TIME_WINDOW, CUR_TIME)})
+  if err != nil {
+    t.Fatal(err)
+  }
+  if expected := 2 * DIFFICULTY_ADJUST_WINDOW * BLOCK_TIME_SEC / LOWER_TIME_BOUND; diff != expected {
+    t.Fatalf("next difficulty %d, expected %d", diff, expected)
+  }
+}
+
+func Test_next_difficulty_errors(t *testing.T) {
+  if _, err := Next_difficulty(&vec_cursor{}); err == nil {
+    t.Fatal("no error without any difficulty data")
+  }
+
+  // a failing cursor fails the calculation instead of padding the data
+  store_err := errors.New("store failure")
+  cursor := &vec_cursor{data: repeat(60, 1000, 10, CUR_TIME), err: store_err}
+  if _, err := Next_difficulty(cursor); err != store_err {
+    t.Fatalf("expected the cursor error, got %v", err)
+  }
+}
@@ -1,7 +1,68 @@
 package core
 
-import "fmt"
-import "time"
+import (
+	"fmt"
+	"time"
+
+	"github.com/kelby/go-grin/keychain"
+	"github.com/kelby/go-grin/secp"
+	"github.com/kelby/go-grin/util"
+
+	consensus "github.com/kelby/go-grin/core"
+)
+
+type BlockErrorKind int
+
+/// Errors thrown by Block validation
+const (
+	/// The sum of output minus input commitments does not
+	/// match the sum of kernel commitments
+	BlockKernelSumMismatch BlockErrorKind = iota
+	/// The total kernel sum on the block header is wrong
+	InvalidTotalKernelSum
+	/// Same as above but for the coinbase part of a block, including reward
+	CoinbaseSumMismatch
+	/// Kernel lock_height greater than block height
+	KernelLockHeight
+	/// Underlying tx related error, the body of the block is invalid
+	BlockTransactionErr
+	/// Underlying Secp256k1 error (signature validation or invalid public key
+	/// typically)
+	BlockSecpErr
+	/// Underlying keychain related error
+	BlockKeychainErr
+	/// Other unspecified error condition
+	BlockOther
+)
+
+/// Error returned by the Block validation, the Kind tells what rule was
+/// broken.
+type BlockError struct {
+	Kind BlockErrorKind
+	Msg  string
+}
+
+func (self BlockError) Error() string {
+	switch self.Kind {
+	case BlockKernelSumMismatch:
+		return "kernel sum mismatch"
+	case InvalidTotalKernelSum:
+		return "invalid total kernel sum"
+	case CoinbaseSumMismatch:
+		return "coinbase sum mismatch"
+	case KernelLockHeight:
+		return fmt.Sprintf("kernel lock height %s greater than block height", self.Msg)
+	case BlockTransactionErr:
+		return fmt.Sprintf("invalid block body: %s", self.Msg)
+	case BlockSecpErr:
+		return fmt.Sprintf("secp error: %s", self.Msg)
+	case BlockKeychainErr:
+		return fmt.Sprintf("keychain error: %s", self.Msg)
+	case BlockOther:
+		return self.Msg
+	}
+	return "unknown block error"
+}
 
 type BlockHeader struct {
 	/// Version of the block
@@ -9,24 +70,24 @@ type BlockHeader struct {
 	/// Height of this block since the genesis block (height 0)
 	Height uint64
 	/// Hash of the block previous to this in the chain.
-	Previous string
+	Previous Hash
 	/// Timestamp at which the block was built.
 	Timestamp time.Time
 	/// Total accumulated difficulty since genesis block
-	Total_difficulty string
+	Total_difficulty Difficulty
 	/// Merklish root of all the commitments in the TxHashSet
-	Output_root string
+	Output_root Hash
 	/// Merklish root of all range proofs in the TxHashSet
-	Range_proof_root string
+	Range_proof_root Hash
 	/// Merklish root of all transaction kernels in the TxHashSet
-	Kernel_root string
+	Kernel_root Hash
 	/// Total accumulated sum of kernel offsets since genesis block.
 	/// We can derive the kernel offset sum for *this* block from
 	/// the total kernel offset of the previous block header.
-	Total_kernel_offset string
+	Total_kernel_offset keychain.BlindingFactor
 	/// Total accumulated sum of kernel commitments since genesis block.
 	/// Should always equal the UTXO commitment sum minus supply.
-	Total_kernel_sum string
+	Total_kernel_sum secp.Commitment
 	/// Total size of the output MMR after applying this block
 	Output_mmr_size uint64
 	/// Total size of the kernel MMR after applying this block
@@ -34,30 +95,148 @@ type BlockHeader struct {
 	/// Nonce increment used to mine this block.
 	Nonce uint64
 	/// Proof of work data.
-	Pow string
+	Pow Proof
 }
 
 func Default() BlockHeader {
 	return BlockHeader{
 		Version:             1,
 		Height:              0,
-		Previous:            "ZERO_HASH",
-		Timestamp:           time.Now(),
-		Total_difficulty:    "Difficulty::one()",
-		Output_root:         "ZERO_HASH",
-		Range_proof_root:    "ZERO_HASH",
-		Kernel_root:         "ZERO_HASH",
-		Total_kernel_offset: "BlindingFactor::zero()",
-		Total_kernel_sum:    "Commitment::from_vec(vec![0; 33])",
+		Previous:            ZERO_HASH,
+		Timestamp:           time.Unix(0, 0).UTC(),
+		Total_difficulty:    Difficulty_one(),
+		Output_root:         ZERO_HASH,
+		Range_proof_root:    ZERO_HASH,
+		Kernel_root:         ZERO_HASH,
+		Total_kernel_offset: keychain.BlindingFactor{},
+		Total_kernel_sum:    secp.Commitment{},
 		Output_mmr_size:     0,
 		Kernel_mmr_size:     0,
 		Nonce:               0,
-		Pow:                 "Proof::zero(proof_size),"}
+		Pow:                 Zero_proof(consensus.Proofsize())}
+}
+
+/// Hash of the pre-pow part of the header, which the proof of work is
+/// computed from.
+func (self *BlockHeader) Pre_pow_hash() Hash {
+	hasher := New_hash_writer()
+	// writing to a HashWriter never fails
+	self.Write_pre_pow(hasher)
+	return hasher.Finalize()
+}
+
+/// Serialization of a block header. When hashing only the proof of work is
+/// written, the header hash is the hash of its proof.
+func (self *BlockHeader) Write(writer consensus.Writer) error {
+	if writer.Serialization_mode() != consensus.SERIALIZATION_HASH {
+		if err := self.Write_pre_pow(writer); err != nil {
+			return err
+		}
+	}
+	return self.Pow.Write(writer)
+}
+
+/// Write the pre-hash portion of the header
+func (self *BlockHeader) Write_pre_pow(writer consensus.Writer) error {
+	if err := writer.Write_u16(self.Version); err != nil {
+		return err
+	}
+	if err := writer.Write_u64(self.Height); err != nil {
+		return err
+	}
+	if err := self.Previous.Write(writer); err != nil {
+		return err
+	}
+	if err := writer.Write_i64(self.Timestamp.Unix()); err != nil {
+		return err
+	}
+	if err := self.Output_root.Write(writer); err != nil {
+		return err
+	}
+	if err := self.Range_proof_root.Write(writer); err != nil {
+		return err
+	}
+	if err := self.Kernel_root.Write(writer); err != nil {
+		return err
+	}
+	if err := writer.Write_fixed_bytes(self.Total_kernel_offset[:]); err != nil {
+		return err
+	}
+	if err := consensus.Write_commitment(writer, self.Total_kernel_sum); err != nil {
+		return err
+	}
+	if err := writer.Write_u64(self.Output_mmr_size); err != nil {
+		return err
+	}
+	if err := writer.Write_u64(self.Kernel_mmr_size); err != nil {
+		return err
+	}
+	if err := self.Total_difficulty.Write(writer); err != nil {
+		return err
+	}
+	return writer.Write_u64(self.Nonce)
+}
+
+/// The hash of the header, which is the hash of its proof of work (the proof
+/// itself commits to the pre-pow part of the header).
+func (self *BlockHeader) Hash() Hash {
+	return Hash_writeable(self)
 }
 
-/// Total kernel offset for the chain state up to and including this block.
-func (self *BlockHeader) Total_kernel_offset() -> BlindingFactor {
-	self.Total_kernel_offset
+/// Deserialization of a block header
+func (self *BlockHeader) Read(reader consensus.Reader) error {
+	var err error
+	if self.Version, err = reader.Read_u16(); err != nil {
+		return err
+	}
+	if self.Height, err = reader.Read_u64(); err != nil {
+		return err
+	}
+	if err = self.Previous.Read(reader); err != nil {
+		return err
+	}
+	timestamp, err := reader.Read_i64()
+	if err != nil {
+		return err
+	}
+	if timestamp > (1<<55) || timestamp < -(1<<55) {
+		return consensus.Error{Kind: consensus.CorruptedData}
+	}
+	self.Timestamp = time.Unix(timestamp, 0).UTC()
+	if err = self.Output_root.Read(reader); err != nil {
+		return err
+	}
+	if err = self.Range_proof_root.Read(reader); err != nil {
+		return err
+	}
+	if err = self.Kernel_root.Read(reader); err != nil {
+		return err
+	}
+	if self.Total_kernel_offset, err = consensus.Read_blinding_factor(reader); err != nil {
+		return err
+	}
+	if self.Total_kernel_sum, err = consensus.Read_commitment(reader); err != nil {
+		return err
+	}
+	if self.Output_mmr_size, err = reader.Read_u64(); err != nil {
+		return err
+	}
+	if self.Kernel_mmr_size, err = reader.Read_u64(); err != nil {
+		return err
+	}
+	if err = self.Total_difficulty.Read(reader); err != nil {
+		return err
+	}
+	if self.Nonce, err = reader.Read_u64(); err != nil {
+		return err
+	}
+	return self.Pow.Read(reader)
+}
+
+/// The "overage" to use when verifying the kernel sums.
+/// For a block header the overage is 0 - reward.
+func (self *BlockHeader) Overage() int64 {
+	return -int64(consensus.REWARD)
 }
 
 /// A block as expressed in the MimbleWimble protocol. The reward is
@@ -77,15 +256,264 @@ type Block struct {
 	Kernels []TxKernel
 }
 
+/// Builds a new block from the header of the previous block, a vector of
+/// transactions and the reward output and kernel paying the miner. The
+/// transactions are aggregated (which validates them and applies
+/// cut-through), the block then being given a random proof of work so
+/// block hashing works as expected before mining.
+///
+/// Note: the block itself isn't validated, caller must validate the block as
+/// necessary.
+func New_block(prev *BlockHeader, txs []Transaction, reward_out Output, reward_kern TxKernel, difficulty Difficulty) (Block, error) {
+	// A block is just a big transaction, aggregate as such.
+	agg_tx, err := Aggregate(txs)
+	if err != nil {
+		return Block{}, err
+	}
+
+	// Now add the kernel offset of the previous block for a total
+	total_kernel_offset, err := util.Static_secp_instance().Blind_sum(
+		[]secp.SecretKey{secp.SecretKey(agg_tx.Offset), secp.SecretKey(prev.Total_kernel_offset)}, nil)
+	if err != nil {
+		return Block{}, BlockError{Kind: BlockSecpErr, Msg: err.Error()}
+	}
+
+	inputs := agg_tx.Inputs
+	outputs := sort_outputs(append(agg_tx.Outputs, reward_out))
+	kernels := sort_kernels(append(agg_tx.Kernels, reward_kern))
+
+	// the total kernel sum of the chain, including this block
+	kernel_commits := make([]secp.Commitment, 0, len(kernels)+1)
+	for i := range kernels {
+		kernel_commits = append(kernel_commits, kernels[i].Excess)
+	}
+	kernel_commits = append(kernel_commits, prev.Total_kernel_sum)
+	total_kernel_sum, err := Sum_commits(kernel_commits, nil)
+	if err != nil {
+		return Block{}, block_committed_error(err)
+	}
+
+	header := Default()
+	header.Height = prev.Height + 1
+	header.Previous = prev.Hash()
+	header.Timestamp = time.Unix(time.Now().Unix(), 0).UTC()
+	header.Total_difficulty = prev.Total_difficulty.Add(difficulty)
+	header.Total_kernel_offset = keychain.BlindingFactor(total_kernel_offset)
+	header.Total_kernel_sum = total_kernel_sum
+	// Now set the pow on the header so block hashing works as expected.
+	header.Pow = Random_proof(consensus.Proofsize())
+
+	return Block{
+		Header:  header,
+		Inputs:  inputs,
+		Outputs: outputs,
+		Kernels: kernels,
+	}, nil
+}
+
 /// Sum of all fees (inputs less outputs) in the block
 func (self *Block) Total_fees() uint64 {
-	self.Kernels.iter().map(|p| p.fee).sum()
+	total_fees := uint64(0)
+	for i := range self.Kernels {
+		total_fees += self.Kernels[i].Fee
+	}
+	return total_fees
+}
 
-	total_fees := 0
+/// Validates all the elements in a block that can be checked without
+/// additional data. Includes commitment sums and kernels, reward, etc.
+/// Returns the sum of the kernel excesses of this block on success.
+func (self *Block) Validate(prev_kernel_offset *keychain.BlindingFactor, prev_kernel_sum *secp.Commitment) (secp.Commitment, error) {
+	if err := validate_body(self.Inputs, self.Outputs, self.Kernels, true); err != nil {
+		return secp.Commitment{}, BlockError{Kind: BlockTransactionErr, Msg: err.Error()}
+	}
+	if err := self.verify_kernel_lock_heights(); err != nil {
+		return secp.Commitment{}, err
+	}
+	if err := self.verify_coinbase(); err != nil {
+		return secp.Commitment{}, err
+	}
 
-	for _, kernel := range self.Kernels {
-		total_fees += kernel.Fee
+	// take the kernel offset for this block (block offset minus previous)
+	// and verify the body outputs and kernel sums
+	block_kernel_offset, err := util.Static_secp_instance().Blind_sum(
+		[]secp.SecretKey{secp.SecretKey(self.Header.Total_kernel_offset)},
+		[]secp.SecretKey{secp.SecretKey(*prev_kernel_offset)})
+	if err != nil {
+		return secp.Commitment{}, BlockError{Kind: BlockSecpErr, Msg: err.Error()}
+	}
+	_, kernel_sum, err := self.Verify_kernel_sums(self.Header.Overage(), keychain.BlindingFactor(block_kernel_offset))
+	if err != nil {
+		return secp.Commitment{}, block_committed_error(err)
 	}
 
-	return total_fees
+	// check the block header's total kernel sum
+	total_sum, err := Sum_commits([]secp.Commitment{kernel_sum, *prev_kernel_sum}, nil)
+	if err != nil {
+		return secp.Commitment{}, block_committed_error(err)
+	}
+	if total_sum != self.Header.Total_kernel_sum {
+		return secp.Commitment{}, BlockError{Kind: InvalidTotalKernelSum}
+	}
+	return kernel_sum, nil
+}
+
+/// Validate the coinbase outputs generated by miners.
+/// Check the sum of coinbase-marked outputs match
+/// the sum of coinbase-marked kernels accounting for fees.
+func (self *Block) verify_coinbase() error {
+	cb_outs := []secp.Commitment{}
+	for i := range self.Outputs {
+		if self.Outputs[i].Features&COINBASE_OUTPUT != 0 {
+			cb_outs = append(cb_outs, self.Outputs[i].Commit)
+		}
+	}
+	cb_kerns := []secp.Commitment{}
+	for i := range self.Kernels {
+		if self.Kernels[i].Features&COINBASE_KERNEL != 0 {
+			cb_kerns = append(cb_kerns, self.Kernels[i].Excess)
+		}
+	}
+
+	secp_ctx := util.Static_secp_instance()
+	over_commit, err := secp_ctx.Commit_value(consensus.Reward(self.Total_fees()))
+	if err != nil {
+		return BlockError{Kind: BlockSecpErr, Msg: err.Error()}
+	}
+	out_adjust_sum, err := secp_ctx.Commit_sum(cb_outs, []secp.Commitment{over_commit})
+	if err != nil {
+		return BlockError{Kind: BlockSecpErr, Msg: err.Error()}
+	}
+	kerns_sum, err := secp_ctx.Commit_sum(cb_kerns, nil)
+	if err != nil {
+		return BlockError{Kind: BlockSecpErr, Msg: err.Error()}
+	}
+
+	// Verify the kernel sum equals the output sum accounting for block fees.
+	if kerns_sum != out_adjust_sum {
+		return BlockError{Kind: CoinbaseSumMismatch}
+	}
+	return nil
+}
+
+/// Check we have no kernels with lock_heights greater than current height,
+/// no tx can be included in a block earlier than its lock_height.
+func (self *Block) verify_kernel_lock_heights() error {
+	for i := range self.Kernels {
+		if self.Kernels[i].Lock_height > self.Header.Height {
+			return BlockError{Kind: KernelLockHeight, Msg: fmt.Sprintf("%d", self.Kernels[i].Lock_height)}
+		}
+	}
+	return nil
+}
+
+/// Translates the error of the kernel sums verification.
+func block_committed_error(err error) error {
+	if e, ok := err.(CommittedError); ok {
+		switch e.Kind {
+		case KernelSumMismatch:
+			return BlockError{Kind: BlockKernelSumMismatch}
+		case CommittedKeychainErr:
+			return BlockError{Kind: BlockKeychainErr, Msg: e.Msg}
+		}
+	}
+	return BlockError{Kind: BlockSecpErr, Msg: err.Error()}
+}
+
+/// Implementation of Writeable for a block, defines how to write the block to a
+/// binary writer. Differentiates between writing the block for the purpose of
+/// full serialization and the one of just extracting a hash.
+func (self *Block) Write(writer consensus.Writer) error {
+	if err := self.Header.Write(writer); err != nil {
+		return err
+	}
+	if writer.Serialization_mode() == consensus.SERIALIZATION_HASH {
+		return nil
+	}
+
+	return write_body(writer, self.Inputs, self.Outputs, self.Kernels)
+}
+
+/// Implementation of Readable for a block, defines how to read a full block
+/// from a binary stream.
+func (self *Block) Read(reader consensus.Reader) error {
+	var header BlockHeader
+	if err := header.Read(reader); err != nil {
+		return err
+	}
+	input_len, err := reader.Read_u64()
+	if err != nil {
+		return err
+	}
+	output_len, err := reader.Read_u64()
+	if err != nil {
+		return err
+	}
+	kernel_len, err := reader.Read_u64()
+	if err != nil {
+		return err
+	}
+
+	inputs, outputs, kernels, err := read_body(reader, input_len, output_len, kernel_len)
+	if err != nil {
+		return err
+	}
+
+	self.Header = header
+	self.Inputs = inputs
+	self.Outputs = outputs
+	self.Kernels = kernels
+	return nil
+}
+
+/// Gather the kernel excesses and sum them.
+func (self *Block) Sum_kernel_excesses(offset *keychain.BlindingFactor) (secp.Commitment, secp.Commitment, error) {
+	return Sum_kernel_excesses(self, offset)
+}
+
+/// Gathers commitments and sum them.
+func (self *Block) Sum_commitments(overage int64) (secp.Commitment, error) {
+	return Sum_commitments(self, overage)
+}
+
+/// The commitments of the inputs being spent.
+func (self *Block) Inputs_committed() []secp.Commitment {
+	commits := make([]secp.Commitment, len(self.Inputs))
+	for i := range self.Inputs {
+		commits[i] = self.Inputs[i].Commit
+	}
+	return commits
+}
+
+/// The commitments of the outputs being created.
+func (self *Block) Outputs_committed() []secp.Commitment {
+	commits := make([]secp.Commitment, len(self.Outputs))
+	for i := range self.Outputs {
+		commits[i] = self.Outputs[i].Commit
+	}
+	return commits
+}
+
+/// The kernel excesses.
+func (self *Block) Kernels_committed() []secp.Commitment {
+	commits := make([]secp.Commitment, len(self.Kernels))
+	for i := range self.Kernels {
+		commits[i] = self.Kernels[i].Excess
+	}
+	return commits
+}
+
+/// Verify the kernel sums, the block overage being the (negative) reward.
+func (self *Block) Verify_kernel_sums(overage int64, kernel_offset keychain.BlindingFactor) (secp.Commitment, secp.Commitment, error) {
+	return Verify_kernel_sums(self, overage, kernel_offset)
+}
+
+/// Verify the range proofs of all the block outputs, in a single batch.
+func (self *Block) Verify_rangeproofs() error {
+	return Batch_verify_proofs(self.Outputs)
+}
+
+/// The block hash is the hash of its header.
+func (self *Block) Hash() Hash {
+	return self.Header.Hash()
 }
@@ -0,0 +1,135 @@
+package core
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/kelby/go-grin/util"
+
+	consensus "github.com/kelby/go-grin/core"
+)
+
+/// Builds the coinbase output of the given value and its kernel, the
+/// kernel signing its lock height.
+func build_test_reward(t *testing.T, value uint64, lock_height uint64) (Output, TxKernel) {
+	secp_ctx := util.Static_secp_instance()
+	coin := new_test_coin(value)
+	output := coin.output(t)
+	output.Features = COINBASE_OUTPUT
+
+	excess, err := secp_ctx.Commit(0, coin.blind)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kernel := TxKernel{Features: COINBASE_KERNEL, Lock_height: lock_height, Excess: excess}
+	msg := Kernel_sig_msg(kernel.Fee, kernel.Lock_height)
+	kernel.Excess_sig, err = secp_ctx.Sign_single(&msg, &coin.blind, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return output, kernel
+}
+
+/// Builds a block on top of prev, holding a single transaction and paying
+/// the reward plus its fee.
+func build_test_block(t *testing.T, prev *BlockHeader) Block {
+	tx := build_test_tx(t, []test_coin{new_test_coin(10)}, []test_coin{new_test_coin(7)})
+	reward_out, reward_kern := build_test_reward(t, consensus.Reward(tx.Fee()), prev.Height+1)
+	b, err := New_block(prev, []Transaction{tx}, reward_out, reward_kern, Difficulty_one())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func Test_new_block_validate(t *testing.T) {
+	prev := Default()
+	b := build_test_block(t, &prev)
+	if b.Header.Height != 1 || b.Header.Previous != prev.Hash() {
+		t.Fatalf("block at height %d on %s", b.Header.Height, b.Header.Previous)
+	}
+	if len(b.Inputs) != 1 || len(b.Outputs) != 2 || len(b.Kernels) != 2 || b.Total_fees() != 3 {
+		t.Fatalf("block with %d inputs, %d outputs, %d kernels and %d fees", len(b.Inputs), len(b.Outputs), len(b.Kernels), b.Total_fees())
+	}
+	if _, err := b.Validate(&prev.Total_kernel_offset, &prev.Total_kernel_sum); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_block_coinbase_sum_mismatch(t *testing.T) {
+	prev := Default()
+	tx := build_test_tx(t, []test_coin{new_test_coin(10)}, []test_coin{new_test_coin(7)})
+	reward_out, reward_kern := build_test_reward(t, consensus.Reward(tx.Fee())+1, 1)
+	b, err := New_block(&prev, []Transaction{tx}, reward_out, reward_kern, Difficulty_one())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = b.Validate(&prev.Total_kernel_offset, &prev.Total_kernel_sum)
+	if e, ok := err.(BlockError); !ok || e.Kind != CoinbaseSumMismatch {
+		t.Fatalf("expected a coinbase sum mismatch, got %v", err)
+	}
+}
+
+func Test_block_kernel_lock_height(t *testing.T) {
+	prev := Default()
+	tx := build_test_tx(t, []test_coin{new_test_coin(10)}, []test_coin{new_test_coin(7)})
+	reward_out, reward_kern := build_test_reward(t, consensus.Reward(tx.Fee()), 2)
+	b, err := New_block(&prev, []Transaction{tx}, reward_out, reward_kern, Difficulty_one())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = b.Validate(&prev.Total_kernel_offset, &prev.Total_kernel_sum)
+	if e, ok := err.(BlockError); !ok || e.Kind != KernelLockHeight {
+		t.Fatalf("expected a kernel lock height error, got %v", err)
+	}
+}
+
+func Test_block_chained(t *testing.T) {
+	genesis := Default()
+	b1 := build_test_block(t, &genesis)
+	b2 := build_test_block(t, &b1.Header)
+	if b2.Header.Height != 2 || b2.Header.Previous != b1.Hash() {
+		t.Fatalf("block at height %d on %s", b2.Header.Height, b2.Header.Previous)
+	}
+	if _, err := b2.Validate(&b1.Header.Total_kernel_offset, &b1.Header.Total_kernel_sum); err != nil {
+		t.Fatal(err)
+	}
+
+	// the totals of the header are those of the whole chain
+	if _, err := b2.Validate(&genesis.Total_kernel_offset, &genesis.Total_kernel_sum); err == nil {
+		t.Fatal("block validated against the totals of the wrong previous block")
+	}
+	sum, err := Sum_commits(append(b1.Kernels_committed(), b2.Kernels_committed()...), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum != b2.Header.Total_kernel_sum {
+		t.Fatal("total kernel sum isn't the sum of all the kernels")
+	}
+}
+
+func Test_block_ser_round_trip(t *testing.T) {
+	prev := Default()
+	b := build_test_block(t, &prev)
+	vec, err := consensus.Ser_vec(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var read Block
+	if err := consensus.Deserialize(bytes.NewReader(vec), &read); err != nil {
+		t.Fatal(err)
+	}
+	if read.Hash() != b.Hash() {
+		t.Fatalf("hash %s after a round trip, expected %s", read.Hash(), b.Hash())
+	}
+	if len(read.Inputs) != len(b.Inputs) || len(read.Outputs) != len(b.Outputs) || len(read.Kernels) != len(b.Kernels) {
+		t.Fatal("block body changed by a round trip")
+	}
+	if _, err := read.Validate(&prev.Total_kernel_offset, &prev.Total_kernel_sum); err != nil {
+		t.Fatal(err)
+	}
+	again, _ := consensus.Ser_vec(&read)
+	if !bytes.Equal(again, vec) {
+		t.Fatal("block serialized differently after a round trip")
+	}
+}
@@ -1,29 +1,162 @@
 package core
 
-import "keychain"
+/// The Committed trait and associated errors.
+
+import (
+  "fmt"
+
+  "github.com/kelby/go-grin/keychain"
+  "github.com/kelby/go-grin/secp"
+  "github.com/kelby/go-grin/util"
+)
+
+type CommittedErrorKind int
+
+/// Errors from summing and verifying kernel excesses via committed trait.
+const (
+  /// Keychain related error.
+  CommittedKeychainErr CommittedErrorKind = iota
+  /// Secp related error (typically a malformed commitment).
+  CommittedSecpErr
+  /// Kernel sums do not equal output sums.
+  KernelSumMismatch
+  /// Committed overage (fee or reward) is invalid
+  InvalidValue
+)
+
+/// Error returned when summing or verifying the commitments, the Kind tells
+/// an imbalance apart from a malformed commitment.
+type CommittedError struct {
+  Kind CommittedErrorKind
+  Msg  string
+}
+
+func (self CommittedError) Error() string {
+  switch self.Kind {
+  case CommittedKeychainErr:
+    return fmt.Sprintf("keychain error: %s", self.Msg)
+  case CommittedSecpErr:
+    return fmt.Sprintf("secp error: %s", self.Msg)
+  case KernelSumMismatch:
+    return "kernel sum mismatch"
+  case InvalidValue:
+    return "invalid value"
+  }
+  return "unknown committed error"
+}
 
 /// Implemented by types that hold inputs and outputs (and kernels)
 /// containing Pedersen commitments.
 /// Handles the collection of the commitments as well as their
 /// summing, taking potential explicit overages of fees into account.
+///
+/// The summing methods are all implemented by the Sum_kernel_excesses,
+/// Sum_commitments and Verify_kernel_sums functions, the implementing types
+/// only provide the commitments.
 type Committed interface {
   /// Gather the kernel excesses and sum them.
-  Sum_kernel_excesses(offset *keychain.BlindingFactor) (Commitment, Commitment), error
+  Sum_kernel_excesses(offset *keychain.BlindingFactor) (secp.Commitment, secp.Commitment, error)
 
   /// Gathers commitments and sum them.
-  Sum_commitments(overage int64) (Commitment, error)
+  Sum_commitments(overage int64) (secp.Commitment, error)
 
   /// Vector of input commitments to verify.
-  Inputs_committed []Commitment
+  Inputs_committed() []secp.Commitment
 
   /// Vector of output commitments to verify.
-  Outputs_committed []Commitment
+  Outputs_committed() []secp.Commitment
 
   /// Vector of kernel excesses to verify.
-  Kernels_committed []Commitment
+  Kernels_committed() []secp.Commitment
 
   /// Verify the sum of the kernel excesses equals the
   /// sum of the outputs, taking into account both
   /// the kernel_offset and overage.
-  Verify_kernel_sums(overage int64, kernel_offset keychain.BlindingFactor) (Commitment, Commitment), error
+  Verify_kernel_sums(overage int64, kernel_offset keychain.BlindingFactor) (secp.Commitment, secp.Commitment, error)
+}
+
+/// Gather the kernel excesses and sum them, returning the sum both without
+/// and with the offset added.
+func Sum_kernel_excesses(c Committed, offset *keychain.BlindingFactor) (secp.Commitment, secp.Commitment, error) {
+  kernel_sum, err := Sum_commits(c.Kernels_committed(), nil)
+  if err != nil {
+    return secp.Commitment{}, secp.Commitment{}, err
+  }
+
+  // add the offset in as necessary (unless offset is zero)
+  commits := []secp.Commitment{kernel_sum}
+  if *offset != (keychain.BlindingFactor{}) {
+    key, err := offset.Secret_key()
+    if err != nil {
+      return secp.Commitment{}, secp.Commitment{}, CommittedError{Kind: CommittedKeychainErr, Msg: err.Error()}
+    }
+    offset_commit, err := util.Static_secp_instance().Commit(0, key)
+    if err != nil {
+      return secp.Commitment{}, secp.Commitment{}, CommittedError{Kind: CommittedSecpErr, Msg: err.Error()}
+    }
+    commits = append(commits, offset_commit)
+  }
+  kernel_sum_plus_offset, err := Sum_commits(commits, nil)
+  if err != nil {
+    return secp.Commitment{}, secp.Commitment{}, err
+  }
+  return kernel_sum, kernel_sum_plus_offset, nil
+}
+
+/// Gathers commitments and sum them, the overage (fee or reward) being
+/// committed to with a zero blinding factor.
+func Sum_commitments(c Committed, overage int64) (secp.Commitment, error) {
+  input_commits := c.Inputs_committed()
+  output_commits := c.Outputs_committed()
+
+  // add the overage as output commitment if positive,
+  // or as an input commitment if negative
+  if overage != 0 {
+    abs := uint64(overage)
+    if overage < 0 {
+      abs = uint64(-overage)
+    }
+    over_commit, err := util.Static_secp_instance().Commit_value(abs)
+    if err != nil {
+      return secp.Commitment{}, CommittedError{Kind: CommittedSecpErr, Msg: err.Error()}
+    }
+    if overage < 0 {
+      input_commits = append(input_commits, over_commit)
+    } else {
+      output_commits = append(output_commits, over_commit)
+    }
+  }
+
+  return Sum_commits(output_commits, input_commits)
+}
+
+/// Verify the sum of the kernel excesses equals the sum of the outputs minus
+/// the inputs, taking into account both the kernel offset and overage.
+/// Returns the utxo sum and the kernel sum.
+func Verify_kernel_sums(c Committed, overage int64, kernel_offset keychain.BlindingFactor) (secp.Commitment, secp.Commitment, error) {
+  // Sum all input|output|overage commitments.
+  utxo_sum, err := Sum_commitments(c, overage)
+  if err != nil {
+    return secp.Commitment{}, secp.Commitment{}, err
+  }
+
+  // Sum the kernel excesses accounting for the kernel offset.
+  kernel_sum, kernel_sum_plus_offset, err := Sum_kernel_excesses(c, &kernel_offset)
+  if err != nil {
+    return secp.Commitment{}, secp.Commitment{}, err
+  }
+
+  if utxo_sum != kernel_sum_plus_offset {
+    return secp.Commitment{}, secp.Commitment{}, CommittedError{Kind: KernelSumMismatch}
+  }
+  return utxo_sum, kernel_sum, nil
+}
+
+/// Utility to sum positive and negative commitments, eliminating zero values
+func Sum_commits(positive []secp.Commitment, negative []secp.Commitment) (secp.Commitment, error) {
+  sum, err := util.Static_secp_instance().Commit_sum(positive, negative)
+  if err != nil {
+    return secp.Commitment{}, CommittedError{Kind: CommittedSecpErr, Msg: err.Error()}
+  }
+  return sum, nil
 }
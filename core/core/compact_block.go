@@ -0,0 +1,302 @@
+package core
+
+/// Compact Blocks.
+
+import (
+  "crypto/rand"
+  "encoding/binary"
+  "sort"
+
+  ser "github.com/kelby/go-grin/core"
+)
+
+/// Container for full outputs and kernels and kern_ids for a compact
+/// block. Only the coinbase outputs and kernels are sent in full, the other
+/// kernels are identified by their short id, the receiving node hydrating
+/// the block from the transactions in its pool.
+type CompactBlock struct {
+  /// The header with metadata and commitments to the rest of the data
+  Header BlockHeader
+  /// Nonce for connection specific short_ids
+  Nonce uint64
+  /// List of full outputs - specifically the coinbase output(s)
+  Out_full []Output
+  /// List of full kernels - specifically the coinbase kernel(s)
+  Kern_full []TxKernel
+  /// List of transaction kernels, excluding those in the full list
+  /// (short_ids)
+  Kern_ids []ShortId
+}
+
+/// Builds the compact version of the block, with a random nonce for the
+/// short ids.
+func (self *Block) As_compact_block() CompactBlock {
+  var buf [8]byte
+  if _, err := rand.Read(buf[:]); err != nil {
+    panic(err)
+  }
+  return self.As_compact_block_with_nonce(binary.LittleEndian.Uint64(buf[:]))
+}
+
+/// Builds the compact version of the block with the given nonce.
+func (self *Block) As_compact_block_with_nonce(nonce uint64) CompactBlock {
+  header := self.Header
+  header_hash := header.Hash()
+
+  out_full := []Output{}
+  for i := range self.Outputs {
+    if self.Outputs[i].Features&COINBASE_OUTPUT != 0 {
+      out_full = append(out_full, self.Outputs[i])
+    }
+  }
+
+  kern_full := []TxKernel{}
+  kern_ids := []ShortId{}
+  for i := range self.Kernels {
+    // if it is a coinbase kernel include it in the list of full kernels
+    if self.Kernels[i].Features&COINBASE_KERNEL != 0 {
+      kern_full = append(kern_full, self.Kernels[i])
+    } else {
+      kern_ids = append(kern_ids, self.Kernels[i].Short_id(&header_hash, nonce))
+    }
+  }
+
+  return CompactBlock{
+    Header:    header,
+    Nonce:     nonce,
+    Out_full:  sort_outputs(out_full),
+    Kern_full: sort_kernels(kern_full),
+    Kern_ids:  sort_short_ids(kern_ids),
+  }
+}
+
+/// Hydrate a block from a compact block.
+/// Note: caller must validate the block and handle the case where some of
+/// the kernels weren't found (the block then fails validation and the full
+/// block needs to be requested).
+func Hydrate_from(cb *CompactBlock, txs []Transaction) Block {
+  inputs := []Input{}
+  outputs := []Output{}
+  kernels := []TxKernel{}
+  seen_inputs := make(map[Input]bool)
+  seen_outputs := make(map[Hash]bool)
+  seen_kernels := make(map[TxKernel]bool)
+  add_output := func(output Output) {
+    if h := output.Hash(); !seen_outputs[h] {
+      seen_outputs[h] = true
+      outputs = append(outputs, output)
+    }
+  }
+  add_kernel := func(kernel TxKernel) {
+    if !seen_kernels[kernel] {
+      seen_kernels[kernel] = true
+      kernels = append(kernels, kernel)
+    }
+  }
+
+  // collect all the inputs, outputs and kernels from the txs
+  for i := range txs {
+    for _, input := range txs[i].Inputs {
+      if !seen_inputs[input] {
+        seen_inputs[input] = true
+        inputs = append(inputs, input)
+      }
+    }
+    for _, output := range txs[i].Outputs {
+      add_output(output)
+    }
+    for _, kernel := range txs[i].Kernels {
+      add_kernel(kernel)
+    }
+  }
+
+  // include the coinbase output(s) and kernel(s) from the compact_block
+  for _, output := range cb.Out_full {
+    add_output(output)
+  }
+  for _, kernel := range cb.Kern_full {
+    add_kernel(kernel)
+  }
+
+  // finally return the full block
+  // Note: we have not actually validated the block here
+  // leave it to the caller to actually validate the block
+  block := Block{
+    Header:  cb.Header,
+    Inputs:  sort_inputs(inputs),
+    Outputs: sort_outputs(outputs),
+    Kernels: sort_kernels(kernels),
+  }
+  return block.Cut_through()
+}
+
+/// Matches any output with a potential spending input, eliminating them
+/// from the block. Provides a simple way to cut-through the block. The
+/// elimination is stable with respect to the order of inputs and outputs.
+func (self Block) Cut_through() Block {
+  in_set := make(map[Hash]bool, len(self.Inputs))
+  for i := range self.Inputs {
+    in_set[self.Inputs[i].Hash()] = true
+  }
+  out_set := make(map[Hash]bool, len(self.Outputs))
+  for i := range self.Outputs {
+    out_set[self.Outputs[i].Hash()] = true
+  }
+
+  inputs := []Input{}
+  for i := range self.Inputs {
+    if !out_set[self.Inputs[i].Hash()] {
+      inputs = append(inputs, self.Inputs[i])
+    }
+  }
+  outputs := []Output{}
+  for i := range self.Outputs {
+    if !in_set[self.Outputs[i].Hash()] {
+      outputs = append(outputs, self.Outputs[i])
+    }
+  }
+
+  self.Inputs = inputs
+  self.Outputs = outputs
+  return self
+}
+
+/// The hash of the compact block is the hash of its header.
+func (self *CompactBlock) Hash() Hash {
+  return self.Header.Hash()
+}
+
+/// Implementation of Writeable for a compact block, defines how to write the
+/// block to a binary writer. Differentiates between writing the block for
+/// the purpose of full serialization and the one of just extracting a hash.
+func (self *CompactBlock) Write(writer ser.Writer) error {
+  if err := self.Header.Write(writer); err != nil {
+    return err
+  }
+  if writer.Serialization_mode() == ser.SERIALIZATION_HASH {
+    return nil
+  }
+
+  if err := writer.Write_u64(self.Nonce); err != nil {
+    return err
+  }
+  if err := writer.Write_u64(uint64(len(self.Out_full))); err != nil {
+    return err
+  }
+  if err := writer.Write_u64(uint64(len(self.Kern_full))); err != nil {
+    return err
+  }
+  if err := writer.Write_u64(uint64(len(self.Kern_ids))); err != nil {
+    return err
+  }
+
+  // Consensus rule that everything is sorted in lexicographical order on the
+  // wire.
+  out_full := sort_outputs(self.Out_full)
+  for i := range out_full {
+    if err := out_full[i].Write(writer); err != nil {
+      return err
+    }
+  }
+  kern_full := sort_kernels(self.Kern_full)
+  for i := range kern_full {
+    if err := kern_full[i].Write(writer); err != nil {
+      return err
+    }
+  }
+  kern_ids := sort_short_ids(self.Kern_ids)
+  for i := range kern_ids {
+    if err := kern_ids[i].Write(writer); err != nil {
+      return err
+    }
+  }
+  return nil
+}
+
+/// Implementation of Readable for a compact block, defines how to read a
+/// compact block from a binary stream.
+func (self *CompactBlock) Read(reader ser.Reader) error {
+  var header BlockHeader
+  if err := header.Read(reader); err != nil {
+    return err
+  }
+  nonce, err := reader.Read_u64()
+  if err != nil {
+    return err
+  }
+  out_full_len, err := reader.Read_u64()
+  if err != nil {
+    return err
+  }
+  kern_full_len, err := reader.Read_u64()
+  if err != nil {
+    return err
+  }
+  kern_id_len, err := reader.Read_u64()
+  if err != nil {
+    return err
+  }
+
+  out_full := []Output{}
+  err = ser.Read_multi(reader, out_full_len, func(reader ser.Reader) error {
+    var output Output
+    if err := output.Read(reader); err != nil {
+      return err
+    }
+    out_full = append(out_full, output)
+    return nil
+  })
+  if err != nil {
+    return err
+  }
+
+  kern_full := []TxKernel{}
+  err = ser.Read_multi(reader, kern_full_len, func(reader ser.Reader) error {
+    var kernel TxKernel
+    if err := kernel.Read(reader); err != nil {
+      return err
+    }
+    kern_full = append(kern_full, kernel)
+    return nil
+  })
+  if err != nil {
+    return err
+  }
+
+  kern_ids := []ShortId{}
+  err = ser.Read_multi(reader, kern_id_len, func(reader ser.Reader) error {
+    var id ShortId
+    if err := id.Read(reader); err != nil {
+      return err
+    }
+    kern_ids = append(kern_ids, id)
+    return nil
+  })
+  if err != nil {
+    return err
+  }
+
+  if err := verify_sort_order(len(out_full), func(i int) Hash { return out_full[i].Hash() }); err != nil {
+    return err
+  }
+  if err := verify_sort_order(len(kern_full), func(i int) Hash { return kern_full[i].Hash() }); err != nil {
+    return err
+  }
+  if err := verify_sort_order(len(kern_ids), func(i int) Hash { return kern_ids[i].Hash() }); err != nil {
+    return err
+  }
+
+  self.Header = header
+  self.Nonce = nonce
+  self.Out_full = out_full
+  self.Kern_full = kern_full
+  self.Kern_ids = kern_ids
+  return nil
+}
+
+/// Returns a copy of the short ids sorted by hash.
+func sort_short_ids(ids []ShortId) []ShortId {
+  sorted := append([]ShortId{}, ids...)
+  sort.Slice(sorted, func(i, j int) bool { return sorted[i].Hash().Less(sorted[j].Hash()) })
+  return sorted
+}
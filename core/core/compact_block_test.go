@@ -0,0 +1,63 @@
+package core
+
+import (
+  "testing"
+
+  consensus "github.com/kelby/go-grin/core"
+)
+
+/// Builds a block holding the given transactions on top of the default
+/// header.
+func build_test_block_with_txs(t *testing.T, txs []Transaction) Block {
+  prev := Default()
+  fees := uint64(0)
+  for i := range txs {
+    fees += txs[i].Fee()
+  }
+  reward_out, reward_kern := build_test_reward(t, consensus.Reward(fees), 1)
+  b, err := New_block(&prev, txs, reward_out, reward_kern, Difficulty_one())
+  if err != nil {
+    t.Fatal(err)
+  }
+  return b
+}
+
+func Test_compact_block_hydrate(t *testing.T) {
+  tx1 := build_test_tx(t, []test_coin{new_test_coin(10)}, []test_coin{new_test_coin(7)})
+  tx2 := build_test_tx(t, []test_coin{new_test_coin(20)}, []test_coin{new_test_coin(11), new_test_coin(8)})
+  b := build_test_block_with_txs(t, []Transaction{tx1, tx2})
+  prev := Default()
+
+  cb := b.As_compact_block_with_nonce(42)
+  if cb.Hash() != b.Hash() || cb.Nonce != 42 {
+    t.Fatal("compact block doesn't match its block")
+  }
+  // only the coinbase output and kernel are sent in full
+  if len(cb.Out_full) != 1 || len(cb.Kern_full) != 1 || len(cb.Kern_ids) != 2 {
+    t.Fatalf("compact block with %d full outputs, %d full kernels and %d kernel ids", len(cb.Out_full), len(cb.Kern_full), len(cb.Kern_ids))
+  }
+  header_hash := b.Hash()
+  for _, tx := range []Transaction{tx1, tx2} {
+    id := tx.Kernels[0].Short_id(&header_hash, cb.Nonce)
+    if id != cb.Kern_ids[0] && id != cb.Kern_ids[1] {
+      t.Fatalf("no kernel id %s in the compact block", id)
+    }
+  }
+
+  full := Hydrate_from(&cb, []Transaction{tx2, tx1})
+  if full.Hash() != b.Hash() {
+    t.Fatalf("hydrated block hash %s, expected %s", full.Hash(), b.Hash())
+  }
+  if len(full.Inputs) != len(b.Inputs) || len(full.Outputs) != len(b.Outputs) || len(full.Kernels) != len(b.Kernels) {
+    t.Fatal("hydrated block body differs")
+  }
+  if _, err := full.Validate(&prev.Total_kernel_offset, &prev.Total_kernel_sum); err != nil {
+    t.Fatal(err)
+  }
+
+  // a transaction missing from the pool leaves the block invalid
+  partial := Hydrate_from(&cb, []Transaction{tx1})
+  if _, err := partial.Validate(&prev.Total_kernel_offset, &prev.Total_kernel_sum); err == nil {
+    t.Fatal("block hydrated without one of its transactions validated")
+  }
+}
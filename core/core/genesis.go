@@ -0,0 +1,66 @@
+package core
+
+/// Definition of the genesis block. Placeholder for now.
+
+import (
+  "time"
+
+  global "github.com/kelby/go-grin/core"
+)
+
+/// Genesis block definition for development networks. The proof of work size
+/// is small enough to mine it on the fly for tests.
+func Genesis_dev() Block {
+  header := Default()
+  header.Height = 0
+  header.Previous = genesis_previous()
+  header.Timestamp = time.Date(1997, 8, 4, 0, 0, 0, 0, time.UTC)
+  header.Nonce = global.Get_genesis_nonce()
+  return Block{Header: header}
+}
+
+/// Testnet genesis block. Its proof of work gets filled in once mined for
+/// the network launch.
+func Genesis_testnet() Block {
+  header := Default()
+  header.Height = 0
+  header.Previous = genesis_previous()
+  header.Timestamp = time.Date(2018, 7, 8, 18, 0, 0, 0, time.UTC)
+  header.Total_difficulty = Difficulty_from_num(global.Initial_block_difficulty())
+  header.Nonce = global.Get_genesis_nonce()
+  header.Pow = Zero_proof(global.Proofsize())
+  return Block{Header: header}
+}
+
+/// Placeholder for mainnet genesis block, will definitely change before
+/// release so no use trying to pre-mine it.
+func Genesis_main() Block {
+  header := Default()
+  header.Height = 0
+  header.Previous = genesis_previous()
+  header.Timestamp = time.Date(2018, 8, 14, 0, 0, 0, 0, time.UTC)
+  header.Total_difficulty = Difficulty_from_num(global.Initial_block_difficulty())
+  header.Nonce = global.Get_genesis_nonce()
+  header.Pow = Zero_proof(global.Proofsize())
+  return Block{Header: header}
+}
+
+/// The genesis block of the chain type currently set.
+func Genesis_block() Block {
+  switch global.Chain_type() {
+  case global.AutomatedTesting, global.UserTesting:
+    return Genesis_dev()
+  case global.Testnet:
+    return Genesis_testnet()
+  }
+  return Genesis_main()
+}
+
+/// The genesis block has no previous block, its previous hash is all 0xff.
+func genesis_previous() Hash {
+  var h Hash
+  for i := range h {
+    h[i] = 0xff
+  }
+  return h
+}
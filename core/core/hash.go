@@ -1,6 +1,175 @@
 package core
 
-type Hash [32]byte
+/// Hash Function
+///
+/// Primary hash function used in the protocol
+
+import (
+  "bytes"
+  "encoding/binary"
+  "encoding/hex"
+  "hash"
+
+  "golang.org/x/crypto/blake2b"
+
+  ser "github.com/kelby/go-grin/core"
+)
 
 /// A hash consisting of all zeroes, used as a sentinel. No known preimage.
-ZERO_HASH := Hash{}
+var ZERO_HASH = Hash{}
+
+/// A hash to uniquely (or close enough) identify one of the main blockchain
+/// constructs. Used pervasively for blocks, transactions and outputs.
+type Hash [32]byte
+
+/// Convert a hash to hex string format.
+func (self Hash) To_hex() string {
+  return hex.EncodeToString(self[:])
+}
+
+/// Convert hex string back to hash.
+func From_hex(hex_str string) (Hash, error) {
+  var h Hash
+  bytes, err := hex.DecodeString(hex_str)
+  if err != nil {
+    return h, ser.Error{Kind: ser.HexError, Msg: err.Error()}
+  }
+  if len(bytes) != len(h) {
+    return h, ser.Error{Kind: ser.HexError, Msg: "invalid hash length"}
+  }
+  copy(h[:], bytes)
+  return h, nil
+}
+
+/// Lexicographical ordering of hashes, used to sort inputs, outputs and
+/// kernels on the wire.
+func (self Hash) Less(other Hash) bool {
+  return bytes.Compare(self[:], other[:]) < 0
+}
+
+/// Implements String() interface, the hash as hex.
+func (self Hash) String() string {
+  return self.To_hex()
+}
+
+/// Serializes the hash as its 32 raw bytes.
+func (self *Hash) Write(writer ser.Writer) error {
+  return writer.Write_fixed_bytes(self[:])
+}
+
+/// Reads a hash from its 32 raw bytes.
+func (self *Hash) Read(reader ser.Reader) error {
+  bytes, err := reader.Read_fixed_bytes(32)
+  if err != nil {
+    return err
+  }
+  copy(self[:], bytes)
+  return nil
+}
+
+/// Hash the provided writeable together with this hash, as a (self, other)
+/// tuple.
+func (self Hash) Hash_with(other ser.Writeable) Hash {
+  hasher := New_hash_writer()
+  hasher.Write_fixed_bytes(self[:])
+  other.Write(hasher)
+  return hasher.Finalize()
+}
+
+/// Serializer that outputs a hash of the serialized object
+type HashWriter struct {
+  state hash.Hash
+}
+
+/// A new HashWriter, backed by a 32 bytes blake2b state.
+func New_hash_writer() *HashWriter {
+  state, _ := blake2b.New256(nil)
+  return &HashWriter{state: state}
+}
+
+/// Consume the HashWriter, outputting its current hash.
+func (self *HashWriter) Finalize() Hash {
+  var h Hash
+  copy(h[:], self.state.Sum(nil))
+  return h
+}
+
+func (self *HashWriter) Serialization_mode() ser.SerializationMode {
+  return ser.SERIALIZATION_HASH
+}
+
+func (self *HashWriter) Write_u8(n uint8) error {
+  return self.Write_fixed_bytes([]byte{n})
+}
+
+func (self *HashWriter) Write_u16(n uint16) error {
+  var buf [2]byte
+  binary.BigEndian.PutUint16(buf[:], n)
+  return self.Write_fixed_bytes(buf[:])
+}
+
+func (self *HashWriter) Write_u32(n uint32) error {
+  var buf [4]byte
+  binary.BigEndian.PutUint32(buf[:], n)
+  return self.Write_fixed_bytes(buf[:])
+}
+
+func (self *HashWriter) Write_u64(n uint64) error {
+  var buf [8]byte
+  binary.BigEndian.PutUint64(buf[:], n)
+  return self.Write_fixed_bytes(buf[:])
+}
+
+func (self *HashWriter) Write_i64(n int64) error {
+  return self.Write_u64(uint64(n))
+}
+
+func (self *HashWriter) Write_bytes(bytes []byte) error {
+  self.Write_u64(uint64(len(bytes)))
+  return self.Write_fixed_bytes(bytes)
+}
+
+func (self *HashWriter) Write_fixed_bytes(fixed []byte) error {
+  self.state.Write(fixed)
+  return nil
+}
+
+/// A trait for types that have a canonical hash
+type Hashed interface {
+  /// Obtain the hash of the object
+  Hash() Hash
+}
+
+/// Hashes any writeable with blake2b-256, in hash serialization mode. This is
+/// the implementation backing all the Hashed types.
+func Hash_writeable(thing ser.Writeable) Hash {
+  hasher := New_hash_writer()
+  // writing to a HashWriter never fails
+  thing.Write(hasher)
+  return hasher.Finalize()
+}
+
+/// Trait for types that get hashed with their position (index) in the MMR,
+/// the hash being the one of the (index, self) tuple.
+type PMMRIndexHashable interface {
+  /// Hash with a given index
+  Hash_with_index(index uint64) Hash
+}
+
+/// Hashes a writeable together with its MMR index.
+func Hash_with_index(thing ser.Writeable, index uint64) Hash {
+  hasher := New_hash_writer()
+  hasher.Write_u64(index)
+  thing.Write(hasher)
+  return hasher.Finalize()
+}
+
+/// Hash of the hash, so a Hash is Hashed itself.
+func (self Hash) Hash() Hash {
+  return Hash_writeable(&self)
+}
+
+/// Hash with a given index, used for the MMR parents.
+func (self Hash) Hash_with_index(index uint64) Hash {
+  return Hash_with_index(&self, index)
+}
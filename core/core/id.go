@@ -1,3 +1,104 @@
+package core
+
+/// short ids for compact blocks
+
+import (
+  "encoding/binary"
+  "encoding/hex"
+
+  "github.com/dchest/siphash"
+
+  ser "github.com/kelby/go-grin/core"
+)
+
+/// The size of a short id used to identify inputs|outputs|kernels (6 bytes)
+const SHORT_ID_SIZE = 6
+
 /// Short id for identifying inputs/outputs/kernels
 // #[derive(Clone, Serialize, Deserialize)]
-type ShortId [uint8]6
+type ShortId [SHORT_ID_SIZE]uint8
+
+/// A trait for types that have a short_id (inputs/outputs/kernels)
+type ShortIdentifiable interface {
+  /// The short_id of a kernel uses a hash built from the block_header *and* a
+  /// connection specific nonce to minimize the effect of collisions.
+  Short_id(hash *Hash, nonce uint64) ShortId
+}
+
+/// Computes the short id of a hashed element. The block hash and the nonce
+/// are hashed together, the first 16 bytes of the result being the two
+/// (little endian) siphash keys the hash of the element is hashed with.
+func Short_id(hashed Hashed, hash *Hash, nonce uint64) ShortId {
+  // take the block hash and the nonce and hash them together
+  hasher := New_hash_writer()
+  hasher.Write_fixed_bytes(hash[:])
+  hasher.Write_u64(nonce)
+  hash_with_nonce := hasher.Finalize()
+
+  // we "use" the first 16 bytes of the hash as k0 and k1 for siphash
+  k0 := binary.LittleEndian.Uint64(hash_with_nonce[0:8])
+  k1 := binary.LittleEndian.Uint64(hash_with_nonce[8:16])
+
+  // SipHash24 the hash of the element with the keys
+  h := hashed.Hash()
+  res := siphash.Hash(k0, k1, h[:])
+
+  // construct a short_id from the resulting bytes (only the first 6 bytes)
+  var buf [8]byte
+  binary.LittleEndian.PutUint64(buf[:], res)
+  return Short_id_from_bytes(buf[:SHORT_ID_SIZE])
+}
+
+/// Build a new short_id from a byte slice
+func Short_id_from_bytes(bytes []byte) ShortId {
+  var id ShortId
+  copy(id[:], bytes)
+  return id
+}
+
+/// Hex string representation of a short_id
+func (self ShortId) To_hex() string {
+  return hex.EncodeToString(self[:])
+}
+
+/// Reconstructs a short_id from a hex string.
+func Short_id_from_hex(hex_str string) (ShortId, error) {
+  bytes, err := hex.DecodeString(hex_str)
+  if err != nil {
+    return ShortId{}, ser.Error{Kind: ser.HexError, Msg: err.Error()}
+  }
+  if len(bytes) != SHORT_ID_SIZE {
+    return ShortId{}, ser.Error{Kind: ser.HexError, Msg: "invalid short id length"}
+  }
+  return Short_id_from_bytes(bytes), nil
+}
+
+/// The zero short_id, convenient for generating a short_id for testing.
+func Short_id_zero() ShortId {
+  return ShortId{}
+}
+
+/// Implements String() interface, the short id as hex.
+func (self ShortId) String() string {
+  return self.To_hex()
+}
+
+/// Serializes the short id as its raw bytes.
+func (self *ShortId) Write(writer ser.Writer) error {
+  return writer.Write_fixed_bytes(self[:])
+}
+
+/// Reads a short id from its raw bytes.
+func (self *ShortId) Read(reader ser.Reader) error {
+  bytes, err := reader.Read_fixed_bytes(SHORT_ID_SIZE)
+  if err != nil {
+    return err
+  }
+  copy(self[:], bytes)
+  return nil
+}
+
+/// Short ids are hashed like any other writeable, used for sorting.
+func (self *ShortId) Hash() Hash {
+  return Hash_writeable(self)
+}
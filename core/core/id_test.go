@@ -0,0 +1,52 @@
+package core
+
+import (
+  "testing"
+
+  ser "github.com/kelby/go-grin/core"
+)
+
+/// A u64 hashed the default way, as in the short_id tests of grin.
+type test_foo uint64
+
+func (self test_foo) Write(writer ser.Writer) error {
+  return writer.Write_u64(uint64(self))
+}
+
+func (self test_foo) Hash() Hash {
+  return Hash_writeable(self)
+}
+
+func Test_short_id(t *testing.T) {
+  foo_hash := "81e47a19e6b29b0a65b9591762ce5143ed30d0261e5d24a3201752506b20f15c"
+  if h := test_foo(0).Hash(); h.To_hex() != foo_hash {
+    t.Fatalf("hash of Foo(0) %s, expected %s", h, foo_hash)
+  }
+  if h := test_foo(5).Hash(); h.To_hex() != "3a42e66e46dd7633b57d1f921780a1ac715e6b93c19ee52ab714178eb3a9f673" {
+    t.Fatalf("wrong hash of Foo(5) %s", h)
+  }
+  other_hash, err := From_hex(foo_hash)
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  tests := []struct {
+    foo   test_foo
+    hash  Hash
+    nonce uint64
+    id    string
+  }{
+    {0, ZERO_HASH, 0, "4cc808b62476"},
+    {5, ZERO_HASH, 5, "02955a094534"},
+    {5, other_hash, 5, "3e9cde72a687"},
+  }
+  for _, test := range tests {
+    expected, err := Short_id_from_hex(test.id)
+    if err != nil {
+      t.Fatal(err)
+    }
+    if id := Short_id(test.foo, &test.hash, test.nonce); id != expected {
+      t.Errorf("short_id of Foo(%d) with %s and nonce %d = %s, expected %s", test.foo, test.hash, test.nonce, id, test.id)
+    }
+  }
+}
@@ -1,5 +1,35 @@
 package core
 
+import (
+  "bytes"
+  "encoding/hex"
+  "fmt"
+
+  ser "github.com/kelby/go-grin/core"
+)
+
+/// Merkle proof errors.
+type MerkleProofErrorKind int
+
+const (
+  /// Merkle proof root hash does not match when attempting to verify.
+  RootMismatch MerkleProofErrorKind = iota
+)
+
+/// Error returned when a Merkle proof fails to verify.
+type MerkleProofError struct {
+  Kind MerkleProofErrorKind
+  Msg  string
+}
+
+func (self MerkleProofError) Error() string {
+  switch self.Kind {
+  case RootMismatch:
+    return "merkle proof root hash mismatch"
+  }
+  return "unknown merkle proof error"
+}
+
 /// A Merkle proof that proves a particular element exists in the MMR.
 // #[derive(Serialize, Deserialize, Debug, Eq, PartialEq, Clone, PartialOrd, Ord)]
 type MerkleProof struct {
@@ -9,3 +39,144 @@ type MerkleProof struct {
   /// root.
   Path []Hash
 }
+
+/// Builds an empty merkle proof.
+func Merkle_proof_empty() MerkleProof {
+  return MerkleProof{Mmr_size: 0, Path: []Hash{}}
+}
+
+func (self *MerkleProof) Write(writer ser.Writer) error {
+  if err := writer.Write_u64(self.Mmr_size); err != nil {
+    return err
+  }
+  if err := writer.Write_u64(uint64(len(self.Path))); err != nil {
+    return err
+  }
+  for i := range self.Path {
+    if err := self.Path[i].Write(writer); err != nil {
+      return err
+    }
+  }
+  return nil
+}
+
+func (self *MerkleProof) Read(reader ser.Reader) error {
+  mmr_size, err := reader.Read_u64()
+  if err != nil {
+    return err
+  }
+  path_len, err := reader.Read_u64()
+  if err != nil {
+    return err
+  }
+
+  path := []Hash{}
+  err = ser.Read_multi(reader, path_len, func(reader ser.Reader) error {
+    var hash Hash
+    if err := hash.Read(reader); err != nil {
+      return err
+    }
+    path = append(path, hash)
+    return nil
+  })
+  if err != nil {
+    return err
+  }
+
+  self.Mmr_size = mmr_size
+  self.Path = path
+  return nil
+}
+
+/// Serialize the Merkle proof as a hex string (for api json endpoints)
+func (self *MerkleProof) To_hex() string {
+  vec, err := ser.Ser_vec(self)
+  if err != nil {
+    panic(fmt.Sprintf("failed to serialize merkle proof: %s", err))
+  }
+  return hex.EncodeToString(vec)
+}
+
+/// Convert hex string representation back to a Merkle proof instance
+func Merkle_proof_from_hex(hex_str string) (MerkleProof, error) {
+  var proof MerkleProof
+  vec, err := hex.DecodeString(hex_str)
+  if err != nil {
+    return proof, ser.Error{Kind: ser.HexError, Msg: err.Error()}
+  }
+  if err := ser.Deserialize(bytes.NewReader(vec), &proof); err != nil {
+    return proof, ser.Error{Kind: ser.HexError, Msg: err.Error()}
+  }
+  return proof, nil
+}
+
+/// Verifies the Merkle proof against the provided
+/// root hash, element and position in the MMR.
+func (self *MerkleProof) Verify(root Hash, element PMMRIndexHashable, node_pos uint64) error {
+  path := make([]Hash, len(self.Path))
+  copy(path, self.Path)
+  peaks := Peaks(self.Mmr_size)
+  return self.verify_consume(root, element, node_pos, peaks, path)
+}
+
+/// Consumes the Merkle proof while verifying it, one sibling at a time
+/// going up the tree and then through the peaks.
+func (self *MerkleProof) verify_consume(root Hash, element PMMRIndexHashable, node_pos uint64, peaks []uint64, path []Hash) error {
+  var node_hash Hash
+  if node_pos > self.Mmr_size {
+    node_hash = element.Hash_with_index(self.Mmr_size)
+  } else {
+    node_hash = element.Hash_with_index(node_pos - 1)
+  }
+
+  // handle special case of only a single entry in the MMR
+  // (no siblings to hash together)
+  if len(path) == 0 {
+    if root == node_hash {
+      return nil
+    }
+    return MerkleProofError{Kind: RootMismatch}
+  }
+
+  sibling := path[0]
+  path = path[1:]
+
+  parent_pos, sibling_pos := Family(node_pos)
+
+  var parent hash_pair
+  if x := peak_index(peaks, node_pos); x >= 0 {
+    if x == len(peaks)-1 {
+      parent = hash_pair{sibling, node_hash}
+    } else {
+      parent = hash_pair{node_hash, sibling}
+    }
+  } else if parent_pos > self.Mmr_size {
+    parent = hash_pair{sibling, node_hash}
+  } else if Is_left_sibling(sibling_pos) {
+    parent = hash_pair{sibling, node_hash}
+  } else {
+    parent = hash_pair{node_hash, sibling}
+  }
+  return self.verify_consume(root, &parent, parent_pos, peaks, path)
+}
+
+/// Index of pos in the peaks, -1 if it isn't one of them.
+func peak_index(peaks []uint64, pos uint64) int {
+  for i, peak := range peaks {
+    if peak == pos {
+      return i
+    }
+  }
+  return -1
+}
+
+/// A pair of (left, right) child hashes, hashed together with the parent
+/// index.
+type hash_pair struct {
+  left  Hash
+  right Hash
+}
+
+func (self *hash_pair) Hash_with_index(index uint64) Hash {
+  return hash_children(self.left, self.right, index)
+}
@@ -1,6 +1,12 @@
 package core
 
-import "target"
+import (
+  "math/rand"
+  "sort"
+
+  consensus "github.com/kelby/go-grin/core"
+)
+
 /// A Cuckoo Cycle proof of work, consisting of the shift to get the graph
 /// size (i.e. 31 for Cuckoo31 with a 2^31 or 1<<31 graph size) and the nonces
 /// of the graph solution. While being expressed as u64 for simplicity, each
@@ -18,10 +24,117 @@ type Proof struct {
   Nonces []uint64
 }
 
-type Proof interface {
-  New(in_nonces []uint64) Proof
-  Zero(proof_size usize) Proof
-  Random(proof_size usize) Proof
-  To_difficulty() target.Difficulty
-  Proof_size() usize
+/// Builds a proof with provided nonces at the minimum sizeshift
+func New_proof(in_nonces []uint64) Proof {
+  nonces := append([]uint64{}, in_nonces...)
+  sort.Slice(nonces, func(i, j int) bool { return nonces[i] < nonces[j] })
+  return Proof{Cuckoo_sizeshift: consensus.Min_sizeshift(), Nonces: nonces}
+}
+
+/// Builds a proof with all bytes zeroed out
+func Zero_proof(proof_size int) Proof {
+  return Proof{Cuckoo_sizeshift: consensus.Min_sizeshift(), Nonces: make([]uint64, proof_size)}
+}
+
+/// Builds a proof with random POW data, needed so that tests that ignore
+/// POW don't fail due to duplicate hashes
+func Random_proof(proof_size int) Proof {
+  nonce_mask := uint64(1)<<(consensus.Min_sizeshift()-1) - 1
+  nonces := make([]uint64, proof_size)
+  for i := range nonces {
+    nonces[i] = rand.Uint64() & nonce_mask
+  }
+  return New_proof(nonces)
+}
+
+/// Returns the proof size
+func (self *Proof) Proof_size() int {
+  return len(self.Nonces)
+}
+
+/// Difficulty achieved by this proof, with its sizeshift adjustment
+func (self *Proof) To_difficulty() Difficulty {
+  hash := self.Hash()
+  return Difficulty_from_hash_and_shift(&hash, self.Cuckoo_sizeshift)
+}
+
+/// Serializes the proof. The sizeshift is left out when hashing, the nonces
+/// are packed at their exact bit size (sizeshift - 1).
+func (self *Proof) Write(writer consensus.Writer) error {
+  if writer.Serialization_mode() != consensus.SERIALIZATION_HASH {
+    if err := writer.Write_u8(self.Cuckoo_sizeshift); err != nil {
+      return err
+    }
+  }
+  nonce_bits := int(self.Cuckoo_sizeshift) - 1
+  bitvec := new_bitvec(nonce_bits * len(self.Nonces))
+  for n, nonce := range self.Nonces {
+    for bit := 0; bit < nonce_bits; bit++ {
+      if nonce&(1<<uint(bit)) != 0 {
+        bitvec.set_bit_at(n*nonce_bits + bit)
+      }
+    }
+  }
+  return writer.Write_fixed_bytes(bitvec.bits)
+}
+
+/// Reads a proof, unpacking the nonces from their exact bit size.
+func (self *Proof) Read(reader consensus.Reader) error {
+  cuckoo_sizeshift, err := reader.Read_u8()
+  if err != nil {
+    return err
+  }
+  if cuckoo_sizeshift == 0 || cuckoo_sizeshift > 64 {
+    return consensus.Error{Kind: consensus.CorruptedData}
+  }
+
+  nonce_bits := int(cuckoo_sizeshift) - 1
+  bits, err := reader.Read_fixed_bytes(bitvec_bytes_len(nonce_bits * consensus.Proofsize()))
+  if err != nil {
+    return err
+  }
+  bitvec := bitvec{bits: bits}
+
+  proof_size := consensus.Proofsize()
+  nonces := make([]uint64, 0, proof_size)
+  for n := 0; n < proof_size; n++ {
+    var nonce uint64
+    for bit := 0; bit < nonce_bits; bit++ {
+      if bitvec.bit_at(n*nonce_bits + bit) {
+        nonce |= 1 << uint(bit)
+      }
+    }
+    nonces = append(nonces, nonce)
+  }
+
+  self.Cuckoo_sizeshift = cuckoo_sizeshift
+  self.Nonces = nonces
+  return nil
+}
+
+/// The hash of the proof is the hash of its packed nonces.
+func (self *Proof) Hash() Hash {
+  return Hash_writeable(self)
+}
+
+/// Bit vector used to pack the proof nonces.
+type bitvec struct {
+  bits []uint8
+}
+
+/// Number of bytes required to store a certain number of bits.
+func bitvec_bytes_len(bits_len int) int {
+  return (bits_len + 7) / 8
+}
+
+func new_bitvec(bits_len int) bitvec {
+  return bitvec{bits: make([]uint8, bitvec_bytes_len(bits_len))}
+}
+
+func (self *bitvec) set_bit_at(pos int) {
+  self.bits[pos/8] |= 1 << uint(pos%8)
+}
+
+func (self *bitvec) bit_at(pos int) bool {
+  return self.bits[pos/8]&(1<<uint(pos%8)) != 0
 }
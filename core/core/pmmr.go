@@ -1,5 +1,79 @@
 package core
 
+/// Persistent and prunable Merkle Mountain Range implementation. For a high
+/// level description of MMRs, see:
+///
+/// https://github.com/opentimestamps/opentimestamps-server/blob/master/doc/merkle-mountain-range.md
+///
+/// This implementation is built in two major parts:
+///
+/// 1. A set of low-level functions that allow navigation within an arbitrary
+/// sized binary tree traversed in postorder. To realize why this us useful,
+/// we start with the standard height sequence in a MMR: 0010012001... This is
+/// in fact identical to the postorder traversal (left-right-top) of a binary
+/// tree. In addition postorder traversal is independent of the height of the
+/// tree. This allows us, with a few primitive, to get the height of any node
+/// in the MMR from its position in the sequence, as well as calculate the
+/// position of siblings, parents, etc. As all those functions only rely on
+/// binary operations, they're extremely fast.
+/// 2. The implementation of a prunable MMR tree using the above. Each leaf
+/// is required to be Writeable (which implements Hashed). Tree roots can be
+/// trivially and efficiently calculated without materializing the full tree.
+/// The underlying Hashes are stored in a Backend implementation that can
+/// either be a simple Vec or a database.
+
+import (
+  "fmt"
+  "math/bits"
+
+  "github.com/RoaringBitmap/roaring"
+
+  ser "github.com/kelby/go-grin/core"
+)
+
+/// Trait for an element of the tree that has a well-defined byte
+/// representation, and a hash that commits to its position in the MMR.
+type PMMRable interface {
+  ser.Writeable
+  PMMRIndexHashable
+}
+
+/// Storage backend for the MMR, just needs to be indexed by order of
+/// insertion. The PMMR itself does not need the Backend to be accurate on
+/// the existence of an element (i.e. remove could be a no-op) but layers
+/// above can depend on an accurate Backend to check existence.
+type Backend interface {
+  /// Append the provided Hashes to the backend storage, the data being the
+  /// element of the leaf at position (the first hash). The position of the
+  /// first hash in the MMR is provided to help the implementation.
+  Append(position uint64, data PMMRable, hashes []Hash) error
+
+  /// Rewind the backend state to a previous position, as if all append
+  /// operations after that had been canceled. Expects a position in the PMMR
+  /// to rewind to as well as bitmaps representing the positions added and
+  /// removed since the rewind position. These are what we will "undo"
+  /// during the rewind.
+  Rewind(position uint64, rewind_add_pos *roaring.Bitmap, rewind_rm_pos *roaring.Bitmap) error
+
+  /// Get a Hash by insertion position, false if the leaf was removed.
+  Get_hash(position uint64) (Hash, bool)
+
+  /// Reads the data of the leaf at the insertion position into the given
+  /// element, false if there is no data (pruned or not a leaf).
+  Get_data(position uint64, into ser.Readable) bool
+
+  /// Get a Hash by original insertion position (ignoring the remove log).
+  Get_from_file(position uint64) (Hash, bool)
+
+  /// Remove a leaf by insertion position.
+  Remove(position uint64) error
+
+  /// Returns the data file path.. this is a bit of a hack now that doesn't
+  /// sit well with the design, but TxKernels have to be summed and the
+  /// fastest way to to be able to allow direct access to the file
+  Get_data_file_path() string
+}
+
 /// Prunable Merkle Mountain Range implementation. All positions within the tree
 /// start at 1 as they're postorder tree traversal positions rather than array
 /// indices.
@@ -7,10 +81,466 @@ package core
 /// Heavily relies on navigation operations within a binary tree. In particular,
 /// all the implementation needs to keep track of the MMR structure is how far
 /// we are in the sequence of nodes making up the MMR.
-type struct PMMR {
+type PMMR struct {
   /// The last position in the PMMR
   Last_pos uint64
-  Backend Backend
-  // only needed to parameterise Backend
-  // marker marker.PhantomData
+  Backend  Backend
+}
+
+/// Build a new prunable Merkle Mountain Range using the provided backend.
+func New_pmmr(backend Backend) PMMR {
+  return PMMR{Last_pos: 0, Backend: backend}
+}
+
+/// Build a new prunable Merkle Mountain Range pre-initialized until
+/// last_pos with the provided backend.
+func Pmmr_at(backend Backend, last_pos uint64) PMMR {
+  return PMMR{Last_pos: last_pos, Backend: backend}
+}
+
+/// Returns a vec of the peaks of this MMR.
+func (self *PMMR) Peaks() []Hash {
+  res := []Hash{}
+  for _, pi := range Peaks(self.Last_pos) {
+    // here we want to get from underlying hash file
+    // as the pos *may* have been "removed"
+    if hash, ok := self.Backend.Get_from_file(pi); ok {
+      res = append(res, hash)
+    }
+  }
+  return res
+}
+
+/// The peaks left of the given one (from right to left), preceded by the
+/// bagged peaks on its right, the path from a peak to the root.
+func (self *PMMR) peak_path(peak_pos uint64) []Hash {
+  res := []Hash{}
+  if rhs, ok := self.Bag_the_rhs(peak_pos); ok {
+    res = append(res, rhs)
+  }
+  peaks := Peaks(self.Last_pos)
+  for i := len(peaks) - 1; i >= 0; i-- {
+    if peaks[i] >= peak_pos {
+      continue
+    }
+    if hash, ok := self.Backend.Get_from_file(peaks[i]); ok {
+      res = append(res, hash)
+    }
+  }
+  return res
+}
+
+/// Takes a single peak position and hashes together
+/// all the peaks to the right of this peak (if any).
+/// If this return a hash then this is our peaks sibling.
+/// If none then the sibling of our peak is the peak to the left.
+func (self *PMMR) Bag_the_rhs(peak_pos uint64) (Hash, bool) {
+  rhs := []Hash{}
+  for _, pi := range Peaks(self.Last_pos) {
+    if pi <= peak_pos {
+      continue
+    }
+    if hash, ok := self.Backend.Get_from_file(pi); ok {
+      rhs = append(rhs, hash)
+    }
+  }
+  return self.bag(rhs)
+}
+
+/// Computes the root of the MMR. Find all the peaks in the current
+/// tree and "bags" them to get a single peak.
+func (self *PMMR) Root() Hash {
+  root, ok := self.bag(self.Peaks())
+  if !ok {
+    panic("no root, invalid tree")
+  }
+  return root
+}
+
+/// Bags the given peaks from right to left, each peak being hashed with the
+/// bag of the ones on its right and the MMR size.
+func (self *PMMR) bag(peaks []Hash) (Hash, bool) {
+  if len(peaks) == 0 {
+    return Hash{}, false
+  }
+  res := peaks[len(peaks)-1]
+  for i := len(peaks) - 2; i >= 0; i-- {
+    res = hash_children(peaks[i], res, self.Unpruned_size())
+  }
+  return res, true
+}
+
+/// Build a Merkle proof for the element at the given position.
+func (self *PMMR) Merkle_proof(pos uint64) (MerkleProof, error) {
+  // check this pos is actually a leaf in the MMR
+  if !Is_leaf(pos) {
+    return MerkleProof{}, fmt.Errorf("not a leaf at pos %d", pos)
+  }
+
+  // check we actually have a hash in the MMR at this pos
+  if _, ok := self.Get_hash(pos); !ok {
+    return MerkleProof{}, fmt.Errorf("no element at pos %d", pos)
+  }
+
+  mmr_size := self.Unpruned_size()
+
+  // Edge case: an MMR with a single entry in it
+  // this entry is a leaf, a peak and the root itself
+  // and there are no siblings to hash with
+  if mmr_size == 1 {
+    return MerkleProof{Mmr_size: mmr_size, Path: []Hash{}}, nil
+  }
+
+  family_branch := Family_branch(pos, self.Last_pos)
+
+  path := []Hash{}
+  for _, fam := range family_branch {
+    if hash, ok := self.get_from_file(fam.Sibling); ok {
+      path = append(path, hash)
+    }
+  }
+
+  peak_pos := pos
+  if len(family_branch) > 0 {
+    peak_pos = family_branch[len(family_branch)-1].Parent
+  }
+  path = append(path, self.peak_path(peak_pos)...)
+
+  return MerkleProof{Mmr_size: mmr_size, Path: path}, nil
+}
+
+/// Push a new element into the MMR. Computes new related peaks at
+/// the same time if applicable.
+func (self *PMMR) Push(elmt PMMRable) (uint64, error) {
+  elmt_pos := self.Last_pos + 1
+  current_hash := elmt.Hash_with_index(elmt_pos - 1)
+
+  to_append := []Hash{current_hash}
+  pos := elmt_pos
+
+  peak_map, height := Peak_map_height(pos - 1)
+  if height != 0 {
+    return 0, fmt.Errorf("bad mmr size %d", pos-1)
+  }
+  // hash with all immediately preceding peaks, as indicated by peak map
+  peak := uint64(1)
+  for peak_map&peak != 0 {
+    left_sibling := pos + 1 - 2*peak
+    left_hash, ok := self.Backend.Get_from_file(left_sibling)
+    if !ok {
+      return 0, fmt.Errorf("missing left sibling in tree, should not have been pruned")
+    }
+    peak *= 2
+    pos += 1
+    current_hash = hash_children(left_hash, current_hash, pos-1)
+    to_append = append(to_append, current_hash)
+  }
+
+  // append all the new nodes and update the MMR index
+  if err := self.Backend.Append(elmt_pos, elmt, to_append); err != nil {
+    return 0, err
+  }
+  self.Last_pos = pos
+  return elmt_pos, nil
+}
+
+/// Rewind the PMMR to a previous position, as if all push operations after
+/// that had been canceled. Expects a position in the PMMR to rewind and
+/// bitmaps representing the positions added and removed that we want to
+/// "undo".
+func (self *PMMR) Rewind(position uint64, rewind_add_pos *roaring.Bitmap, rewind_rm_pos *roaring.Bitmap) error {
+  // Identify which actual position we should rewind to as the provided
+  // position is a leaf. We traverse the MMR to include any parent(s) that
+  // need to be included for the MMR to be valid.
+  pos := position
+  for Bintree_postorder_height(pos+1) > 0 {
+    pos += 1
+  }
+
+  if err := self.Backend.Rewind(pos, rewind_add_pos, rewind_rm_pos); err != nil {
+    return err
+  }
+  self.Last_pos = pos
+  return nil
+}
+
+/// Prunes (removes) the leaf from the MMR at the specified position.
+/// Returns false if the leaf node has already been pruned.
+/// Returns an error if prune is called on a non-leaf position.
+func (self *PMMR) Prune(position uint64) (bool, error) {
+  if _, ok := self.Backend.Get_hash(position); !ok {
+    return false, nil
+  }
+
+  // Check we are not attempting to prune a non-leaf position
+  if !Is_leaf(position) {
+    return false, fmt.Errorf("Node at %d is not a leaf, can't prune.", position)
+  }
+
+  if err := self.Backend.Remove(position); err != nil {
+    return false, err
+  }
+  return true, nil
+}
+
+/// Get the hash at provided position in the MMR.
+func (self *PMMR) Get_hash(pos uint64) (Hash, bool) {
+  if pos > self.Last_pos {
+    return Hash{}, false
+  }
+  if Is_leaf(pos) {
+    // If we are a leaf then get hash from the backend.
+    return self.Backend.Get_hash(pos)
+  }
+  // If we are not a leaf get hash ignoring the remove log.
+  return self.Backend.Get_from_file(pos)
+}
+
+/// Get the data element at provided position in the MMR.
+func (self *PMMR) Get_data(pos uint64, into ser.Readable) bool {
+  if pos > self.Last_pos || !Is_leaf(pos) {
+    // If we are beyond the rhs of the MMR or not a leaf there is no data.
+    return false
+  }
+  return self.Backend.Get_data(pos, into)
+}
+
+/// Get the hash from the underlying MMR file
+/// (ignores the remove log).
+func (self *PMMR) get_from_file(pos uint64) (Hash, bool) {
+  if pos > self.Last_pos {
+    return Hash{}, false
+  }
+  return self.Backend.Get_from_file(pos)
+}
+
+/// Walks all unpruned nodes in the MMR and revalidate all parent hashes
+func (self *PMMR) Validate() error {
+  // iterate on all parent nodes
+  for n := uint64(1); n <= self.Last_pos; n++ {
+    height := Bintree_postorder_height(n)
+    if height == 0 {
+      continue
+    }
+    hash, ok := self.Get_hash(n)
+    if !ok {
+      continue
+    }
+    left_pos := n - (1 << height)
+    right_pos := n - 1
+    // using get_from_file here for the children (they may have been
+    // "removed")
+    left_child_hs, left_ok := self.get_from_file(left_pos)
+    right_child_hs, right_ok := self.get_from_file(right_pos)
+    if left_ok && right_ok {
+      // hash the two child nodes together with parent_pos and compare
+      if hash_children(left_child_hs, right_child_hs, n-1) != hash {
+        return fmt.Errorf("Invalid MMR, hash of parent at %d does not match children.", n)
+      }
+    }
+  }
+  return nil
+}
+
+/// Total size of the tree, including intermediary nodes and ignoring any
+/// pruning.
+func (self *PMMR) Unpruned_size() uint64 {
+  return self.Last_pos
+}
+
+/// Hash of a parent node: its two children hashed together with its
+/// position, also used to bag the peaks.
+func hash_children(left Hash, right Hash, index uint64) Hash {
+  hasher := New_hash_writer()
+  hasher.Write_u64(index)
+  hasher.Write_fixed_bytes(left[:])
+  hasher.Write_fixed_bytes(right[:])
+  return hasher.Finalize()
+}
+
+/// 64 bits all ones: 0b11111111...1
+const all_ones = ^uint64(0)
+
+/// Gets the postorder traversal index of all peaks in a MMR given its size.
+/// Starts with the top peak, which is always on the left
+/// side of the range, and navigates toward lower siblings toward the right
+/// of the range.
+func Peaks(num uint64) []uint64 {
+  if num == 0 {
+    return []uint64{}
+  }
+  peak_size := all_ones >> uint(bits.LeadingZeros64(num))
+  num_left := num
+  sum_prev_peaks := uint64(0)
+  peaks := []uint64{}
+  for peak_size != 0 {
+    if num_left >= peak_size {
+      peaks = append(peaks, sum_prev_peaks+peak_size)
+      sum_prev_peaks += peak_size
+      num_left -= peak_size
+    }
+    peak_size >>= 1
+  }
+  if num_left > 0 {
+    // not a valid MMR size
+    return []uint64{}
+  }
+  return peaks
+}
+
+/// The number of leaves in a MMR of the provided size.
+func N_leaves(size uint64) uint64 {
+  sizes, height := Peak_sizes_height(size)
+  nleaves := uint64(0)
+  for _, n := range sizes {
+    nleaves += (n + 1) / 2
+  }
+  if height == 0 {
+    return nleaves
+  }
+  return nleaves + 1
+}
+
+/// Returns the pmmr index of the nth inserted element
+func Insertion_to_pmmr_index(sz uint64) uint64 {
+  if sz == 0 {
+    return 0
+  }
+  // 1 based pmmrs
+  sz -= 1
+  return 2*sz - uint64(bits.OnesCount64(sz)) + 1
+}
+
+/// sizes of peaks and height of next node in mmr of given size
+/// Example: on input 5 returns ([3,1], 1) as mmr state before adding 5 was
+///    2
+///   / \
+///  0   1   3   4
+func Peak_sizes_height(size uint64) ([]uint64, uint64) {
+  if size == 0 {
+    return []uint64{}, 0
+  }
+  peak_size := all_ones >> uint(bits.LeadingZeros64(size))
+  sizes := []uint64{}
+  for peak_size != 0 {
+    if size >= peak_size {
+      sizes = append(sizes, peak_size)
+      size -= peak_size
+    }
+    peak_size >>= 1
+  }
+  return sizes, size
+}
+
+/// return (peak_map, pos_height) of given 0-based node pos prior to its
+/// addition
+/// Example: on input 4 returns (0b11, 0) as mmr state before adding 4 was
+///    2
+///   / \
+///  0   1   3
+/// with 0b11 indicating presence of peaks of height 0 and 1.
+/// NOTE:
+/// the peak map also encodes the path taken from the root to the added node
+/// since the path turns left (resp. right) if-and-only-if
+/// a peak at that height is absent (resp. present)
+func Peak_map_height(pos uint64) (uint64, uint64) {
+  if pos == 0 {
+    return 0, 0
+  }
+  peak_size := all_ones >> uint(bits.LeadingZeros64(pos))
+  bitmap := uint64(0)
+  for peak_size != 0 {
+    bitmap <<= 1
+    if pos >= peak_size {
+      pos -= peak_size
+      bitmap |= 1
+    }
+    peak_size >>= 1
+  }
+  return bitmap, pos
+}
+
+/// The height of a node in a full binary tree from its postorder traversal
+/// index. This function is the base on which all others, as well as the MMR,
+/// are built.
+func Bintree_postorder_height(num uint64) uint64 {
+  if num == 0 {
+    return 0
+  }
+  _, height := Peak_map_height(num - 1)
+  return height
+}
+
+/// Is this position a leaf in the MMR?
+/// We know the positions of all leaves based on the postorder height of an
+/// MMR of any size (somewhat unintuitively but this is how the PMMR is
+/// "append only").
+func Is_leaf(pos uint64) bool {
+  return Bintree_postorder_height(pos) == 0
+}
+
+/// Calculates the positions of the parent and sibling of the node at the
+/// provided position.
+func Family(pos uint64) (uint64, uint64) {
+  peak_map, height := Peak_map_height(pos - 1)
+  peak := uint64(1) << height
+  if peak_map&peak != 0 {
+    return pos + 1, pos + 1 - 2*peak
+  }
+  return pos + 2*peak, pos + 2*peak - 1
+}
+
+/// Is the node at this pos the "left" sibling of its parent?
+func Is_left_sibling(pos uint64) bool {
+  peak_map, height := Peak_map_height(pos - 1)
+  peak := uint64(1) << height
+  return peak_map&peak == 0
+}
+
+/// Parent and sibling positions of a node of a family branch.
+type FamilyPos struct {
+  Parent  uint64
+  Sibling uint64
+}
+
+/// For a given starting position calculate the parent and sibling positions
+/// for the branch/path from that position to the peak of the tree.
+/// We will use the sibling positions to generate the "path" of a Merkle
+/// proof.
+func Family_branch(pos uint64, last_pos uint64) []FamilyPos {
+  // loop going up the tree, from node to parent, as long as we stay inside
+  // the tree (as defined by last_pos).
+  peak_map, height := Peak_map_height(pos - 1)
+  peak := uint64(1) << height
+  branch := []FamilyPos{}
+  current := pos
+  for current < last_pos {
+    var sibling uint64
+    if peak_map&peak != 0 {
+      current += 1
+      sibling = current - 2*peak
+    } else {
+      current += 2 * peak
+      sibling = current - 1
+    }
+    if current > last_pos {
+      break
+    }
+    branch = append(branch, FamilyPos{Parent: current, Sibling: sibling})
+    peak <<= 1
+  }
+  return branch
+}
+
+/// Gets the position of the rightmost node (i.e. leaf) beneath the provided
+/// subtree root.
+func Bintree_rightmost(num uint64) uint64 {
+  return num - Bintree_postorder_height(num)
+}
+
+/// Gets the position of the leftmost node (i.e. leaf) beneath the provided
+/// subtree root.
+func Bintree_leftmost(num uint64) uint64 {
+  height := Bintree_postorder_height(num)
+  return num + 2 - (2 << height)
 }
@@ -0,0 +1,470 @@
+package core
+
+import (
+  "bytes"
+  "reflect"
+  "testing"
+
+  "github.com/RoaringBitmap/roaring"
+
+  ser "github.com/kelby/go-grin/core"
+)
+
+/// Simple MMR element for tests.
+type test_elem [4]uint32
+
+func (self *test_elem) Write(writer ser.Writer) error {
+  for _, n := range self {
+    if err := writer.Write_u32(n); err != nil {
+      return err
+    }
+  }
+  return nil
+}
+
+func (self *test_elem) Read(reader ser.Reader) error {
+  for i := range self {
+    n, err := reader.Read_u32()
+    if err != nil {
+      return err
+    }
+    self[i] = n
+  }
+  return nil
+}
+
+func (self *test_elem) Hash_with_index(index uint64) Hash {
+  return Hash_with_index(self, index)
+}
+
+/// Simple, in-memory Backend implementation for testing the PMMR.
+type vec_backend struct {
+  /// Backend elements, one per leaf
+  data []PMMRable
+  /// All the hashes, by insertion position minus one
+  hashes []Hash
+  /// Positions of removed elements
+  remove_list *roaring.Bitmap
+}
+
+func new_vec_backend() *vec_backend {
+  return &vec_backend{remove_list: roaring.New()}
+}
+
+func (self *vec_backend) Append(position uint64, data PMMRable, hashes []Hash) error {
+  self.data = append(self.data, data)
+  self.hashes = append(self.hashes, hashes...)
+  return nil
+}
+
+func (self *vec_backend) Rewind(position uint64, rewind_add_pos *roaring.Bitmap, rewind_rm_pos *roaring.Bitmap) error {
+  self.data = self.data[:N_leaves(position)]
+  self.hashes = self.hashes[:position]
+  if rewind_rm_pos != nil {
+    self.remove_list.AndNot(rewind_rm_pos)
+  }
+  return nil
+}
+
+func (self *vec_backend) Get_hash(position uint64) (Hash, bool) {
+  if self.remove_list.Contains(uint32(position)) {
+    return Hash{}, false
+  }
+  return self.Get_from_file(position)
+}
+
+func (self *vec_backend) Get_data(position uint64, into ser.Readable) bool {
+  if self.remove_list.Contains(uint32(position)) {
+    return false
+  }
+  idx := N_leaves(position) - 1
+  if idx >= uint64(len(self.data)) {
+    return false
+  }
+  data, err := ser.Ser_vec(self.data[idx])
+  if err != nil {
+    return false
+  }
+  return ser.Deserialize(bytes.NewReader(data), into) == nil
+}
+
+func (self *vec_backend) Get_from_file(position uint64) (Hash, bool) {
+  if position == 0 || position > uint64(len(self.hashes)) {
+    return Hash{}, false
+  }
+  return self.hashes[position-1], true
+}
+
+func (self *vec_backend) Remove(position uint64) error {
+  self.remove_list.Add(uint32(position))
+  return nil
+}
+
+func (self *vec_backend) Get_data_file_path() string {
+  return ""
+}
+
+func Test_bintree_postorder_height(t *testing.T) {
+  heights := []uint64{0, 0, 1, 0, 0, 1, 2, 0, 0, 1, 0, 0, 1, 2, 3, 0, 0, 1, 0, 0, 1, 2, 0}
+  for i, height := range heights {
+    pos := uint64(i + 1)
+    if h := Bintree_postorder_height(pos); h != height {
+      t.Errorf("height of %d is %d, expected %d", pos, h, height)
+    }
+    if Is_leaf(pos) != (height == 0) {
+      t.Errorf("is_leaf(%d) inconsistent with height %d", pos, height)
+    }
+  }
+}
+
+func Test_peaks(t *testing.T) {
+  tests := []struct {
+    size  uint64
+    peaks []uint64
+  }{
+    {0, []uint64{}},
+    {1, []uint64{1}},
+    {2, []uint64{}},
+    {3, []uint64{3}},
+    {4, []uint64{3, 4}},
+    {5, []uint64{}},
+    {6, []uint64{}},
+    {7, []uint64{7}},
+    {8, []uint64{7, 8}},
+    {9, []uint64{}},
+    {10, []uint64{7, 10}},
+    {11, []uint64{7, 10, 11}},
+    {22, []uint64{15, 22}},
+    {32, []uint64{31, 32}},
+    {35, []uint64{31, 34, 35}},
+    {42, []uint64{31, 38, 41, 42}},
+  }
+  for _, test := range tests {
+    if peaks := Peaks(test.size); !reflect.DeepEqual(peaks, test.peaks) {
+      t.Errorf("peaks(%d) = %v, expected %v", test.size, peaks, test.peaks)
+    }
+  }
+}
+
+func Test_n_leaves(t *testing.T) {
+  // an invalid size is rounded up to the next valid one
+  leaves := []uint64{0, 1, 2, 2, 3, 4, 4, 4, 5, 6, 6, 7}
+  for size, n := range leaves {
+    if l := N_leaves(uint64(size)); l != n {
+      t.Errorf("n_leaves(%d) = %d, expected %d", size, l, n)
+    }
+  }
+}
+
+func Test_insertion_to_pmmr_index(t *testing.T) {
+  indexes := []uint64{0, 1, 2, 4, 5, 8, 9, 11, 12, 16}
+  for n, pos := range indexes {
+    if idx := Insertion_to_pmmr_index(uint64(n)); idx != pos {
+      t.Errorf("insertion_to_pmmr_index(%d) = %d, expected %d", n, idx, pos)
+    }
+    if n > 0 && N_leaves(pos) != uint64(n) {
+      t.Errorf("n_leaves(%d) = %d, expected %d", pos, N_leaves(pos), n)
+    }
+  }
+}
+
+func Test_peak_map_height(t *testing.T) {
+  tests := []struct {
+    pos      uint64
+    peak_map uint64
+    height   uint64
+  }{
+    {0, 0, 0},
+    {1, 0b1, 0},
+    {2, 0b1, 1},
+    {3, 0b10, 0},
+    {4, 0b11, 0},
+    {5, 0b11, 1},
+    {6, 0b11, 2},
+    {7, 0b100, 0},
+    {^uint64(0) - 1, ^uint64(0) >> 1, 63},
+  }
+  for _, test := range tests {
+    peak_map, height := Peak_map_height(test.pos)
+    if peak_map != test.peak_map || height != test.height {
+      t.Errorf("peak_map_height(%d) = (%b, %d), expected (%b, %d)", test.pos, peak_map, height, test.peak_map, test.height)
+    }
+  }
+}
+
+func Test_family(t *testing.T) {
+  tests := []struct {
+    pos     uint64
+    parent  uint64
+    sibling uint64
+  }{
+    {1, 3, 2},
+    {2, 3, 1},
+    {3, 7, 6},
+    {4, 6, 5},
+    {5, 6, 4},
+    {6, 7, 3},
+    {7, 15, 14},
+    {1000, 1001, 997},
+  }
+  for _, test := range tests {
+    parent, sibling := Family(test.pos)
+    if parent != test.parent || sibling != test.sibling {
+      t.Errorf("family(%d) = (%d, %d), expected (%d, %d)", test.pos, parent, sibling, test.parent, test.sibling)
+    }
+    if Is_left_sibling(test.pos) != (test.sibling > test.pos) {
+      t.Errorf("is_left_sibling(%d) inconsistent with its sibling %d", test.pos, test.sibling)
+    }
+  }
+}
+
+func Test_family_branch(t *testing.T) {
+  tests := []struct {
+    pos      uint64
+    last_pos uint64
+    branch   []FamilyPos
+  }{
+    // a single node is also a peak
+    {1, 1, []FamilyPos{}},
+    // leaves of a tree missing their parent
+    {1, 2, []FamilyPos{}},
+    {4, 4, []FamilyPos{}},
+    {1, 3, []FamilyPos{{3, 2}}},
+    {2, 3, []FamilyPos{{3, 1}}},
+    {3, 3, []FamilyPos{}},
+    {1, 7, []FamilyPos{{3, 2}, {7, 6}}},
+    {4, 7, []FamilyPos{{6, 5}, {7, 3}}},
+    {5, 7, []FamilyPos{{6, 4}, {7, 3}}},
+    // the branch stops at the peak of its own mountain
+    {1, 8, []FamilyPos{{3, 2}, {7, 6}}},
+    {8, 10, []FamilyPos{{10, 9}}},
+    {1, 15, []FamilyPos{{3, 2}, {7, 6}, {15, 14}}},
+    {12, 15, []FamilyPos{{13, 11}, {14, 10}, {15, 7}}},
+    {16, 22, []FamilyPos{{18, 17}, {22, 21}}},
+  }
+  for _, test := range tests {
+    if branch := Family_branch(test.pos, test.last_pos); !reflect.DeepEqual(branch, test.branch) {
+      t.Errorf("family_branch(%d, %d) = %v, expected %v", test.pos, test.last_pos, branch, test.branch)
+    }
+  }
+}
+
+func Test_bintree_range(t *testing.T) {
+  tests := []struct {
+    pos       uint64
+    leftmost  uint64
+    rightmost uint64
+  }{
+    {1, 1, 1},
+    {3, 1, 2},
+    {6, 4, 5},
+    {7, 1, 5},
+    {14, 8, 12},
+    {15, 1, 12},
+  }
+  for _, test := range tests {
+    if l := Bintree_leftmost(test.pos); l != test.leftmost {
+      t.Errorf("bintree_leftmost(%d) = %d, expected %d", test.pos, l, test.leftmost)
+    }
+    if r := Bintree_rightmost(test.pos); r != test.rightmost {
+      t.Errorf("bintree_rightmost(%d) = %d, expected %d", test.pos, r, test.rightmost)
+    }
+  }
+}
+
+func push_elems(t *testing.T, pmmr *PMMR, elems []test_elem) {
+  t.Helper()
+  for i := range elems {
+    if _, err := pmmr.Push(&elems[i]); err != nil {
+      t.Fatal(err)
+    }
+  }
+}
+
+func Test_pmmr_push_root(t *testing.T) {
+  elems := []test_elem{{0, 0, 0, 1}, {0, 0, 0, 2}, {0, 0, 0, 3}, {0, 0, 0, 4}, {0, 0, 0, 5}}
+  pmmr := New_pmmr(new_vec_backend())
+
+  // one element
+  push_elems(t, &pmmr, elems[:1])
+  h1 := elems[0].Hash_with_index(0)
+  if pmmr.Unpruned_size() != 1 || pmmr.Root() != h1 {
+    t.Fatal("wrong root with a single element")
+  }
+
+  // two elements, a parent gets added
+  push_elems(t, &pmmr, elems[1:2])
+  h2 := elems[1].Hash_with_index(1)
+  h3 := hash_children(h1, h2, 2)
+  if pmmr.Unpruned_size() != 3 || pmmr.Root() != h3 {
+    t.Fatal("wrong root with two elements")
+  }
+
+  // three elements, the two peaks get bagged with the MMR size
+  push_elems(t, &pmmr, elems[2:3])
+  h4 := elems[2].Hash_with_index(3)
+  if pmmr.Unpruned_size() != 4 || pmmr.Root() != hash_children(h3, h4, 4) {
+    t.Fatal("wrong root with three elements")
+  }
+
+  // four elements, a single peak again
+  push_elems(t, &pmmr, elems[3:4])
+  h5 := elems[3].Hash_with_index(4)
+  h6 := hash_children(h4, h5, 5)
+  h7 := hash_children(h3, h6, 6)
+  if pmmr.Unpruned_size() != 7 || pmmr.Root() != h7 {
+    t.Fatal("wrong root with four elements")
+  }
+  if err := pmmr.Validate(); err != nil {
+    t.Fatal(err)
+  }
+
+  // data gets read back from the leaves only
+  var elem test_elem
+  if !pmmr.Get_data(5, &elem) || elem != elems[3] {
+    t.Fatalf("read %v at pos 5, expected %v", elem, elems[3])
+  }
+  if pmmr.Get_data(6, &elem) || pmmr.Get_data(8, &elem) {
+    t.Fatal("data read outside of the leaves")
+  }
+}
+
+func Test_pmmr_merkle_proof(t *testing.T) {
+  elems := []test_elem{}
+  for i := uint32(1); i <= 20; i++ {
+    elems = append(elems, test_elem{i, i + 1, i + 2, i + 3})
+  }
+
+  for n := 1; n <= len(elems); n++ {
+    pmmr := New_pmmr(new_vec_backend())
+    push_elems(t, &pmmr, elems[:n])
+    root := pmmr.Root()
+    for i := 1; i <= n; i++ {
+      pos := Insertion_to_pmmr_index(uint64(i))
+      proof, err := pmmr.Merkle_proof(pos)
+      if err != nil {
+        t.Fatal(err)
+      }
+      if err := proof.Verify(root, &elems[i-1], pos); err != nil {
+        t.Fatalf("proof of element %d of %d doesn't verify: %s", i, n, err)
+      }
+      // survives a hex round trip but not a different element
+      proof, err = Merkle_proof_from_hex(proof.To_hex())
+      if err != nil {
+        t.Fatal(err)
+      }
+      if err := proof.Verify(root, &elems[i-1], pos); err != nil {
+        t.Fatalf("hex proof of element %d of %d doesn't verify: %s", i, n, err)
+      }
+      if n > 1 && proof.Verify(root, &elems[i%n], pos) == nil {
+        t.Fatalf("proof of element %d of %d verified another element", i, n)
+      }
+    }
+  }
+
+  pmmr := New_pmmr(new_vec_backend())
+  push_elems(t, &pmmr, elems[:3])
+  if _, err := pmmr.Merkle_proof(3); err == nil {
+    t.Fatal("proof built for a parent")
+  }
+  if _, err := pmmr.Merkle_proof(5); err == nil {
+    t.Fatal("proof built beyond the MMR")
+  }
+}
+
+func Test_pmmr_prune(t *testing.T) {
+  elems := []test_elem{{1}, {2}, {3}, {4}, {5}, {6}, {7}, {8}, {9}}
+  pmmr := New_pmmr(new_vec_backend())
+  push_elems(t, &pmmr, elems)
+  if pmmr.Unpruned_size() != 16 {
+    t.Fatalf("unpruned size %d, expected 16", pmmr.Unpruned_size())
+  }
+  root := pmmr.Root()
+
+  // pruning a parent is refused
+  if _, err := pmmr.Prune(3); err == nil {
+    t.Fatal("pruned a parent")
+  }
+
+  // pruning leaves keeps the root and doesn't prune twice
+  for _, pos := range []uint64{2, 1, 16} {
+    if ok, err := pmmr.Prune(pos); !ok || err != nil {
+      t.Fatalf("couldn't prune %d: %v", pos, err)
+    }
+    if ok, _ := pmmr.Prune(pos); ok {
+      t.Fatalf("pruned %d twice", pos)
+    }
+    if _, ok := pmmr.Get_hash(pos); ok {
+      t.Fatalf("pruned %d still has a hash", pos)
+    }
+    if pmmr.Root() != root {
+      t.Fatalf("root changed pruning %d", pos)
+    }
+  }
+  if _, ok := pmmr.Get_hash(3); !ok {
+    t.Fatal("parent of pruned leaves lost its hash")
+  }
+  if err := pmmr.Validate(); err != nil {
+    t.Fatal(err)
+  }
+
+  // pruned leaves have no proof, their siblings still have one
+  if _, err := pmmr.Merkle_proof(1); err == nil {
+    t.Fatal("proof built for a pruned leaf")
+  }
+  proof, err := pmmr.Merkle_proof(4)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if err := proof.Verify(root, &elems[2], 4); err != nil {
+    t.Fatal(err)
+  }
+}
+
+func Test_pmmr_rewind(t *testing.T) {
+  elems := []test_elem{{1}, {2}, {3}, {4}, {5}, {6}, {7}, {8}, {9}}
+  pmmr := New_pmmr(new_vec_backend())
+  push_elems(t, &pmmr, elems[:3])
+  root := pmmr.Root()
+  last_pos := pmmr.Last_pos
+
+  push_elems(t, &pmmr, elems[3:])
+  if ok, err := pmmr.Prune(2); !ok || err != nil {
+    t.Fatalf("couldn't prune 2: %v", err)
+  }
+
+  // rewinding to the last leaf, undoing the pruning
+  rewind_rm_pos := roaring.New()
+  rewind_rm_pos.Add(2)
+  if err := pmmr.Rewind(Insertion_to_pmmr_index(3), roaring.New(), rewind_rm_pos); err != nil {
+    t.Fatal(err)
+  }
+  if pmmr.Last_pos != last_pos || pmmr.Root() != root {
+    t.Fatalf("rewound to %d, expected %d", pmmr.Last_pos, last_pos)
+  }
+  if _, ok := pmmr.Get_hash(2); !ok {
+    t.Fatal("pruned leaf not restored by the rewind")
+  }
+
+  // rewinding to a leaf also includes its parents
+  push_elems(t, &pmmr, elems[3:4])
+  root = pmmr.Root()
+  push_elems(t, &pmmr, elems[4:])
+  if err := pmmr.Rewind(Insertion_to_pmmr_index(4), roaring.New(), roaring.New()); err != nil {
+    t.Fatal(err)
+  }
+  if pmmr.Last_pos != 7 || pmmr.Root() != root {
+    t.Fatalf("rewound to %d, expected 7", pmmr.Last_pos)
+  }
+
+  // pushing again after a rewind gives the same tree
+  push_elems(t, &pmmr, elems[4:])
+  other := New_pmmr(new_vec_backend())
+  push_elems(t, &other, elems)
+  if pmmr.Root() != other.Root() {
+    t.Fatal("different root after pushing again")
+  }
+  if err := pmmr.Validate(); err != nil {
+    t.Fatal(err)
+  }
+}
@@ -1,26 +1,102 @@
 package core
 
+import (
+  "encoding/binary"
+  "strconv"
+
+  consensus "github.com/kelby/go-grin/core"
+)
+
+/// The target is the 32-bytes hash block hashes must be lower than.
+var MAX_TARGET = [8]uint8{0xf, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+
+/// The difficulty is defined as the maximum target divided by the block hash.
+// #[derive(Debug, Clone, PartialEq, PartialOrd, Eq, Ord)]
 type Difficulty struct {
   Num uint64
 }
 
-type Difficulty interface {
-  /// Difficulty of zero, which is invalid (no target can be
-  /// calculated from it) but very useful as a start for additions.
-  func Zero() Difficulty
+/// Difficulty of zero, which is invalid (no target can be
+/// calculated from it) but very useful as a start for additions.
+func Difficulty_zero() Difficulty {
+  return Difficulty{Num: 0}
+}
 
-  /// Difficulty of one, which is the minumum difficulty
-  /// (when the hash equals the max target)
-  func One() Difficulty
+/// Difficulty of one, which is the minumum difficulty
+/// (when the hash equals the max target)
+func Difficulty_one() Difficulty {
+  return Difficulty{Num: 1}
+}
 
-  /// Convert a `u32` into a `Difficulty`
-  func From_num(num uint64) Difficulty
+/// Convert a `u64` into a `Difficulty`
+func Difficulty_from_num(num uint64) Difficulty {
+  return Difficulty{Num: num}
+}
 
-  /// Computes the difficulty from a hash. Divides the maximum target by the
-  /// provided hash and applies the Cuckoo sizeshift adjustment factor (see
-  /// https://lists.launchpad.net/mimblewimble/msg00494.html).
-  func From_hash_and_shift(h &Hash, shift uint8) Difficulty
+/// Converts the difficulty into a u64
+func (self Difficulty) To_num() uint64 {
+  return self.Num
+}
+
+/// Sum of both difficulties
+func (self Difficulty) Add(other Difficulty) Difficulty {
+  return Difficulty{Num: self.Num + other.Num}
+}
+
+/// Difference of both difficulties, other must not be greater than self
+func (self Difficulty) Sub(other Difficulty) Difficulty {
+  return Difficulty{Num: self.Num - other.Num}
+}
+
+/// Compares both difficulties, returning -1, 0 or 1 when self is
+/// respectively lower, equal or greater than other.
+func (self Difficulty) Cmp(other Difficulty) int {
+  switch {
+  case self.Num < other.Num:
+    return -1
+  case self.Num > other.Num:
+    return 1
+  }
+  return 0
+}
+
+/// Implements String() interface, the difficulty as a number.
+func (self Difficulty) String() string {
+  return strconv.FormatUint(self.Num, 10)
+}
+
+/// Computes the difficulty from a hash. Divides the maximum target by the
+/// provided hash and applies the Cuckoo sizeshift adjustment factor (see
+/// https://lists.launchpad.net/mimblewimble/msg00494.html).
+func Difficulty_from_hash_and_shift(h *Hash, shift uint8) Difficulty {
+  max_target := binary.BigEndian.Uint64(MAX_TARGET[:])
+  // Use the first 64 bits of the given hash
+  num := binary.BigEndian.Uint64(h[:8])
+  if num == 0 {
+    num = 1
+  }
+
+  // Adjust the difficulty based on a 2^(N-M)*(N-1) factor, with M being
+  // the reference sizeshift and N the provided sizeshift
+  adjust_factor := uint64(shift) - 1
+  if ref_shift := consensus.Ref_sizeshift(); shift > ref_shift {
+    adjust_factor <<= shift - ref_shift
+  }
+
+  return Difficulty_from_num((max_target / num) * adjust_factor)
+}
+
+/// Serialize a difficulty
+func (self *Difficulty) Write(writer consensus.Writer) error {
+  return writer.Write_u64(self.Num)
+}
 
-  /// Converts the difficulty into a u64
-  func (self *Difficulty)To_num() uint64
+/// Deserialize a difficulty
+func (self *Difficulty) Read(reader consensus.Reader) error {
+  num, err := reader.Read_u64()
+  if err != nil {
+    return err
+  }
+  self.Num = num
+  return nil
 }
@@ -1,8 +1,16 @@
-package transaction
+package core
 
-import "keychain"
-import "secp/pedersen"
-import "secp"
+import (
+  "bytes"
+  "fmt"
+  "sort"
+
+  "github.com/kelby/go-grin/keychain"
+  "github.com/kelby/go-grin/secp"
+  "github.com/kelby/go-grin/util"
+
+  consensus "github.com/kelby/go-grin/core"
+)
 
 /// Options for a kernel's structure or use
 // #[derive(Serialize, Deserialize)]
@@ -17,13 +25,92 @@ const (
 // #[derive(Serialize, Deserialize)]
 type OutputFeatures uint8
 
-const {
+const (
   /// No flags
   DEFAULT_OUTPUT OutputFeatures = iota
   /// Output is a coinbase output, must not be spent until maturity
   COINBASE_OUTPUT
+)
+
+type TransactionErrorKind int
+
+/// Errors thrown by Transaction validation
+const (
+  /// Underlying Secp256k1 error (signature validation or invalid public key
+  /// typically)
+  TxSecpErr TransactionErrorKind = iota
+  /// Underlying keychain related error
+  TxKeychainErr
+  /// The sum of output minus input commitments does not
+  /// match the sum of kernel commitments
+  TxKernelSumMismatch
+  /// Restrict tx total weight.
+  TooHeavy
+  /// Error originating from an invalid lock-height
+  LockHeight
+  /// Range proof validation error
+  InvalidRangeProof
+  /// Error when the inputs, outputs or kernels aren't sorted
+  SortOrder
+  /// An input, output or kernel is duplicated
+  DuplicateError
+  /// Validation error relating to cut-through (tx is spending its own
+  /// output).
+  CutThrough
+  /// Validation error relating to output features.
+  /// It is invalid for a transaction to contain a coinbase output, for
+  /// example.
+  InvalidOutputFeatures
+  /// Validation error relating to kernel features.
+  /// It is invalid for a transaction to contain a coinbase kernel, for
+  /// example.
+  InvalidKernelFeatures
+  /// Signature verification error.
+  IncorrectSignature
+  /// Error when aggregating or deaggregating transactions
+  AggregationError
+)
+
+/// Error returned by the Transaction validation, the Kind tells what rule
+/// was broken and the Msg which input, output or kernel broke it.
+type TransactionError struct {
+  Kind TransactionErrorKind
+  Msg  string
+}
+
+func (self TransactionError) Error() string {
+  switch self.Kind {
+  case TxSecpErr:
+    return fmt.Sprintf("secp error: %s", self.Msg)
+  case TxKeychainErr:
+    return fmt.Sprintf("keychain error: %s", self.Msg)
+  case TxKernelSumMismatch:
+    return "kernel sum mismatch"
+  case TooHeavy:
+    return fmt.Sprintf("transaction too heavy: %s", self.Msg)
+  case LockHeight:
+    return fmt.Sprintf("invalid lock height: %s", self.Msg)
+  case InvalidRangeProof:
+    return fmt.Sprintf("invalid range proof: %s", self.Msg)
+  case SortOrder:
+    return fmt.Sprintf("badly sorted %s", self.Msg)
+  case DuplicateError:
+    return fmt.Sprintf("duplicate %s", self.Msg)
+  case CutThrough:
+    return fmt.Sprintf("cut-through: %s", self.Msg)
+  case InvalidOutputFeatures:
+    return fmt.Sprintf("invalid output features: %s", self.Msg)
+  case InvalidKernelFeatures:
+    return fmt.Sprintf("invalid kernel features: %s", self.Msg)
+  case IncorrectSignature:
+    return fmt.Sprintf("incorrect kernel signature: %s", self.Msg)
+  case AggregationError:
+    return fmt.Sprintf("aggregation error: %s", self.Msg)
+  }
+  return "unknown transaction error"
 }
 
+/// A transaction
 type Transaction struct {
   /// List of inputs spent by the transaction.
   Inputs []Input
@@ -36,8 +123,487 @@ type Transaction struct {
   Offset keychain.BlindingFactor
 }
 
-func (self Transaction) Eq(tx Transaction) bool {
-  return self.Inputs == tx.Inputs && self.Outputs == tx.Outputs && self.Kernels == tx.Kernels && self.Offset == tx.Offset
+/// Whether the two transactions hold the exact same inputs, outputs, kernels
+/// and offset.
+func (self *Transaction) Eq(tx *Transaction) bool {
+  if len(self.Inputs) != len(tx.Inputs) || len(self.Outputs) != len(tx.Outputs) || len(self.Kernels) != len(tx.Kernels) {
+    return false
+  }
+  for i := range self.Inputs {
+    if self.Inputs[i] != tx.Inputs[i] {
+      return false
+    }
+  }
+  for i := range self.Outputs {
+    if !self.Outputs[i].Eq(&tx.Outputs[i]) {
+      return false
+    }
+  }
+  for i := range self.Kernels {
+    if self.Kernels[i] != tx.Kernels[i] {
+      return false
+    }
+  }
+  return self.Offset == tx.Offset
+}
+
+/// Implementation of Writeable for a fully blinded transaction, defines how to
+/// write the transaction as binary.
+func (self *Transaction) Write(writer consensus.Writer) error {
+  if err := writer.Write_fixed_bytes(self.Offset[:]); err != nil {
+    return err
+  }
+  return write_body(writer, self.Inputs, self.Outputs, self.Kernels)
+}
+
+/// Implementation of Readable for a transaction, defines how to read a full
+/// transaction from a binary stream.
+func (self *Transaction) Read(reader consensus.Reader) error {
+  offset, err := consensus.Read_blinding_factor(reader)
+  if err != nil {
+    return err
+  }
+  input_len, err := reader.Read_u64()
+  if err != nil {
+    return err
+  }
+  output_len, err := reader.Read_u64()
+  if err != nil {
+    return err
+  }
+  kernel_len, err := reader.Read_u64()
+  if err != nil {
+    return err
+  }
+
+  inputs, outputs, kernels, err := read_body(reader, input_len, output_len, kernel_len)
+  if err != nil {
+    return err
+  }
+
+  self.Offset = offset
+  self.Inputs = inputs
+  self.Outputs = outputs
+  self.Kernels = kernels
+  return nil
+}
+
+/// Creates a new transaction from its inputs, outputs and kernels, sorting
+/// them as consensus requires. The offset is zero, see With_offset.
+func New_transaction(inputs []Input, outputs []Output, kernels []TxKernel) Transaction {
+  return Transaction{
+    Inputs:  sort_inputs(inputs),
+    Outputs: sort_outputs(outputs),
+    Kernels: sort_kernels(kernels),
+    Offset:  keychain.BlindingFactor{},
+  }
+}
+
+/// Creates a new transaction using this transaction as a template
+/// and with the specified offset.
+func (self Transaction) With_offset(offset keychain.BlindingFactor) Transaction {
+  self.Offset = offset
+  return self
+}
+
+/// Aggregate a vec of transactions into a multi-kernel transaction with
+/// cut_through. The kernel offsets are summed up and everything is sorted,
+/// the resulting transaction is validated before being returned.
+func Aggregate(transactions []Transaction) (Transaction, error) {
+  inputs := []Input{}
+  outputs := []Output{}
+  kernels := []TxKernel{}
+  kernel_offsets := []secp.SecretKey{}
+  for i := range transactions {
+    inputs = append(inputs, transactions[i].Inputs...)
+    outputs = append(outputs, transactions[i].Outputs...)
+    kernels = append(kernels, transactions[i].Kernels...)
+    kernel_offsets = append(kernel_offsets, secp.SecretKey(transactions[i].Offset))
+  }
+
+  // assemble output commitments set, checking they're all unique
+  out_set := make(map[secp.Commitment]bool, len(outputs))
+  for i := range outputs {
+    if out_set[outputs[i].Commit] {
+      return Transaction{}, TransactionError{Kind: AggregationError, Msg: fmt.Sprintf("duplicate output %x", outputs[i].Commit[:])}
+    }
+    out_set[outputs[i].Commit] = true
+  }
+
+  // cut-through: an output spent by an input of another transaction
+  // disappears along with that input
+  in_set := make(map[secp.Commitment]bool, len(inputs))
+  for i := range inputs {
+    in_set[inputs[i].Commit] = true
+  }
+  new_inputs := []Input{}
+  for i := range inputs {
+    if !out_set[inputs[i].Commit] {
+      new_inputs = append(new_inputs, inputs[i])
+    }
+  }
+  new_outputs := []Output{}
+  for i := range outputs {
+    if !in_set[outputs[i].Commit] {
+      new_outputs = append(new_outputs, outputs[i])
+    }
+  }
+
+  // sum the kernel_offsets up to give us an aggregate offset for the
+  // transaction
+  total_kernel_offset, err := util.Static_secp_instance().Blind_sum(kernel_offsets, nil)
+  if err != nil {
+    return Transaction{}, TransactionError{Kind: TxSecpErr, Msg: err.Error()}
+  }
+
+  tx := New_transaction(new_inputs, new_outputs, kernels).With_offset(keychain.BlindingFactor(total_kernel_offset))
+
+  // We need to check sums here as aggregation/cut-through may have created
+  // an invalid tx.
+  if err := tx.Validate(); err != nil {
+    return Transaction{}, err
+  }
+  return tx, nil
+}
+
+/// Attempt to deaggregate a multi-kernel transaction based on multiple
+/// transactions. The known transactions are aggregated and their inputs,
+/// outputs and kernels removed from the multi-kernel one, the offset of
+/// the result being the difference of the offsets.
+func Deaggregate(mk_tx Transaction, txs []Transaction) (Transaction, error) {
+  // transaction to remove
+  tx, err := Aggregate(txs)
+  if err != nil {
+    return Transaction{}, err
+  }
+
+  remove_inputs := make(map[Input]bool, len(tx.Inputs))
+  for _, input := range tx.Inputs {
+    remove_inputs[input] = true
+  }
+  remove_outputs := make(map[secp.Commitment]bool, len(tx.Outputs))
+  for i := range tx.Outputs {
+    remove_outputs[tx.Outputs[i].Commit] = true
+  }
+  remove_kernels := make(map[TxKernel]bool, len(tx.Kernels))
+  for _, kernel := range tx.Kernels {
+    remove_kernels[kernel] = true
+  }
+
+  inputs := []Input{}
+  for _, input := range mk_tx.Inputs {
+    if !remove_inputs[input] {
+      inputs = append(inputs, input)
+    }
+  }
+  outputs := []Output{}
+  for i := range mk_tx.Outputs {
+    if !remove_outputs[mk_tx.Outputs[i].Commit] {
+      outputs = append(outputs, mk_tx.Outputs[i])
+    }
+  }
+  kernels := []TxKernel{}
+  for _, kernel := range mk_tx.Kernels {
+    if !remove_kernels[kernel] {
+      kernels = append(kernels, kernel)
+    }
+  }
+  if len(kernels) == len(mk_tx.Kernels) {
+    return Transaction{}, TransactionError{Kind: AggregationError, Msg: "no kernel in common"}
+  }
+
+  // now compute the total kernel offset
+  total_kernel_offset, err := util.Static_secp_instance().Blind_sum(
+    []secp.SecretKey{secp.SecretKey(mk_tx.Offset)},
+    []secp.SecretKey{secp.SecretKey(tx.Offset)})
+  if err != nil {
+    return Transaction{}, TransactionError{Kind: TxSecpErr, Msg: err.Error()}
+  }
+
+  result := New_transaction(inputs, outputs, kernels).With_offset(keychain.BlindingFactor(total_kernel_offset))
+  if err := result.Validate(); err != nil {
+    return Transaction{}, err
+  }
+  return result, nil
+}
+
+/// Total fee for a transaction is the sum of fees of all kernels.
+func (self *Transaction) Fee() uint64 {
+  fee := uint64(0)
+  for i := range self.Kernels {
+    fee += self.Kernels[i].Fee
+  }
+  return fee
+}
+
+/// The transaction overage, its fee, is committed to as an output.
+func (self *Transaction) Overage() int64 {
+  return int64(self.Fee())
+}
+
+/// Lock height of a transaction is the max lock height of the kernels.
+func (self *Transaction) Lock_height() uint64 {
+  lock_height := uint64(0)
+  for i := range self.Kernels {
+    if self.Kernels[i].Lock_height > lock_height {
+      lock_height = self.Kernels[i].Lock_height
+    }
+  }
+  return lock_height
+}
+
+/// Calculate transaction weight
+func (self *Transaction) Weight() int {
+  return consensus.Body_weight(len(self.Inputs), len(self.Outputs), len(self.Kernels))
+}
+
+/// Validates all relevant parts of a fully built transaction. Checks the
+/// excess value against the signature as well as range proofs for each
+/// output.
+func (self *Transaction) Validate() error {
+  if err := self.verify_features(); err != nil {
+    return err
+  }
+  if err := validate_body(self.Inputs, self.Outputs, self.Kernels, false); err != nil {
+    return err
+  }
+  if _, _, err := self.Verify_kernel_sums(self.Overage(), self.Offset); err != nil {
+    return tx_committed_error(err)
+  }
+  return nil
+}
+
+/// Verify we have no invalid outputs or kernels in the transaction
+/// due to invalid features.
+/// Specifically, a transaction cannot contain a coinbase output or a
+/// coinbase kernel.
+func (self *Transaction) verify_features() error {
+  for i := range self.Outputs {
+    if self.Outputs[i].Features&COINBASE_OUTPUT != 0 {
+      return TransactionError{Kind: InvalidOutputFeatures, Msg: fmt.Sprintf("coinbase output %x", self.Outputs[i].Commit[:])}
+    }
+  }
+  for i := range self.Kernels {
+    if self.Kernels[i].Features&COINBASE_KERNEL != 0 {
+      return TransactionError{Kind: InvalidKernelFeatures, Msg: fmt.Sprintf("coinbase kernel %x", self.Kernels[i].Excess[:])}
+    }
+  }
+  return nil
+}
+
+/// Validates the body of a transaction or a block, without the kernel sums
+/// which depend on the overage and offset. The reward output and kernel are
+/// reserved for when checking the weight of a transaction, so it always
+/// fits in a block.
+func validate_body(inputs []Input, outputs []Output, kernels []TxKernel, with_reward bool) error {
+  if err := verify_weight(inputs, outputs, kernels, with_reward); err != nil {
+    return err
+  }
+  if err := verify_sorted(inputs, outputs, kernels); err != nil {
+    return err
+  }
+  if err := verify_cut_through(inputs, outputs); err != nil {
+    return err
+  }
+  if err := Batch_verify_proofs(outputs); err != nil {
+    return TransactionError{Kind: InvalidRangeProof, Msg: err.Error()}
+  }
+  if err := Verify_kernel_sigs(kernels); err != nil {
+    return TransactionError{Kind: IncorrectSignature, Msg: err.Error()}
+  }
+  return nil
+}
+
+/// Verify the body is not too big in terms of number of inputs|outputs|kernels.
+func verify_weight(inputs []Input, outputs []Output, kernels []TxKernel, with_reward bool) error {
+  // if as_block check the body as if it was a block, with an additional
+  // output and kernel for reward
+  reserve := 1
+  if with_reward {
+    reserve = 0
+  }
+  weight := consensus.Body_weight(len(inputs), len(outputs)+reserve, len(kernels)+reserve)
+  if weight > consensus.MAX_BLOCK_WEIGHT {
+    return TransactionError{Kind: TooHeavy, Msg: fmt.Sprintf("weight %d over %d", weight, consensus.MAX_BLOCK_WEIGHT)}
+  }
+  return nil
+}
+
+/// Verify the inputs, outputs and kernels are sorted by hash, without
+/// duplicates.
+func verify_sorted(inputs []Input, outputs []Output, kernels []TxKernel) error {
+  if err := verify_sorted_unique("inputs", len(inputs), func(i int) Hash { return inputs[i].Hash() }); err != nil {
+    return err
+  }
+  if err := verify_sorted_unique("outputs", len(outputs), func(i int) Hash { return outputs[i].Hash() }); err != nil {
+    return err
+  }
+  return verify_sorted_unique("kernels", len(kernels), func(i int) Hash { return kernels[i].Hash() })
+}
+
+/// Checks the n hashes are in strictly increasing order, equal hashes being
+/// duplicates.
+func verify_sorted_unique(what string, n int, hash_at func(i int) Hash) error {
+  for i := 1; i < n; i++ {
+    prev, cur := hash_at(i-1), hash_at(i)
+    if cur == prev {
+      return TransactionError{Kind: DuplicateError, Msg: fmt.Sprintf("%s: %s at %d", what, cur, i)}
+    }
+    if cur.Less(prev) {
+      return TransactionError{Kind: SortOrder, Msg: fmt.Sprintf("%s: %s at %d", what, cur, i)}
+    }
+  }
+  return nil
+}
+
+/// Verify that no input is spending an output from the same block or
+/// transaction, nor two outputs share the same commitment.
+func verify_cut_through(inputs []Input, outputs []Output) error {
+  commits := make(map[secp.Commitment]bool, len(outputs))
+  for i := range outputs {
+    if commits[outputs[i].Commit] {
+      return TransactionError{Kind: DuplicateError, Msg: fmt.Sprintf("output commitment %x", outputs[i].Commit[:])}
+    }
+    commits[outputs[i].Commit] = true
+  }
+  for i := range inputs {
+    if commits[inputs[i].Commit] {
+      return TransactionError{Kind: CutThrough, Msg: fmt.Sprintf("input spends output %x", inputs[i].Commit[:])}
+    }
+  }
+  return nil
+}
+
+/// Translates the error of the kernel sums verification.
+func tx_committed_error(err error) error {
+  if e, ok := err.(CommittedError); ok {
+    switch e.Kind {
+    case KernelSumMismatch:
+      return TransactionError{Kind: TxKernelSumMismatch}
+    case CommittedKeychainErr:
+      return TransactionError{Kind: TxKeychainErr, Msg: e.Msg}
+    }
+  }
+  return TransactionError{Kind: TxSecpErr, Msg: err.Error()}
+}
+
+/// Writes the body of a transaction or a block. Consensus rule that
+/// everything is sorted in lexicographical order (of their hashes) on the
+/// wire.
+func write_body(writer consensus.Writer, inputs []Input, outputs []Output, kernels []TxKernel) error {
+  if err := writer.Write_u64(uint64(len(inputs))); err != nil {
+    return err
+  }
+  if err := writer.Write_u64(uint64(len(outputs))); err != nil {
+    return err
+  }
+  if err := writer.Write_u64(uint64(len(kernels))); err != nil {
+    return err
+  }
+  for _, input := range sort_inputs(inputs) {
+    if err := input.Write(writer); err != nil {
+      return err
+    }
+  }
+  for _, output := range sort_outputs(outputs) {
+    if err := output.Write(writer); err != nil {
+      return err
+    }
+  }
+  for _, kernel := range sort_kernels(kernels) {
+    if err := kernel.Write(writer); err != nil {
+      return err
+    }
+  }
+  return nil
+}
+
+/// Reads the inputs, outputs and kernels making up the body of a
+/// transaction or a block, given their announced counts. Each list must be
+/// sorted by hash, as mandated by consensus.
+func read_body(reader consensus.Reader, input_len, output_len, kernel_len uint64) ([]Input, []Output, []TxKernel, error) {
+  inputs := []Input{}
+  err := consensus.Read_multi(reader, input_len, func(reader consensus.Reader) error {
+    var input Input
+    if err := input.Read(reader); err != nil {
+      return err
+    }
+    inputs = append(inputs, input)
+    return nil
+  })
+  if err != nil {
+    return nil, nil, nil, err
+  }
+
+  outputs := []Output{}
+  err = consensus.Read_multi(reader, output_len, func(reader consensus.Reader) error {
+    var output Output
+    if err := output.Read(reader); err != nil {
+      return err
+    }
+    outputs = append(outputs, output)
+    return nil
+  })
+  if err != nil {
+    return nil, nil, nil, err
+  }
+
+  kernels := []TxKernel{}
+  err = consensus.Read_multi(reader, kernel_len, func(reader consensus.Reader) error {
+    var kernel TxKernel
+    if err := kernel.Read(reader); err != nil {
+      return err
+    }
+    kernels = append(kernels, kernel)
+    return nil
+  })
+  if err != nil {
+    return nil, nil, nil, err
+  }
+
+  if err := verify_sort_order(len(inputs), func(i int) Hash { return inputs[i].Hash() }); err != nil {
+    return nil, nil, nil, err
+  }
+  if err := verify_sort_order(len(outputs), func(i int) Hash { return outputs[i].Hash() }); err != nil {
+    return nil, nil, nil, err
+  }
+  if err := verify_sort_order(len(kernels), func(i int) Hash { return kernels[i].Hash() }); err != nil {
+    return nil, nil, nil, err
+  }
+
+  return inputs, outputs, kernels, nil
+}
+
+/// Returns a copy of the inputs sorted by hash.
+func sort_inputs(inputs []Input) []Input {
+  sorted := append([]Input{}, inputs...)
+  sort.Slice(sorted, func(i, j int) bool { return sorted[i].Hash().Less(sorted[j].Hash()) })
+  return sorted
+}
+
+/// Returns a copy of the outputs sorted by hash.
+func sort_outputs(outputs []Output) []Output {
+  sorted := append([]Output{}, outputs...)
+  sort.Slice(sorted, func(i, j int) bool { return sorted[i].Hash().Less(sorted[j].Hash()) })
+  return sorted
+}
+
+/// Returns a copy of the kernels sorted by hash.
+func sort_kernels(kernels []TxKernel) []TxKernel {
+  sorted := append([]TxKernel{}, kernels...)
+  sort.Slice(sorted, func(i, j int) bool { return sorted[i].Hash().Less(sorted[j].Hash()) })
+  return sorted
+}
+
+/// Checks the n hashes produced by hash_at are in (non strictly) increasing
+/// order.
+func verify_sort_order(n int, hash_at func(i int) Hash) error {
+  for i := 1; i < n; i++ {
+    if hash_at(i).Less(hash_at(i - 1)) {
+      return consensus.Error{Kind: consensus.ConsensusError, Msg: "badly sorted data"}
+    }
+  }
+  return nil
 }
 
 /// A transaction input.
@@ -49,7 +615,49 @@ type Input struct {
   /// We will check maturity for coinbase output.
   Features OutputFeatures
   /// The commit referencing the output being spent.
-  Commit pedersen.Commitment
+  Commit secp.Commitment
+}
+
+/// Implementation of Writeable for a transaction Input, defines how to write
+/// an Input as binary.
+func (self *Input) Write(writer consensus.Writer) error {
+  if err := writer.Write_u8(uint8(self.Features)); err != nil {
+    return err
+  }
+  return consensus.Write_commitment(writer, self.Commit)
+}
+
+/// Implementation of Readable for a transaction Input, defines how to read
+/// an Input from a binary stream.
+func (self *Input) Read(reader consensus.Reader) error {
+  features, err := read_output_features(reader)
+  if err != nil {
+    return err
+  }
+  commit, err := consensus.Read_commitment(reader)
+  if err != nil {
+    return err
+  }
+  self.Features = features
+  self.Commit = commit
+  return nil
+}
+
+/// The hash of an input is the hash of its features and commitment.
+func (self *Input) Hash() Hash {
+  return Hash_writeable(self)
+}
+
+/// Reads the output features flag, rejecting unknown bits.
+func read_output_features(reader consensus.Reader) (OutputFeatures, error) {
+  features, err := reader.Read_u8()
+  if err != nil {
+    return 0, err
+  }
+  if OutputFeatures(features) > COINBASE_OUTPUT {
+    return 0, consensus.Error{Kind: consensus.CorruptedData}
+  }
+  return OutputFeatures(features), nil
 }
 
 /// An output_identifier can be build from either an input _or_ an output and
@@ -62,7 +670,40 @@ type OutputIdentifier struct {
   /// enforced.
   Features OutputFeatures
   /// Output commitment
-  Commit pedersen.Commitment
+  Commit secp.Commitment
+}
+
+/// Write the output identifier.
+func (self *OutputIdentifier) Write(writer consensus.Writer) error {
+  if err := writer.Write_u8(uint8(self.Features)); err != nil {
+    return err
+  }
+  return consensus.Write_commitment(writer, self.Commit)
+}
+
+/// Read an output identifier from the provided reader.
+func (self *OutputIdentifier) Read(reader consensus.Reader) error {
+  features, err := read_output_features(reader)
+  if err != nil {
+    return err
+  }
+  commit, err := consensus.Read_commitment(reader)
+  if err != nil {
+    return err
+  }
+  self.Features = features
+  self.Commit = commit
+  return nil
+}
+
+/// The hash of an output identifier, same as the corresponding output.
+func (self *OutputIdentifier) Hash() Hash {
+  return Hash_writeable(self)
+}
+
+/// Hash with the MMR index, as stored in the output MMR.
+func (self *OutputIdentifier) Hash_with_index(index uint64) Hash {
+  return Hash_with_index(self, index)
 }
 
 /// Output for a transaction, defining the new ownership of coins that are being
@@ -77,7 +718,155 @@ type Output struct {
   /// Options for an output's structure or use
   Features OutputFeatures
   /// The homomorphic commitment representing the output amount
-  Commit pedersen.Commitment
+  Commit secp.Commitment
   /// A proof that the commitment is in the right range
-  Proof pedersen.RangeProof
+  Proof secp.RangeProof
+}
+
+/// Implementation of Writeable for a transaction Output, defines how to write
+/// an Output as binary.
+func (self *Output) Write(writer consensus.Writer) error {
+  if err := writer.Write_u8(uint8(self.Features)); err != nil {
+    return err
+  }
+  if err := consensus.Write_commitment(writer, self.Commit); err != nil {
+    return err
+  }
+  // The hash of an output doesn't include the range proof, which
+  // is committed to separately
+  if writer.Serialization_mode() != consensus.SERIALIZATION_HASH {
+    return consensus.Write_range_proof(writer, self.Proof)
+  }
+  return nil
+}
+
+/// Implementation of Readable for a transaction Output, defines how to read
+/// an Output from a binary stream.
+func (self *Output) Read(reader consensus.Reader) error {
+  features, err := read_output_features(reader)
+  if err != nil {
+    return err
+  }
+  commit, err := consensus.Read_commitment(reader)
+  if err != nil {
+    return err
+  }
+  proof, err := consensus.Read_range_proof(reader)
+  if err != nil {
+    return err
+  }
+  self.Features = features
+  self.Commit = commit
+  self.Proof = proof
+  return nil
+}
+
+/// Whether both outputs have the same features, commitment and proof.
+func (self *Output) Eq(other *Output) bool {
+  return self.Features == other.Features && self.Commit == other.Commit && self.Proof.ProofLen == other.Proof.ProofLen && bytes.Equal(self.Proof.Proof, other.Proof.Proof)
+}
+
+/// The hash of an output only covers its features and commitment, the range
+/// proof is committed to separately.
+func (self *Output) Hash() Hash {
+  return Hash_writeable(self)
+}
+
+/// Gather the kernel excesses and sum them.
+func (self *Transaction) Sum_kernel_excesses(offset *keychain.BlindingFactor) (secp.Commitment, secp.Commitment, error) {
+  return Sum_kernel_excesses(self, offset)
+}
+
+/// Gathers commitments and sum them.
+func (self *Transaction) Sum_commitments(overage int64) (secp.Commitment, error) {
+  return Sum_commitments(self, overage)
+}
+
+/// The commitments of the inputs being spent.
+func (self *Transaction) Inputs_committed() []secp.Commitment {
+  commits := make([]secp.Commitment, len(self.Inputs))
+  for i := range self.Inputs {
+    commits[i] = self.Inputs[i].Commit
+  }
+  return commits
+}
+
+/// The commitments of the outputs being created.
+func (self *Transaction) Outputs_committed() []secp.Commitment {
+  commits := make([]secp.Commitment, len(self.Outputs))
+  for i := range self.Outputs {
+    commits[i] = self.Outputs[i].Commit
+  }
+  return commits
+}
+
+/// The kernel excesses.
+func (self *Transaction) Kernels_committed() []secp.Commitment {
+  commits := make([]secp.Commitment, len(self.Kernels))
+  for i := range self.Kernels {
+    commits[i] = self.Kernels[i].Excess
+  }
+  return commits
+}
+
+/// Verify the kernel sums, the transaction balancing out with its fee as
+/// (positive) overage.
+func (self *Transaction) Verify_kernel_sums(overage int64, kernel_offset keychain.BlindingFactor) (secp.Commitment, secp.Commitment, error) {
+  return Verify_kernel_sums(self, overage, kernel_offset)
+}
+
+/// A range proof as stored in the range proof MMR, next to the output with
+/// the same position in the output MMR.
+type RangeProof struct {
+  secp.RangeProof
+}
+
+/// Writes the proof, length prefixed.
+func (self *RangeProof) Write(writer consensus.Writer) error {
+  return consensus.Write_range_proof(writer, self.RangeProof)
+}
+
+/// Reads a length prefixed proof.
+func (self *RangeProof) Read(reader consensus.Reader) error {
+  proof, err := consensus.Read_range_proof(reader)
+  if err != nil {
+    return err
+  }
+  self.RangeProof = proof
+  return nil
+}
+
+/// Hash with the MMR index, as stored in the range proof MMR.
+func (self *RangeProof) Hash_with_index(index uint64) Hash {
+  return Hash_with_index(self, index)
+}
+
+/// Validates the range proof using the commitment
+func (self *Output) Verify_proof() error {
+  secp_ctx := util.Static_secp_instance()
+  _, err := secp_ctx.Verify_bullet_proof(self.Commit, self.Proof, nil)
+  return err
+}
+
+/// Batch validates the range proofs of the given outputs, the returned error
+/// being a secp.BatchProofError identifying the first invalid one.
+func Batch_verify_proofs(outputs []Output) error {
+  commits := make([]secp.Commitment, len(outputs))
+  proofs := make([]secp.RangeProof, len(outputs))
+  for i := range outputs {
+    commits[i] = outputs[i].Commit
+    proofs[i] = outputs[i].Proof
+  }
+  return Batch_verify_commit_proofs(commits, proofs)
+}
+
+/// Batch validates range proofs against their commitments, as they're stored
+/// separately in the output and range proof MMRs.
+func Batch_verify_commit_proofs(commits []secp.Commitment, proofs []secp.RangeProof) error {
+  if len(commits) == 0 {
+    return nil
+  }
+  secp_ctx := util.Static_secp_instance()
+  _, err := secp_ctx.Verify_bullet_proof_multi(commits, proofs, nil)
+  return err
 }
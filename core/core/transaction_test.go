@@ -0,0 +1,193 @@
+package core
+
+import (
+  "bytes"
+  "testing"
+
+  ser "github.com/kelby/go-grin/core"
+  "github.com/kelby/go-grin/keychain"
+  "github.com/kelby/go-grin/secp"
+  "github.com/kelby/go-grin/util"
+)
+
+/// A value and the blinding factor committing to it, spent as an input or
+/// created as an output by the test transactions.
+type test_coin struct {
+  value uint64
+  blind secp.SecretKey
+}
+
+func new_test_coin(value uint64) test_coin {
+  return test_coin{value: value, blind: secp.Random_secret_key()}
+}
+
+func (self test_coin) input(t *testing.T) Input {
+  commit, err := util.Static_secp_instance().Commit(self.value, self.blind)
+  if err != nil {
+    t.Fatal(err)
+  }
+  return Input{Features: DEFAULT_OUTPUT, Commit: commit}
+}
+
+func (self test_coin) output(t *testing.T) Output {
+  secp_ctx := util.Static_secp_instance()
+  commit, err := secp_ctx.Commit(self.value, self.blind)
+  if err != nil {
+    t.Fatal(err)
+  }
+  proof, err := secp_ctx.Bullet_proof(self.value, self.blind, secp.Random_secret_key(), secp.Random_secret_key(), nil, nil)
+  if err != nil {
+    t.Fatal(err)
+  }
+  return Output{Features: DEFAULT_OUTPUT, Commit: commit, Proof: proof}
+}
+
+/// Builds a transaction spending the ins to create the outs, paying the
+/// difference as fee. The excess is split between the kernel and a random
+/// offset.
+func build_test_tx(t *testing.T, ins []test_coin, outs []test_coin) Transaction {
+  secp_ctx := util.Static_secp_instance()
+  inputs := []Input{}
+  outputs := []Output{}
+  in_blinds := []secp.SecretKey{}
+  out_blinds := []secp.SecretKey{}
+  fee := uint64(0)
+  for _, coin := range ins {
+    inputs = append(inputs, coin.input(t))
+    in_blinds = append(in_blinds, coin.blind)
+    fee += coin.value
+  }
+  for _, coin := range outs {
+    outputs = append(outputs, coin.output(t))
+    out_blinds = append(out_blinds, coin.blind)
+    fee -= coin.value
+  }
+
+  offset := secp.Random_secret_key()
+  excess_blind, err := secp_ctx.Blind_sum(out_blinds, append(in_blinds, offset))
+  if err != nil {
+    t.Fatal(err)
+  }
+  excess, err := secp_ctx.Commit(0, excess_blind)
+  if err != nil {
+    t.Fatal(err)
+  }
+  kernel := TxKernel{Features: DEFAULT_KERNEL, Fee: fee, Excess: excess}
+  msg := Kernel_sig_msg(kernel.Fee, kernel.Lock_height)
+  kernel.Excess_sig, err = secp_ctx.Sign_single(&msg, &excess_blind, nil, nil, nil)
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  return New_transaction(inputs, outputs, []TxKernel{kernel}).With_offset(keychain.Blinding_factor_from_secret_key(offset))
+}
+
+func Test_transaction_validate(t *testing.T) {
+  tx := build_test_tx(t, []test_coin{new_test_coin(10), new_test_coin(5)}, []test_coin{new_test_coin(7), new_test_coin(6)})
+  if err := tx.Validate(); err != nil {
+    t.Fatal(err)
+  }
+  if tx.Fee() != 2 {
+    t.Fatalf("fee %d, expected 2", tx.Fee())
+  }
+
+  // the kernel signs the fee, and the fee balances the sums
+  tampered := tx
+  tampered.Kernels = []TxKernel{tx.Kernels[0]}
+  tampered.Kernels[0].Fee = 3
+  if err := tampered.Validate(); err == nil {
+    t.Fatal("transaction with a tampered fee validated")
+  }
+  tampered = tx.With_offset(keychain.Blinding_factor_from_secret_key(secp.Random_secret_key()))
+  err := tampered.Validate()
+  if e, ok := err.(TransactionError); !ok || e.Kind != TxKernelSumMismatch {
+    t.Fatalf("expected a kernel sum mismatch, got %v", err)
+  }
+}
+
+func Test_transaction_ser_unsorted(t *testing.T) {
+  tx := build_test_tx(t, []test_coin{new_test_coin(10)}, []test_coin{new_test_coin(4), new_test_coin(5)})
+  vec, err := ser.Ser_vec(&tx)
+  if err != nil {
+    t.Fatal(err)
+  }
+  var read Transaction
+  if err := ser.Deserialize(bytes.NewReader(vec), &read); err != nil {
+    t.Fatal(err)
+  }
+  if !read.Eq(&tx) {
+    t.Fatal("transaction changed by a round trip")
+  }
+
+  // the writer always sorts, swap the two outputs on the wire
+  unsorted := tx
+  unsorted.Outputs = []Output{tx.Outputs[1], tx.Outputs[0]}
+  err = unsorted.Validate()
+  if e, ok := err.(TransactionError); !ok || e.Kind != SortOrder {
+    t.Fatalf("expected a sort order error, got %v", err)
+  }
+  first, _ := ser.Ser_vec(&tx.Outputs[0])
+  second, _ := ser.Ser_vec(&tx.Outputs[1])
+  start := bytes.Index(vec, first)
+  if start < 0 || !bytes.Equal(vec[start+len(first):start+len(first)+len(second)], second) {
+    t.Fatal("outputs not found in the serialized transaction")
+  }
+  swapped := append(append(append(append([]byte{}, vec[:start]...), second...), first...), vec[start+len(first)+len(second):]...)
+  err = ser.Deserialize(bytes.NewReader(swapped), &read)
+  if e, ok := err.(ser.Error); !ok || e.Kind != ser.ConsensusError {
+    t.Fatalf("expected a consensus error, got %v", err)
+  }
+}
+
+func Test_aggregate_deaggregate(t *testing.T) {
+  tx1 := build_test_tx(t, []test_coin{new_test_coin(10)}, []test_coin{new_test_coin(8)})
+  tx2 := build_test_tx(t, []test_coin{new_test_coin(20)}, []test_coin{new_test_coin(15), new_test_coin(2)})
+
+  agg, err := Aggregate([]Transaction{tx1, tx2})
+  if err != nil {
+    t.Fatal(err)
+  }
+  if err := agg.Validate(); err != nil {
+    t.Fatal(err)
+  }
+  if len(agg.Inputs) != 2 || len(agg.Outputs) != 3 || len(agg.Kernels) != 2 || agg.Fee() != 5 {
+    t.Fatalf("aggregated %d inputs, %d outputs, %d kernels and a fee of %d", len(agg.Inputs), len(agg.Outputs), len(agg.Kernels), agg.Fee())
+  }
+
+  tx, err := Deaggregate(agg, []Transaction{tx1})
+  if err != nil {
+    t.Fatal(err)
+  }
+  if !tx.Eq(&tx2) {
+    t.Fatal("deaggregated transaction differs from the second one")
+  }
+}
+
+func Test_aggregate_cut_through(t *testing.T) {
+  // the second transaction spends the output of the first one
+  spent := new_test_coin(8)
+  tx1 := build_test_tx(t, []test_coin{new_test_coin(10)}, []test_coin{spent})
+  tx2 := build_test_tx(t, []test_coin{spent}, []test_coin{new_test_coin(5)})
+
+  agg, err := Aggregate([]Transaction{tx1, tx2})
+  if err != nil {
+    t.Fatal(err)
+  }
+  if len(agg.Inputs) != 1 || agg.Inputs[0] != tx1.Inputs[0] {
+    t.Fatal("spent input not cut through")
+  }
+  if len(agg.Outputs) != 1 || !agg.Outputs[0].Eq(&tx2.Outputs[0]) {
+    t.Fatal("spent output not cut through")
+  }
+  if len(agg.Kernels) != 2 || agg.Fee() != 5 {
+    t.Fatalf("aggregated %d kernels and a fee of %d", len(agg.Kernels), agg.Fee())
+  }
+}
+
+func Test_aggregate_duplicate_outputs(t *testing.T) {
+  tx := build_test_tx(t, []test_coin{new_test_coin(10)}, []test_coin{new_test_coin(8)})
+  _, err := Aggregate([]Transaction{tx, tx})
+  if e, ok := err.(TransactionError); !ok || e.Kind != AggregationError {
+    t.Fatalf("expected an aggregation error, got %v", err)
+  }
+}
@@ -1,3 +1,14 @@
+package core
+
+import (
+  "encoding/binary"
+  "fmt"
+
+  "github.com/kelby/go-grin/secp"
+  "github.com/kelby/go-grin/util"
+
+  ser "github.com/kelby/go-grin/core"
+)
 
 /// A proof that a transaction sums to zero. Includes both the transaction's
 /// Pedersen commitment and the signature, that guarantees that the commitments
@@ -16,29 +27,148 @@ type TxKernel struct {
   /// Remainder of the sum of all transaction commitments. If the transaction
   /// is well formed, amounts components should sum to zero and the excess
   /// is hence a valid public key.
-  Excess pedersen.Commitment
+  Excess secp.Commitment
   /// The signature proving the excess is a valid public key, which signs
   /// the transaction fee.
   Excess_sig secp.Signature
 }
 
-/// Return the excess commitment for this tx_kernel.
-func (self *TxKernel) Excess() pedersen.Commitment {
-  self.Excess
+/// Construct msg bytes from tx fee and lock_height, the fee and the
+/// lock_height as big-endian u64s in the last 16 bytes of the message.
+/// The kernel features aren't part of the signed message.
+func Kernel_sig_msg(fee uint64, lock_height uint64) secp.Message {
+  var msg secp.Message
+  binary.BigEndian.PutUint64(msg[16:24], fee)
+  binary.BigEndian.PutUint64(msg[24:], lock_height)
+  return msg
+}
+
+/// The message signed by this kernel
+func (self *TxKernel) Msg_to_sign() secp.Message {
+  return Kernel_sig_msg(self.Fee, self.Lock_height)
 }
 
 /// Verify the transaction proof validity. Entails handling the commitment
 /// as a public key and checking the signature verifies with the fee as
 /// message.
-func (self *TxKernel) Verify() -> Result<(), secp::Error> {
-  let msg = Message::from_slice(&kernel_sig_msg(self.Fee, self.Lock_height))?;
-  let secp = static_secp_instance();
-  let secp = secp.lock().unwrap();
-  let sig = &self.excess_sig;
-  // Verify aggsig directly in libsecp
-  let pubkey = &self.excess.to_pubkey(&secp)?;
-  if !secp::aggsig::verify_single(&secp, &sig, &msg, None, &pubkey, false) {
-    return Err(secp::Error::IncorrectSignature);
-  }
-  Ok(())
+func (self *TxKernel) Verify() error {
+  msg := self.Msg_to_sign()
+  secp_ctx := util.Static_secp_instance()
+  pubkey, err := self.Excess.To_pubkey()
+  if err != nil {
+    return err
+  }
+  if !secp_ctx.Verify_single(&self.Excess_sig, &msg, nil, &pubkey, false) {
+    return secp.IncorrectSignature
+  }
+  return nil
+}
+
+/// Batch verification of the kernel signatures, a lot faster than verifying
+/// them one by one. When the batch fails, the kernels are verified
+/// individually to find the invalid one, the returned error being a
+/// KernelSigError.
+func Verify_kernel_sigs(kernels []TxKernel) error {
+  sigs := make([]secp.Signature, len(kernels))
+  msgs := make([]secp.Message, len(kernels))
+  pubkeys := make([]secp.PublicKey, len(kernels))
+  for i := range kernels {
+    pubkey, err := kernels[i].Excess.To_pubkey()
+    if err != nil {
+      return KernelSigError{Index: i, Err: err}
+    }
+    sigs[i] = kernels[i].Excess_sig
+    msgs[i] = kernels[i].Msg_to_sign()
+    pubkeys[i] = pubkey
+  }
+
+  secp_ctx := util.Static_secp_instance()
+  if secp_ctx.Verify_batch(sigs, msgs, pubkeys) {
+    return nil
+  }
+  for i := range kernels {
+    if err := kernels[i].Verify(); err != nil {
+      return KernelSigError{Index: i, Err: err}
+    }
+  }
+  return secp.IncorrectSignature
+}
+
+/// Error returned by the batch kernel verification, identifying the first
+/// kernel with an invalid signature.
+type KernelSigError struct {
+  /// Index of the kernel
+  Index int
+  /// The underlying secp error
+  Err error
+}
+
+func (self KernelSigError) Error() string {
+  return fmt.Sprintf("kernel %d: %s", self.Index, self.Err.Error())
+}
+
+/// Write the kernel as binary, the signature in its compact form.
+func (self *TxKernel) Write(writer ser.Writer) error {
+  if err := writer.Write_u8(uint8(self.Features)); err != nil {
+    return err
+  }
+  if err := writer.Write_u64(self.Fee); err != nil {
+    return err
+  }
+  if err := writer.Write_u64(self.Lock_height); err != nil {
+    return err
+  }
+  if err := ser.Write_commitment(writer, self.Excess); err != nil {
+    return err
+  }
+  return ser.Write_signature(writer, self.Excess_sig)
+}
+
+/// Read a kernel from the provided reader.
+func (self *TxKernel) Read(reader ser.Reader) error {
+  features, err := reader.Read_u8()
+  if err != nil {
+    return err
+  }
+  if KernelFeatures(features) > COINBASE_KERNEL {
+    return ser.Error{Kind: ser.CorruptedData}
+  }
+  fee, err := reader.Read_u64()
+  if err != nil {
+    return err
+  }
+  lock_height, err := reader.Read_u64()
+  if err != nil {
+    return err
+  }
+  excess, err := ser.Read_commitment(reader)
+  if err != nil {
+    return err
+  }
+  excess_sig, err := ser.Read_signature(reader)
+  if err != nil {
+    return err
+  }
+  self.Features = KernelFeatures(features)
+  self.Fee = fee
+  self.Lock_height = lock_height
+  self.Excess = excess
+  self.Excess_sig = excess_sig
+  return nil
+}
+
+/// The kernel hash, as used for sorting and in the kernel MMR.
+func (self *TxKernel) Hash() Hash {
+  return Hash_writeable(self)
+}
+
+/// Hash with the MMR index, as stored in the kernel MMR.
+func (self *TxKernel) Hash_with_index(index uint64) Hash {
+  return Hash_with_index(self, index)
+}
+
+/// The short id of the kernel, for the block with the given hash and the
+/// connection specific nonce.
+func (self *TxKernel) Short_id(hash *Hash, nonce uint64) ShortId {
+  return Short_id(self, hash, nonce)
 }
@@ -0,0 +1,63 @@
+package core
+
+import (
+  "encoding/hex"
+  "testing"
+
+  "github.com/kelby/go-grin/secp"
+  "github.com/kelby/go-grin/util"
+)
+
+func Test_kernel_sig_msg(t *testing.T) {
+  tests := []struct {
+    fee         uint64
+    lock_height uint64
+    msg         string
+  }{
+    {0, 0, "0000000000000000000000000000000000000000000000000000000000000000"},
+    {1, 0, "0000000000000000000000000000000000000000000000010000000000000000"},
+    {0, 1, "0000000000000000000000000000000000000000000000000000000000000001"},
+    {8000000, 100, "00000000000000000000000000000000" + "00000000007a12000000000000000064"},
+    {0x0102030405060708, 0xffffffffffffffff, "00000000000000000000000000000000" + "0102030405060708ffffffffffffffff"},
+  }
+  for _, test := range tests {
+    msg := Kernel_sig_msg(test.fee, test.lock_height)
+    if hex.EncodeToString(msg[:]) != test.msg {
+      t.Errorf("kernel_sig_msg(%d, %d) = %x, expected %s", test.fee, test.lock_height, msg, test.msg)
+    }
+  }
+}
+
+func Test_kernel_verify(t *testing.T) {
+  secp_ctx := util.Static_secp_instance()
+  blind := secp.Random_secret_key()
+  excess, err := secp_ctx.Commit(0, blind)
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  kernel := TxKernel{Features: DEFAULT_KERNEL, Fee: 2, Lock_height: 10, Excess: excess}
+  msg := Kernel_sig_msg(kernel.Fee, kernel.Lock_height)
+  kernel.Excess_sig, err = secp_ctx.Sign_single(&msg, &blind, nil, nil, nil)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if err := kernel.Verify(); err != nil {
+    t.Fatal(err)
+  }
+  if err := Verify_kernel_sigs([]TxKernel{kernel, kernel}); err != nil {
+    t.Fatal(err)
+  }
+
+  // the signature commits to the fee and the lock height
+  tampered := kernel
+  tampered.Fee = 3
+  if err := tampered.Verify(); err == nil {
+    t.Fatal("kernel with a different fee verified")
+  }
+  tampered = kernel
+  tampered.Lock_height = 11
+  if err := Verify_kernel_sigs([]TxKernel{kernel, tampered}); err == nil {
+    t.Fatal("batch with a different lock height verified")
+  }
+}
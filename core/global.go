@@ -0,0 +1,181 @@
+package core
+
+/// Values that should be shared across all modules, without necessarily
+/// having to pass them all over the place, but aren't consensus values.
+/// should be used sparingly.
+
+import (
+  "sync"
+)
+
+/// Automated testing sizeshift
+const AUTOMATED_TESTING_MIN_SIZESHIFT uint8 = 10
+
+/// Automated testing proof size
+const AUTOMATED_TESTING_PROOF_SIZE = 4
+
+/// User testing sizeshift
+const USER_TESTING_MIN_SIZESHIFT uint8 = 16
+
+/// User testing proof size
+const USER_TESTING_PROOF_SIZE = 42
+
+/// Automated testing coinbase maturity
+const AUTOMATED_TESTING_COINBASE_MATURITY uint64 = 3
+
+/// User testing coinbase maturity
+const USER_TESTING_COINBASE_MATURITY uint64 = 3
+
+/// Testing cut through horizon in blocks
+const TESTING_CUT_THROUGH_HORIZON uint32 = 20
+
+/// Testing initial block difficulty
+const TESTING_INITIAL_DIFFICULTY uint64 = 1
+
+/// Testnet initial block difficulty
+const TESTNET_INITIAL_DIFFICULTY uint64 = 1000
+
+/// Types of chain a server can run with, dictates the genesis block
+/// and mining parameters used.
+type ChainTypes int
+
+const (
+  /// For CI testing
+  AutomatedTesting ChainTypes = iota
+  /// For User testing
+  UserTesting
+  /// Public testnet
+  Testnet
+  /// Main production network
+  Mainnet
+)
+
+func (self ChainTypes) String() string {
+  switch self {
+  case AutomatedTesting:
+    return "automated_testing"
+  case UserTesting:
+    return "user_testing"
+  case Testnet:
+    return "testnet"
+  case Mainnet:
+    return "mainnet"
+  }
+  return "unknown"
+}
+
+/// The mining parameter mode, set once at startup and read everywhere.
+var chain_type = Mainnet
+var chain_type_lock sync.RWMutex
+
+/// Set the mining mode
+func Set_mining_mode(mode ChainTypes) {
+  chain_type_lock.Lock()
+  defer chain_type_lock.Unlock()
+  chain_type = mode
+}
+
+/// The mining mode currently set
+func Chain_type() ChainTypes {
+  chain_type_lock.RLock()
+  defer chain_type_lock.RUnlock()
+  return chain_type
+}
+
+/// The minimum acceptable sizeshift
+func Min_sizeshift() uint8 {
+  switch Chain_type() {
+  case AutomatedTesting:
+    return AUTOMATED_TESTING_MIN_SIZESHIFT
+  case UserTesting:
+    return USER_TESTING_MIN_SIZESHIFT
+  }
+  return DEFAULT_MIN_SIZESHIFT
+}
+
+/// Reference sizeshift used to compute factor on higher Cuckoo graph sizes,
+/// while the min_sizeshift can be changed on a soft fork, changing
+/// ref_sizeshift is a hard fork.
+func Ref_sizeshift() uint8 {
+  switch Chain_type() {
+  case AutomatedTesting:
+    return AUTOMATED_TESTING_MIN_SIZESHIFT
+  case UserTesting:
+    return USER_TESTING_MIN_SIZESHIFT
+  }
+  return REFERENCE_SIZESHIFT
+}
+
+/// Get the proofsize
+func Proofsize() int {
+  switch Chain_type() {
+  case AutomatedTesting:
+    return AUTOMATED_TESTING_PROOF_SIZE
+  case UserTesting:
+    return USER_TESTING_PROOF_SIZE
+  }
+  return PROOFSIZE
+}
+
+/// Coinbase maturity for coinbases to be spent
+func Coinbase_maturity() uint64 {
+  switch Chain_type() {
+  case AutomatedTesting:
+    return AUTOMATED_TESTING_COINBASE_MATURITY
+  case UserTesting:
+    return USER_TESTING_COINBASE_MATURITY
+  }
+  return COINBASE_MATURITY
+}
+
+/// Initial mining difficulty
+func Initial_block_difficulty() uint64 {
+  switch Chain_type() {
+  case AutomatedTesting, UserTesting:
+    return TESTING_INITIAL_DIFFICULTY
+  case Testnet:
+    return TESTNET_INITIAL_DIFFICULTY
+  }
+  return INITIAL_DIFFICULTY
+}
+
+/// Horizon at which we can cut-through and do full local pruning
+func Cut_through_horizon() uint32 {
+  switch Chain_type() {
+  case AutomatedTesting, UserTesting:
+    return TESTING_CUT_THROUGH_HORIZON
+  }
+  return CUT_THROUGH_HORIZON
+}
+
+/// Are we in automated testing mode?
+func Is_automated_testing_mode() bool {
+  return Chain_type() == AutomatedTesting
+}
+
+/// Are we in user testing mode?
+func Is_user_testing_mode() bool {
+  return Chain_type() == UserTesting
+}
+
+/// Are we in production mode (a live public network)?
+func Is_production_mode() bool {
+  switch Chain_type() {
+  case Testnet, Mainnet:
+    return true
+  }
+  return false
+}
+
+/// Helper function to get a nonce known to create a valid POW on
+/// the genesis block, to prevent it taking ages. Should be fine for now
+/// as the genesis block POW solution turns out to be the same for every new
+/// block chain at the moment
+func Get_genesis_nonce() uint64 {
+  switch Chain_type() {
+  case AutomatedTesting, UserTesting:
+    // won't make a difference
+    return 0
+  }
+  return 1000000
+}
@@ -1,3 +1,18 @@
+package pow
+
+/// Implementation of Cuckoo Cycle designed by John Tromp. Ported to Rust from
+/// the C and Java code at https://github.com/tromp/cuckoo. Note that only the
+/// simple miner is included, mostly for testing purposes. John Tromp's Tomato
+/// miner will be much faster in almost every environment.
+
+import (
+  "encoding/binary"
+
+  "golang.org/x/crypto/blake2b"
+
+  "github.com/kelby/go-grin/core/core"
+)
+
 /// An edge in the Cuckoo graph, simply references two u64 nodes.
 // #[derive(Debug, Copy, Clone, PartialEq, PartialOrd, Eq, Ord, Hash)]
 type Edge struct {
@@ -12,14 +27,263 @@ type Cuckoo struct {
   V [4]uint64
 }
 
+/// Initializes a new Cuckoo Cycle setup, using the provided byte array to
+/// generate a seed. In practice for PoW applications the byte array is a
+/// serialized block header.
+func New_cuckoo(header []byte, sizeshift uint8) Cuckoo {
+  hashed := blake2b.Sum256(header)
+  return Cuckoo_from_hash(hashed[:], sizeshift)
+}
+
+/// Initializes a new Cuckoo Cycle setup from an already hashed header (the
+/// pre-pow hash of a block header), its first 32 bytes being read as the 4
+/// little endian siphash keys.
+func Cuckoo_from_hash(hashed []byte, sizeshift uint8) Cuckoo {
+  var v [4]uint64
+  for n := range v {
+    v[n] = binary.LittleEndian.Uint64(hashed[n*8 : n*8+8])
+  }
+  size := uint64(1) << sizeshift
+  return Cuckoo{
+    V:    v,
+    Size: size,
+    Mask: size/2 - 1,
+  }
+}
+
+/// Generates a node in the cuckoo graph generated from our seed. A node is
+/// simply materialized as a u64 from a nonce and an offset (generally 0 or
+/// 1).
+func (self *Cuckoo) New_node(nonce uint64, uorv uint64) uint64 {
+  return ((Siphash24(self.V, 2*nonce+uorv) & self.Mask) << 1) | uorv
+}
+
+/// Creates a new edge in the cuckoo graph generated by our seed from a
+/// nonce. Generates two node coordinates from the nonce and links them
+/// together.
+func (self *Cuckoo) New_edge(nonce uint64) Edge {
+  return Edge{
+    U: self.New_node(nonce, 0),
+    V: self.New_node(nonce, 1),
+  }
+}
+
+/// Assuming increasing nonces all smaller than easiness, verifies the
+/// nonces form a cycle in a Cuckoo graph. Each nonce generates an edge, we
+/// build the nodes on both side of that edge and count the connections.
+func (self *Cuckoo) Verify(proof *core.Proof, ease uint64) bool {
+  // split to not overflow on the largest graphs
+  easiness := ease*(self.Size/100) + ease*(self.Size%100)/100
+  nonces := proof.Nonces
+  proof_size := proof.Proof_size()
+  us := make([]uint64, proof_size)
+  vs := make([]uint64, proof_size)
+  for n := 0; n < proof_size; n++ {
+    if nonces[n] >= easiness || (n != 0 && nonces[n] <= nonces[n-1]) {
+      return false
+    }
+    us[n] = self.New_node(nonces[n], 0)
+    vs[n] = self.New_node(nonces[n], 1)
+  }
+
+  i := 0
+  count := proof_size
+  for {
+    j := i
+    for k := 0; k < proof_size; k++ {
+      // find unique other j with same vs[j]
+      if k != i && vs[k] == vs[i] {
+        if j != i {
+          return false
+        }
+        j = k
+      }
+    }
+    if j == i {
+      return false
+    }
+    i = j
+    for k := 0; k < proof_size; k++ {
+      // find unique other i with same us[i]
+      if k != j && us[k] == us[j] {
+        if i != j {
+          return false
+        }
+        i = k
+      }
+    }
+    if i == j {
+      return false
+    }
+    count -= 2
+    if i == 0 {
+      break
+    }
+  }
+  return count == 0
+}
+
 /// Miner for the Cuckoo Cycle algorithm. While the verifier will work for
 /// graph sizes up to a u64, the miner is limited to u32 to be more memory
 /// compact (so shift <= 32). Non-optimized for now and and so mostly used for
 /// tests, being impractical with sizes greater than 2^22.
 type Miner struct {
-  Easiness uint64
-  Proof_size usize
-  Cuckoo Cuckoo
-  Graph []uint32
-  Sizeshift uint8
+  Easiness   uint64
+  Proof_size int
+  Cuckoo     Cuckoo
+  Graph      []uint32
+  Sizeshift  uint8
+}
+
+/// What type of cycle we have found?
+type cycle_sol int
+
+const (
+  /// A cycle of the right length is a valid proof.
+  valid_proof cycle_sol = iota
+  /// A cycle of the wrong length is great, but not a proof.
+  invalid_cycle
+  /// No cycles have been found.
+  no_cycle
+)
+
+/// The maximum length of a path followed in the graph.
+const MAXPATHLEN = 4096
+
+/// Creates a new miner, the graph being seeded by the (pre-pow) hash of the
+/// header.
+func New_miner(hash []byte, ease uint32, proof_size int, sizeshift uint8) Miner {
+  cuckoo := Cuckoo_from_hash(hash, sizeshift)
+  size := uint64(1) << sizeshift
+  return Miner{
+    Easiness:   uint64(ease) * size / 100,
+    Proof_size: proof_size,
+    Cuckoo:     cuckoo,
+    Graph:      make([]uint32, size+1),
+    Sizeshift:  sizeshift,
+  }
+}
+
+/// Searches for a solution
+func (self *Miner) Mine() (core.Proof, error) {
+  us := make([]uint32, MAXPATHLEN)
+  vs := make([]uint32, MAXPATHLEN)
+  for nonce := uint64(0); nonce < self.Easiness; nonce++ {
+    us[0] = uint32(self.Cuckoo.New_node(nonce, 0))
+    vs[0] = uint32(self.Cuckoo.New_node(nonce, 1))
+    if us[0] == 0 {
+      // 0 is the nil node of the graph, an edge from it can't be followed
+      continue
+    }
+    u := self.Graph[us[0]]
+    v := self.Graph[vs[0]]
+    if u == vs[0] || v == us[0] {
+      // ignore duplicate edges
+      continue
+    }
+    nu, err := self.path(u, us)
+    if err != nil {
+      return core.Proof{}, err
+    }
+    nv, err := self.path(v, vs)
+    if err != nil {
+      return core.Proof{}, err
+    }
+
+    sol, res := self.find_sol(nu, us, nv, vs)
+    switch sol {
+    case valid_proof:
+      proof := core.New_proof(res)
+      proof.Cuckoo_sizeshift = self.Sizeshift
+      return proof, nil
+    case invalid_cycle:
+      continue
+    case no_cycle:
+      self.update_graph(nu, us, nv, vs)
+    }
+  }
+  return core.Proof{}, Error{Kind: NoSolution}
+}
+
+/// Follows the path of the graph from u, storing the visited nodes in us.
+func (self *Miner) path(u uint32, us []uint32) (int, error) {
+  nu := 0
+  for u != 0 {
+    nu += 1
+    if nu >= MAXPATHLEN {
+      for nu != 0 && us[nu-1] != u {
+        nu -= 1
+      }
+      return 0, Error{Kind: PathError}
+    }
+    us[nu] = u
+    u = self.Graph[u]
+  }
+  return nu, nil
+}
+
+/// Reverses the shortest of both paths and links the new edge to it.
+func (self *Miner) update_graph(nu int, us []uint32, nv int, vs []uint32) {
+  if nu < nv {
+    for nu != 0 {
+      nu -= 1
+      self.Graph[us[nu+1]] = us[nu]
+    }
+    self.Graph[us[0]] = vs[0]
+  } else {
+    for nv != 0 {
+      nv -= 1
+      self.Graph[vs[nv+1]] = vs[nv]
+    }
+    self.Graph[vs[0]] = us[0]
+  }
+}
+
+/// Checks whether both paths end at the same node, closing a cycle, and
+/// whether that cycle has the length of a proof.
+func (self *Miner) find_sol(nu int, us []uint32, nv int, vs []uint32) (cycle_sol, []uint64) {
+  if us[nu] != vs[nv] {
+    return no_cycle, nil
+  }
+  min := nu
+  if nv < min {
+    min = nv
+  }
+  nu -= min
+  nv -= min
+  for us[nu] != vs[nv] {
+    nu += 1
+    nv += 1
+  }
+  if nu+nv+1 != self.Proof_size {
+    return invalid_cycle, nil
+  }
+  return self.solution(us, nu, vs, nv)
+}
+
+/// Recovers the nonces of the edges of the cycle.
+func (self *Miner) solution(us []uint32, nu int, vs []uint32, nv int) (cycle_sol, []uint64) {
+  cycle := make(map[Edge]bool)
+  cycle[Edge{U: uint64(us[0]), V: uint64(vs[0])}] = true
+  for nu != 0 {
+    nu -= 1
+    cycle[Edge{U: uint64(us[(nu+1)&^1]), V: uint64(us[nu|1])}] = true
+  }
+  for nv != 0 {
+    nv -= 1
+    cycle[Edge{U: uint64(vs[nv|1]), V: uint64(vs[(nv+1)&^1])}] = true
+  }
+
+  sol := make([]uint64, 0, self.Proof_size)
+  for nonce := uint64(0); nonce < self.Easiness; nonce++ {
+    edge := self.Cuckoo.New_edge(nonce)
+    if cycle[edge] {
+      sol = append(sol, nonce)
+      delete(cycle, edge)
+    }
+    if len(sol) == self.Proof_size {
+      return valid_proof, sol
+    }
+  }
+  return invalid_cycle, nil
 }
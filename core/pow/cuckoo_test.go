@@ -0,0 +1,104 @@
+package pow
+
+import (
+  "testing"
+
+  "golang.org/x/crypto/blake2b"
+
+  consensus "github.com/kelby/go-grin/core"
+  "github.com/kelby/go-grin/core/core"
+)
+
+/// Mines the graphs seeded by a few fixed hashes, returning the proofs
+/// found by hash index. Only some graphs have a 4-cycle, 12 of the first
+/// 40 at size 12.
+func mine_test_graphs(t *testing.T, count int, sizeshift uint8) map[int]core.Proof {
+  proofs := make(map[int]core.Proof)
+  for i := 0; i < count; i++ {
+    hash := blake2b.Sum256([]byte{byte(i)})
+    miner := New_miner(hash[:], consensus.EASINESS, 4, sizeshift)
+    proof, err := miner.Mine()
+    if err != nil {
+      if e, ok := err.(Error); !ok || e.Kind != NoSolution {
+        t.Fatal(err)
+      }
+      continue
+    }
+    proofs[i] = proof
+  }
+  return proofs
+}
+
+func Test_mine_verify(t *testing.T) {
+  proofs := mine_test_graphs(t, 40, 12)
+  if len(proofs) == 0 {
+    t.Fatal("no proof found in 40 graphs")
+  }
+  for i, proof := range proofs {
+    hash := blake2b.Sum256([]byte{byte(i)})
+    cuckoo := Cuckoo_from_hash(hash[:], 12)
+    if proof.Cuckoo_sizeshift != 12 || proof.Proof_size() != 4 {
+      t.Fatalf("proof of size %d at sizeshift %d", proof.Proof_size(), proof.Cuckoo_sizeshift)
+    }
+    if !cuckoo.Verify(&proof, uint64(consensus.EASINESS)) {
+      t.Fatalf("proof %v mined on hash %d rejected", proof.Nonces, i)
+    }
+
+    // any other nonce breaks the cycle
+    changed := core.New_proof(proof.Nonces)
+    changed.Nonces[0] ^= 1
+    if cuckoo.Verify(&changed, uint64(consensus.EASINESS)) {
+      t.Fatalf("proof %v with a changed nonce verified", changed.Nonces)
+    }
+    changed = core.New_proof(proof.Nonces)
+    changed.Nonces[3] += 1
+    if cuckoo.Verify(&changed, uint64(consensus.EASINESS)) {
+      t.Fatalf("proof %v with a changed nonce verified", changed.Nonces)
+    }
+    // the nonces must be sorted
+    unsorted := core.New_proof(proof.Nonces)
+    unsorted.Nonces[0], unsorted.Nonces[1] = unsorted.Nonces[1], unsorted.Nonces[0]
+    if cuckoo.Verify(&unsorted, uint64(consensus.EASINESS)) {
+      t.Fatalf("unsorted proof %v verified", unsorted.Nonces)
+    }
+    // and the graph is the one of the hash
+    other := Cuckoo_from_hash(hash[:], 13)
+    if other.Verify(&proof, uint64(consensus.EASINESS)) {
+      t.Fatal("proof verified on a larger graph")
+    }
+  }
+}
+
+func Test_verify_size(t *testing.T) {
+  mode := consensus.Chain_type()
+  consensus.Set_mining_mode(consensus.AutomatedTesting)
+  defer consensus.Set_mining_mode(mode)
+
+  header := core.Default()
+  for {
+    hash := header.Pre_pow_hash()
+    miner := New_miner(hash[:], consensus.EASINESS, consensus.Proofsize(), 12)
+    if proof, err := miner.Mine(); err == nil {
+      header.Pow = proof
+      break
+    }
+    header.Nonce += 1
+  }
+  if !Verify_size(&header, 12) {
+    t.Fatal("mined header rejected")
+  }
+  if Verify_size(&header, 13) {
+    t.Fatal("header verified with another graph size")
+  }
+
+  changed := header
+  changed.Nonce += 1
+  if Verify_size(&changed, 12) {
+    t.Fatal("proof verified for another header")
+  }
+  changed = header
+  changed.Pow = core.New_proof(append(header.Pow.Nonces, 1<<20))
+  if Verify_size(&changed, 12) {
+    t.Fatal("proof with the wrong number of nonces verified")
+  }
+}
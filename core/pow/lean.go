@@ -0,0 +1,337 @@
+package pow
+
+/// Lean edge trimming miner, after John Tromp's lean miner. Instead of
+/// building the whole graph, the edges that can't be part of a cycle get
+/// trimmed first: any edge with an endpoint of degree one is a dead end and
+/// is removed, alternating between both sides of the bipartite graph. Only
+/// a bit per edge and two bits per node are kept, which makes it practical
+/// for realistic graph sizes. The few edges remaining after trimming are
+/// then searched for cycles. Both the trimming rounds and the search
+/// itself are split across threads.
+
+import (
+  "runtime"
+  "sync"
+  "sync/atomic"
+  "time"
+
+  consensus "github.com/kelby/go-grin/core"
+  "github.com/kelby/go-grin/core/core"
+)
+
+/// How often (in edges) the trimming threads check the stop flag
+const STOP_CHECK_INTERVAL = 1 << 16
+
+/// Solver trimming the graph edges in parallel before looking for cycles.
+type LeanSolver struct {
+  Proof_size int
+  Sizeshift  uint8
+  /// Number of threads trimming the edges
+  Nthreads int
+  /// Maximum number of trimming rounds, trimming stops earlier when a round
+  /// didn't remove any edge
+  Ntrims int
+  stats  SolverStats
+}
+
+/// Creates a lean solver for the given proof and graph sizes, using all the
+/// available CPUs when nthreads is zero.
+func New_lean_solver(proof_size int, sizeshift uint8, nthreads int) *LeanSolver {
+  if nthreads < 1 {
+    nthreads = runtime.NumCPU()
+  }
+  ntrims := 68
+  if sizeshift > 30 {
+    ntrims = 96
+  }
+  return &LeanSolver{
+    Proof_size: proof_size,
+    Sizeshift:  sizeshift,
+    Nthreads:   nthreads,
+    Ntrims:     ntrims,
+  }
+}
+
+func (self *LeanSolver) Solve(hash []byte, stop *atomic.Bool) (core.Proof, error) {
+  start := time.Now()
+  proof, err := self.solve(hash, stop)
+  self.stats.record(start, err)
+  return proof, err
+}
+
+func (self *LeanSolver) Stats() SolverStats {
+  return self.stats
+}
+
+func (self *LeanSolver) solve(hash []byte, stop *atomic.Bool) (core.Proof, error) {
+  if stop == nil {
+    stop = new(atomic.Bool)
+  }
+  cuckoo := Cuckoo_from_hash(hash, self.Sizeshift)
+  easiness := uint64(consensus.EASINESS) * cuckoo.Size / 100
+
+  trimmer := new_trimmer(&cuckoo, easiness, self.Nthreads)
+  alive := easiness
+  for round := 0; round < self.Ntrims; round++ {
+    for uorv := uint64(0); uorv < 2; uorv++ {
+      if !trimmer.trim(uorv, stop) {
+        return core.Proof{}, Error{Kind: Stopped}
+      }
+    }
+    count := trimmer.count()
+    if count == alive {
+      break
+    }
+    alive = count
+  }
+
+  return find_cycle(&cuckoo, trimmer.alive_nonces(), self.Proof_size, self.Sizeshift)
+}
+
+/// Bit set whose bits can be set concurrently.
+type bitmap []uint64
+
+func new_bitmap(size uint64) bitmap {
+  return make(bitmap, (size+63)/64)
+}
+
+func (self bitmap) test(i uint64) bool {
+  return self[i/64]&(1<<(i%64)) != 0
+}
+
+/// Atomically sets the bit, returning whether it was already set.
+func (self bitmap) set(i uint64) bool {
+  word := &self[i/64]
+  bit := uint64(1) << (i % 64)
+  for {
+    old := atomic.LoadUint64(word)
+    if old&bit != 0 {
+      return true
+    }
+    if atomic.CompareAndSwapUint64(word, old, old|bit) {
+      return false
+    }
+  }
+}
+
+/// Clears the bit, not safe against concurrent access of the same word.
+func (self bitmap) reset(i uint64) {
+  self[i/64] &^= 1 << (i % 64)
+}
+
+func (self bitmap) clear() {
+  for i := range self {
+    self[i] = 0
+  }
+}
+
+/// Edge trimming state: a bit per edge telling whether it's still alive and
+/// two bits per node on one side of the graph to count degrees up to 2.
+type trimmer struct {
+  cuckoo   *Cuckoo
+  easiness uint64
+  nthreads int
+  alive    bitmap
+  once     bitmap
+  twice    bitmap
+}
+
+func new_trimmer(cuckoo *Cuckoo, easiness uint64, nthreads int) *trimmer {
+  alive := new_bitmap(easiness)
+  for nonce := uint64(0); nonce < easiness; nonce++ {
+    alive.set(nonce)
+  }
+  return &trimmer{
+    cuckoo:   cuckoo,
+    easiness: easiness,
+    nthreads: nthreads,
+    alive:    alive,
+    once:     new_bitmap(cuckoo.Size / 2),
+    twice:    new_bitmap(cuckoo.Size / 2),
+  }
+}
+
+/// Runs f over the nonces in parallel, each thread being given a range of
+/// whole bitmap words so the alive bits can be cleared without locking.
+/// Returns false if the stop flag got set.
+func (self *trimmer) parallel(stop *atomic.Bool, f func(nonce uint64)) bool {
+  words := (self.easiness + 63) / 64
+  per_thread := (words + uint64(self.nthreads) - 1) / uint64(self.nthreads)
+
+  var wg sync.WaitGroup
+  for t := uint64(0); t < uint64(self.nthreads); t++ {
+    from := t * per_thread * 64
+    to := (t + 1) * per_thread * 64
+    if to > self.easiness {
+      to = self.easiness
+    }
+    if from >= to {
+      break
+    }
+    wg.Add(1)
+    go func(from uint64, to uint64) {
+      defer wg.Done()
+      for nonce := from; nonce < to; nonce++ {
+        if nonce%STOP_CHECK_INTERVAL == 0 && stop.Load() {
+          return
+        }
+        if self.alive.test(nonce) {
+          f(nonce)
+        }
+      }
+    }(from, to)
+  }
+  wg.Wait()
+  return !stop.Load()
+}
+
+/// Trims the alive edges with a node of degree one on the given side.
+func (self *trimmer) trim(uorv uint64, stop *atomic.Bool) bool {
+  self.once.clear()
+  self.twice.clear()
+
+  // count the node degrees up to 2
+  counted := self.parallel(stop, func(nonce uint64) {
+    node := self.cuckoo.New_node(nonce, uorv) >> 1
+    if self.once.set(node) {
+      self.twice.set(node)
+    }
+  })
+  if !counted {
+    return false
+  }
+
+  // and kill the edges leading to a leaf
+  return self.parallel(stop, func(nonce uint64) {
+    node := self.cuckoo.New_node(nonce, uorv) >> 1
+    if !self.twice.test(node) {
+      self.alive.reset(nonce)
+    }
+  })
+}
+
+/// Number of edges still alive.
+func (self *trimmer) count() uint64 {
+  var count uint64
+  for nonce := uint64(0); nonce < self.easiness; nonce++ {
+    if self.alive.test(nonce) {
+      count += 1
+    }
+  }
+  return count
+}
+
+/// Nonces of the edges still alive, in increasing order.
+func (self *trimmer) alive_nonces() []uint64 {
+  nonces := []uint64{}
+  for nonce := uint64(0); nonce < self.easiness; nonce++ {
+    if self.alive.test(nonce) {
+      nonces = append(nonces, nonce)
+    }
+  }
+  return nonces
+}
+
+/// Looks for a cycle of proof_size length among the given edges, the same
+/// way the reference miner does but with a sparse graph.
+func find_cycle(cuckoo *Cuckoo, nonces []uint64, proof_size int, sizeshift uint8) (core.Proof, error) {
+  graph := make(map[uint64]uint64)
+  us := make([]uint64, MAXPATHLEN)
+  vs := make([]uint64, MAXPATHLEN)
+
+  for _, nonce := range nonces {
+    edge := cuckoo.New_edge(nonce)
+    us[0] = edge.U
+    vs[0] = edge.V
+    if u, ok := graph[us[0]]; ok && u == vs[0] {
+      // ignore duplicate edges
+      continue
+    }
+    if v, ok := graph[vs[0]]; ok && v == us[0] {
+      continue
+    }
+    nu, ok_u := sparse_path(graph, us)
+    nv, ok_v := sparse_path(graph, vs)
+    if !ok_u || !ok_v {
+      continue
+    }
+
+    if us[nu] == vs[nv] {
+      min := nu
+      if nv < min {
+        min = nv
+      }
+      nu -= min
+      nv -= min
+      for us[nu] != vs[nv] {
+        nu += 1
+        nv += 1
+      }
+      if nu+nv+1 == proof_size {
+        return cycle_proof(cuckoo, nonces, us, nu, vs, nv, proof_size, sizeshift)
+      }
+      // a cycle of the wrong length, leave the graph as is
+      continue
+    }
+
+    // no cycle, reverse the shortest path and add the edge
+    if nu < nv {
+      for nu != 0 {
+        nu -= 1
+        graph[us[nu+1]] = us[nu]
+      }
+      graph[us[0]] = vs[0]
+    } else {
+      for nv != 0 {
+        nv -= 1
+        graph[vs[nv+1]] = vs[nv]
+      }
+      graph[vs[0]] = us[0]
+    }
+  }
+  return core.Proof{}, Error{Kind: NoSolution}
+}
+
+/// Follows the path from us[0], returns false when it's too long.
+func sparse_path(graph map[uint64]uint64, us []uint64) (int, bool) {
+  nu := 0
+  u, ok := graph[us[0]]
+  for ok {
+    nu += 1
+    if nu >= MAXPATHLEN {
+      return 0, false
+    }
+    us[nu] = u
+    u, ok = graph[u]
+  }
+  return nu, true
+}
+
+/// Recovers the nonces of the cycle edges and builds the proof.
+func cycle_proof(cuckoo *Cuckoo, nonces []uint64, us []uint64, nu int, vs []uint64, nv int, proof_size int, sizeshift uint8) (core.Proof, error) {
+  cycle := make(map[Edge]bool)
+  cycle[Edge{U: us[0], V: vs[0]}] = true
+  for nu != 0 {
+    nu -= 1
+    cycle[Edge{U: us[(nu+1)&^1], V: us[nu|1]}] = true
+  }
+  for nv != 0 {
+    nv -= 1
+    cycle[Edge{U: vs[nv|1], V: vs[(nv+1)&^1]}] = true
+  }
+
+  sol := make([]uint64, 0, proof_size)
+  for _, nonce := range nonces {
+    edge := cuckoo.New_edge(nonce)
+    if cycle[edge] {
+      sol = append(sol, nonce)
+      delete(cycle, edge)
+    }
+  }
+  if len(sol) != proof_size {
+    return core.Proof{}, Error{Kind: NoSolution}
+  }
+  proof := core.New_proof(sol)
+  proof.Cuckoo_sizeshift = sizeshift
+  return proof, nil
+}
@@ -0,0 +1,58 @@
+package pow
+
+import (
+  "testing"
+
+  "golang.org/x/crypto/blake2b"
+
+  consensus "github.com/kelby/go-grin/core"
+)
+
+/// Both solvers must agree on whether the graph of each hash has a cycle,
+/// the lean one searching with several threads.
+func Test_lean_solver_matches_ref(t *testing.T) {
+  tests := []struct {
+    sizeshift uint8
+    graphs    int
+  }{
+    {12, 40},
+    {16, 40},
+    // the larger graphs take about a second each
+    {20, 10},
+  }
+  if testing.Short() {
+    tests = tests[:2]
+  }
+  for _, test := range tests {
+    sizeshift := test.sizeshift
+    ref := New_ref_solver(4, sizeshift)
+    lean := New_lean_solver(4, sizeshift, 4)
+    found := 0
+    for i := 0; i < test.graphs; i++ {
+      hash := blake2b.Sum256([]byte{byte(i)})
+      ref_proof, ref_err := ref.Solve(hash[:], nil)
+      lean_proof, lean_err := lean.Solve(hash[:], nil)
+      if (ref_err == nil) != (lean_err == nil) {
+        t.Fatalf("sizeshift %d, hash %d: ref found %v (%v), lean found %v (%v)", sizeshift, i, ref_proof.Nonces, ref_err, lean_proof.Nonces, lean_err)
+      }
+      if lean_err != nil {
+        if e, ok := lean_err.(Error); !ok || e.Kind != NoSolution {
+          t.Fatal(lean_err)
+        }
+        continue
+      }
+      found += 1
+      cuckoo := Cuckoo_from_hash(hash[:], sizeshift)
+      if lean_proof.Cuckoo_sizeshift != sizeshift || !cuckoo.Verify(&lean_proof, uint64(consensus.EASINESS)) {
+        t.Fatalf("sizeshift %d, hash %d: lean proof %v rejected", sizeshift, i, lean_proof.Nonces)
+      }
+    }
+    if found == 0 && test.graphs == 40 {
+      t.Fatalf("no proof found at sizeshift %d", sizeshift)
+    }
+    stats := lean.Stats()
+    if stats.Graphs != uint64(test.graphs) || stats.Solutions != uint64(found) {
+      t.Fatalf("lean solver searched %d graphs with %d solutions, expected %d and %d", stats.Graphs, stats.Solutions, test.graphs, found)
+    }
+  }
+}
@@ -1,11 +1,113 @@
+package pow
+
+/// The proof of work needs to strike a balance between fast header
+/// verification to avoid DoS attacks and difficulty for block verifiers to
+/// build new blocks. In addition, mining new blocks should also be as
+/// difficult on high end custom-made hardware (ASICs) as on commodity hardware
+/// or smartphones. For this reason we use Cuckoo Cycle (see the cuckoo
+/// module for more information).
+
+import (
+  "time"
+
+  consensus "github.com/kelby/go-grin/core"
+  "github.com/kelby/go-grin/core/core"
+)
+
+type ErrorKind int
+
+/// Errors from the miners
+const (
+  /// No solution could be found in the whole graph
+  NoSolution ErrorKind = iota
+  /// A path in the graph grew longer than MAXPATHLEN
+  PathError
+  /// The search was interrupted by the stop flag
+  Stopped
+)
+
+/// Error definition
+type Error struct {
+  Kind ErrorKind
+  Msg  string
+}
+
+func (self Error) Error() string {
+  switch self.Kind {
+  case NoSolution:
+    return "no solution found"
+  case PathError:
+    return "path too long"
+  case Stopped:
+    return "search stopped"
+  }
+  return "unknown pow error"
+}
+
 /// Validates the proof of work of a given header, and that the proof of work
-/// satisfies the requirements of the header.
-func Verify_size(bh &BlockHeader, cuckoo_sz uint8) bool
+/// satisfies the requirements of the header: the expected number of nonces,
+/// ascending and in range, forming a single cycle in the graph of the given
+/// size seeded by the header pre-pow hash.
+func Verify_size(bh *core.BlockHeader, cuckoo_sz uint8) bool {
+  if cuckoo_sz == 0 || cuckoo_sz > 63 || bh.Pow.Proof_size() != consensus.Proofsize() {
+    return false
+  }
+  hash := bh.Pre_pow_hash()
+  cycle := Cuckoo_from_hash(hash[:], cuckoo_sz)
+  return cycle.Verify(&bh.Pow, uint64(consensus.EASINESS))
+}
 
 /// Mines a genesis block using the internal miner
-func Mine_genesis_block() (Block, error)
+func Mine_genesis_block() (core.Block, error) {
+  gen := core.Genesis_block()
+  if consensus.Is_user_testing_mode() || consensus.Is_automated_testing_mode() {
+    gen.Header.Timestamp = time.Now().UTC().Truncate(time.Second)
+  }
+
+  // total_difficulty on the genesis header *is* the difficulty of that block
+  genesis_difficulty := gen.Header.Total_difficulty
+  sz := consensus.Min_sizeshift()
+  proof_size := consensus.Proofsize()
+  if err := Pow_size(&gen.Header, genesis_difficulty, proof_size, sz); err != nil {
+    return core.Block{}, err
+  }
+  return gen, nil
+}
 
 /// Runs a proof of work computation over the provided block using the provided
 /// Mining Worker, until the required difficulty target is reached. May take a
 /// while for a low target...
-func Pow_size(bh &BlockHeader, diff Difficulty, proof_size usize, sz uint8) error
+func Pow_size(bh *core.BlockHeader, diff core.Difficulty, proof_size int, sz uint8) error {
+  start_nonce := bh.Nonce
+
+  // set the nonce for faster solution finding in user testing
+  if bh.Height == 0 && consensus.Is_user_testing_mode() {
+    bh.Nonce = consensus.Get_genesis_nonce()
+  }
+
+  // try to find a cuckoo cycle on that header hash
+  for {
+    // can be trivially optimized by avoiding re-serialization every time but
+    // this is not meant as a fast miner implementation
+    pow_hash := bh.Pre_pow_hash()
+
+    // if we found a cycle (not guaranteed) and the proof hash is higher that
+    // the diff, we're all good
+    miner := New_miner(pow_hash[:], consensus.EASINESS, proof_size, sz)
+    if proof, err := miner.Mine(); err == nil {
+      if proof.To_difficulty().Cmp(diff) >= 0 {
+        bh.Pow = proof
+        return nil
+      }
+    }
+
+    // otherwise increment the nonce
+    bh.Nonce += 1
+
+    // and if we're back where we started, update the time (changes the hash
+    // as well)
+    if bh.Nonce == start_nonce {
+      bh.Timestamp = time.Unix(0, 0).UTC()
+    }
+  }
+}
@@ -0,0 +1,49 @@
+package pow
+
+/// Simple implementation of the siphash 2-4 hashing function from
+/// Jean-Philippe Aumasson and Daniel J. Bernstein, specialized for the
+/// Cuckoo Cycle graph: the key is already expanded into the 4 state words
+/// and the message is a single 64 bits nonce.
+
+import (
+  "math/bits"
+)
+
+/// Implements siphash 2-4 specialized for a 4 u64 array key and a u64 nonce
+func Siphash24(v [4]uint64, nonce uint64) uint64 {
+  v0, v1, v2, v3 := v[0], v[1], v[2], v[3]^nonce
+
+  // macro'd for performance in the reference implementation, inlined by
+  // the compiler here
+  round := func() {
+    v0 += v1
+    v2 += v3
+    v1 = bits.RotateLeft64(v1, 13)
+    v3 = bits.RotateLeft64(v3, 16)
+    v1 ^= v0
+    v3 ^= v2
+    v0 = bits.RotateLeft64(v0, 32)
+    v2 += v1
+    v0 += v3
+    v1 = bits.RotateLeft64(v1, 17)
+    v3 = bits.RotateLeft64(v3, 21)
+    v1 ^= v2
+    v3 ^= v0
+    v2 = bits.RotateLeft64(v2, 32)
+  }
+
+  // 2 rounds
+  round()
+  round()
+
+  v0 ^= nonce
+  v2 ^= 0xff
+
+  // and then 4 rounds, hence siphash 2-4
+  round()
+  round()
+  round()
+  round()
+
+  return v0 ^ v1 ^ v2 ^ v3
+}
@@ -0,0 +1,25 @@
+package pow
+
+import (
+  "testing"
+)
+
+/// Some test vectors hoisted from the Java implementation (and already
+/// used by the reference siphash tests)
+func Test_hash_some(t *testing.T) {
+  tests := []struct {
+    v        [4]uint64
+    nonce    uint64
+    expected uint64
+  }{
+    {[4]uint64{1, 2, 3, 4}, 10, 928382149599306901},
+    {[4]uint64{1, 2, 3, 4}, 111, 10524991083049122233},
+    {[4]uint64{9, 7, 6, 7}, 12, 1305683875471634734},
+    {[4]uint64{9, 7, 6, 7}, 10, 11589833042187638814},
+  }
+  for _, test := range tests {
+    if hash := Siphash24(test.v, test.nonce); hash != test.expected {
+      t.Errorf("siphash24(%v, %d) = %d, expected %d", test.v, test.nonce, hash, test.expected)
+    }
+  }
+}
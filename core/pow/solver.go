@@ -0,0 +1,103 @@
+package pow
+
+/// Pluggable cuckoo cycle solvers, so the mining code doesn't have to care
+/// which miner actually looks for cycles in the graphs.
+
+import (
+  "fmt"
+  "sync/atomic"
+  "time"
+
+  consensus "github.com/kelby/go-grin/core"
+  "github.com/kelby/go-grin/core/core"
+)
+
+/// A cuckoo cycle solver, searching the graph seeded by a (pre-pow) header
+/// hash for a cycle of the proof size.
+type Solver interface {
+  /// Searches the graph seeded by the hash for a proof. Returns a
+  /// NoSolution error when the graph has no cycle of the right length, or a
+  /// Stopped one when the stop flag got set during the search.
+  Solve(hash []byte, stop *atomic.Bool) (core.Proof, error)
+
+  /// Statistics accumulated by the solver over all its searches.
+  Stats() SolverStats
+}
+
+/// Statistics of a solver
+type SolverStats struct {
+  /// Number of graphs fully searched
+  Graphs uint64
+  /// Number of proofs found
+  Solutions uint64
+  /// Total time spent searching
+  Elapsed time.Duration
+}
+
+/// Number of graphs searched per second, the usual measure of a cuckoo
+/// cycle miner speed.
+func (self *SolverStats) Graphs_per_sec() float64 {
+  if self.Elapsed <= 0 {
+    return 0
+  }
+  return float64(self.Graphs) / self.Elapsed.Seconds()
+}
+
+/// Number of proofs found per second.
+func (self *SolverStats) Sols_per_sec() float64 {
+  if self.Elapsed <= 0 {
+    return 0
+  }
+  return float64(self.Solutions) / self.Elapsed.Seconds()
+}
+
+/// Records a search ending with the given error.
+func (self *SolverStats) record(start time.Time, err error) {
+  self.Elapsed += time.Since(start)
+  if err == nil {
+    self.Solutions += 1
+  }
+  if e, ok := err.(Error); err == nil || ok && e.Kind == NoSolution {
+    self.Graphs += 1
+  }
+}
+
+/// Solver backed by the reference (single threaded) miner. Only practical
+/// for small graphs, the stop flag is only checked between searches.
+type RefSolver struct {
+  Proof_size int
+  Sizeshift  uint8
+  stats      SolverStats
+}
+
+/// Creates a reference solver for the given proof and graph sizes.
+func New_ref_solver(proof_size int, sizeshift uint8) *RefSolver {
+  return &RefSolver{Proof_size: proof_size, Sizeshift: sizeshift}
+}
+
+func (self *RefSolver) Solve(hash []byte, stop *atomic.Bool) (core.Proof, error) {
+  if stop != nil && stop.Load() {
+    return core.Proof{}, Error{Kind: Stopped}
+  }
+  start := time.Now()
+  miner := New_miner(hash, consensus.EASINESS, self.Proof_size, self.Sizeshift)
+  proof, err := miner.Mine()
+  self.stats.record(start, err)
+  return proof, err
+}
+
+func (self *RefSolver) Stats() SolverStats {
+  return self.stats
+}
+
+/// Builds the solver from its plugin name, either "ref" for the reference
+/// miner or "lean" for the multi-threaded lean edge trimming miner.
+func New_solver(plugin string, proof_size int, sizeshift uint8, nthreads int) (Solver, error) {
+  switch plugin {
+  case "ref":
+    return New_ref_solver(proof_size, sizeshift), nil
+  case "lean":
+    return New_lean_solver(proof_size, sizeshift, nthreads), nil
+  }
+  return nil, fmt.Errorf("unknown solver plugin %s", plugin)
+}
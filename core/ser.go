@@ -0,0 +1,407 @@
+/// Serialization and deserialization layer specialized for binary encoding.
+/// Ensures consistency and safety. Basically a minimal subset of
+/// encoding/binary customized for our need.
+///
+/// To use it simply implement `Writeable` or `Readable` and then use the
+/// `Serialize` or `Deserialize` functions on them as appropriate.
+package core
+
+import (
+  "encoding/binary"
+  "fmt"
+  "io"
+
+  "github.com/kelby/go-grin/keychain"
+  "github.com/kelby/go-grin/secp"
+)
+
+/// Maximum number of bytes we are willing to read in one go. Protects against
+/// a peer sending us a huge length prefix and having us allocate it.
+const MAX_READ_BYTES = 100000
+
+/// Maximum number of items we are willing to read in a single vector of
+/// serialized elements (inputs, outputs, kernels...).
+const MAX_READ_ITEMS = 1000000
+
+type ErrorKind int
+
+/// Possible errors deriving from serializing or deserializing.
+const (
+  /// Wraps an io error produced when reading or writing
+  IOErr ErrorKind = iota
+  /// Expected a given value that wasn't found
+  UnexpectedData
+  /// Data wasn't in a consumable format
+  CorruptedData
+  /// When asked to read too much data
+  TooLargeReadErr
+  /// Consensus rule failure (currently sort order)
+  ConsensusError
+  /// Error from from_hex deserialization
+  HexError
+  /// Number of elements read doesn't match the announced count
+  CountError
+)
+
+/// Error returned by the serialization layer, the Kind can be used to tell
+/// the different failure cases apart.
+type Error struct {
+  Kind ErrorKind
+  Msg string
+}
+
+func (self Error) Error() string {
+  switch self.Kind {
+  case IOErr:
+    return fmt.Sprintf("io error: %s", self.Msg)
+  case UnexpectedData:
+    return fmt.Sprintf("unexpected data: %s", self.Msg)
+  case CorruptedData:
+    return "corrupted data"
+  case TooLargeReadErr:
+    return fmt.Sprintf("too large read: %s", self.Msg)
+  case ConsensusError:
+    return fmt.Sprintf("consensus error (sort order): %s", self.Msg)
+  case HexError:
+    return fmt.Sprintf("hex error: %s", self.Msg)
+  case CountError:
+    return fmt.Sprintf("count error: %s", self.Msg)
+  }
+  return "unknown serialization error"
+}
+
+/// Signal to a serializable object how much of its data should be serialized
+type SerializationMode int
+
+const (
+  /// Serialize everything sufficiently to fully reconstruct the object
+  SERIALIZATION_FULL SerializationMode = iota
+  /// Serialize the data that defines the object
+  SERIALIZATION_HASH
+)
+
+/// Implementations defined how different numbers and binary structures are
+/// written to an underlying stream or container (depending on implementation).
+type Writer interface {
+  /// The mode this serializer is writing in
+  Serialization_mode() SerializationMode
+
+  /// Writes a u8 as bytes
+  Write_u8(n uint8) error
+
+  /// Writes a u16 as bytes
+  Write_u16(n uint16) error
+
+  /// Writes a u32 as bytes
+  Write_u32(n uint32) error
+
+  /// Writes a u64 as bytes
+  Write_u64(n uint64) error
+
+  /// Writes a i64 as bytes
+  Write_i64(n int64) error
+
+  /// Writes a variable number of bytes. The length is encoded as a 64-bit
+  /// prefix.
+  Write_bytes(bytes []byte) error
+
+  /// Writes a fixed number of bytes from something that can turn itself into
+  /// a `[]byte`. The reader is expected to know the actual length on read.
+  Write_fixed_bytes(fixed []byte) error
+}
+
+/// Implementations defined how different numbers and binary structures are
+/// read from an underlying stream or container (depending on implementation).
+type Reader interface {
+  /// Read a u8 from the underlying Read
+  Read_u8() (uint8, error)
+  /// Read a u16 from the underlying Read
+  Read_u16() (uint16, error)
+  /// Read a u32 from the underlying Read
+  Read_u32() (uint32, error)
+  /// Read a u64 from the underlying Read
+  Read_u64() (uint64, error)
+  /// Read a i64 from the underlying Read
+  Read_i64() (int64, error)
+  /// Read a variable size vector from the underlying Read. Expects a u64
+  /// first before the data bytes.
+  Read_vec() ([]byte, error)
+  /// Read a variable size vector from the underlying Read. Expects a u64
+  /// first before the data bytes limited to max bytes.
+  Read_limited_vec(max int) ([]byte, error)
+  /// Read a fixed number of bytes from the underlying reader.
+  Read_fixed_bytes(length int) ([]byte, error)
+  /// Consumes a byte from the reader, producing an error if it doesn't have
+  /// the expected value
+  Expect_u8(val uint8) (uint8, error)
+}
+
+/// Trait that every type that can be serialized as binary must implement.
+/// Writes directly to a Writer, a utility type thinly wrapping an
+/// underlying Write implementation.
+type Writeable interface {
+  /// Write the data held by this Writeable to the provided writer
+  Write(writer Writer) error
+}
+
+/// Trait that every type that can be deserialized from binary must implement.
+/// Reads directly to a Reader, a utility type thinly wrapping an
+/// underlying Read implementation.
+type Readable interface {
+  /// Reads the data necessary to this Readable from the provided reader
+  Read(reader Reader) error
+}
+
+/// Deserializes a Readable from any io.Reader implementation.
+func Deserialize(source io.Reader, thing Readable) error {
+  reader := &BinReader{Source: source}
+  return thing.Read(reader)
+}
+
+/// Serializes a Writeable into any io.Writer implementation.
+func Serialize(sink io.Writer, thing Writeable) error {
+  writer := &BinWriter{Sink: sink}
+  return thing.Write(writer)
+}
+
+/// Utility function to serialize a writeable directly in memory using a
+/// []byte.
+func Ser_vec(thing Writeable) ([]byte, error) {
+  var vec bytesSink
+  if err := Serialize(&vec, thing); err != nil {
+    return nil, err
+  }
+  return vec, nil
+}
+
+type bytesSink []byte
+
+func (self *bytesSink) Write(p []byte) (int, error) {
+  *self = append(*self, p...)
+  return len(p), nil
+}
+
+/// Reads count items into a collection, the read function is called once
+/// per item. Very rudimentary check to ensure we do not overflow anything
+/// attempting to read huge amounts of data.
+func Read_multi(reader Reader, count uint64, read func(reader Reader) error) error {
+  if count > MAX_READ_ITEMS {
+    return Error{Kind: TooLargeReadErr, Msg: fmt.Sprintf("read_multi of %d items", count)}
+  }
+  for i := uint64(0); i < count; i++ {
+    if err := read(reader); err != nil {
+      return err
+    }
+  }
+  return nil
+}
+
+/// Utility wrapper for an underlying byte Reader. Defines higher level methods
+/// to read numbers, byte vectors, hashes, etc.
+type BinReader struct {
+  Source io.Reader
+}
+
+func (self *BinReader) Read_u8() (uint8, error) {
+  buf, err := self.Read_fixed_bytes(1)
+  if err != nil {
+    return 0, err
+  }
+  return buf[0], nil
+}
+
+func (self *BinReader) Read_u16() (uint16, error) {
+  buf, err := self.Read_fixed_bytes(2)
+  if err != nil {
+    return 0, err
+  }
+  return binary.BigEndian.Uint16(buf), nil
+}
+
+func (self *BinReader) Read_u32() (uint32, error) {
+  buf, err := self.Read_fixed_bytes(4)
+  if err != nil {
+    return 0, err
+  }
+  return binary.BigEndian.Uint32(buf), nil
+}
+
+func (self *BinReader) Read_u64() (uint64, error) {
+  buf, err := self.Read_fixed_bytes(8)
+  if err != nil {
+    return 0, err
+  }
+  return binary.BigEndian.Uint64(buf), nil
+}
+
+func (self *BinReader) Read_i64() (int64, error) {
+  n, err := self.Read_u64()
+  return int64(n), err
+}
+
+/// Read a variable size vector from the underlying Read. Expects a u64
+/// first before the data bytes.
+func (self *BinReader) Read_vec() ([]byte, error) {
+  length, err := self.Read_u64()
+  if err != nil {
+    return nil, err
+  }
+  if length > MAX_READ_BYTES {
+    return nil, Error{Kind: TooLargeReadErr, Msg: fmt.Sprintf("vec of %d bytes", length)}
+  }
+  return self.Read_fixed_bytes(int(length))
+}
+
+/// Read limited variable size vector from the underlying Read. Expects a
+/// u64 first before the data bytes, refuses lengths above max.
+func (self *BinReader) Read_limited_vec(max int) ([]byte, error) {
+  length, err := self.Read_u64()
+  if err != nil {
+    return nil, err
+  }
+  if length > uint64(max) {
+    return nil, Error{Kind: TooLargeReadErr, Msg: fmt.Sprintf("vec of %d bytes, max %d", length, max)}
+  }
+  return self.Read_fixed_bytes(int(length))
+}
+
+func (self *BinReader) Read_fixed_bytes(length int) ([]byte, error) {
+  // not reading more than 100k in a single read
+  if length > MAX_READ_BYTES {
+    return nil, Error{Kind: TooLargeReadErr, Msg: fmt.Sprintf("fixed bytes length too large: %d", length)}
+  }
+  buf := make([]byte, length)
+  if _, err := io.ReadFull(self.Source, buf); err != nil {
+    return nil, Error{Kind: IOErr, Msg: err.Error()}
+  }
+  return buf, nil
+}
+
+func (self *BinReader) Expect_u8(val uint8) (uint8, error) {
+  b, err := self.Read_u8()
+  if err != nil {
+    return 0, err
+  }
+  if b != val {
+    return 0, Error{Kind: UnexpectedData, Msg: fmt.Sprintf("expected %d, received %d", val, b)}
+  }
+  return b, nil
+}
+
+/// Utility wrapper for an underlying byte Writer. Defines higher level methods
+/// to write numbers, byte vectors, hashes, etc.
+type BinWriter struct {
+  Sink io.Writer
+}
+
+func (self *BinWriter) Serialization_mode() SerializationMode {
+  return SERIALIZATION_FULL
+}
+
+func (self *BinWriter) Write_u8(n uint8) error {
+  return self.Write_fixed_bytes([]byte{n})
+}
+
+func (self *BinWriter) Write_u16(n uint16) error {
+  var buf [2]byte
+  binary.BigEndian.PutUint16(buf[:], n)
+  return self.Write_fixed_bytes(buf[:])
+}
+
+func (self *BinWriter) Write_u32(n uint32) error {
+  var buf [4]byte
+  binary.BigEndian.PutUint32(buf[:], n)
+  return self.Write_fixed_bytes(buf[:])
+}
+
+func (self *BinWriter) Write_u64(n uint64) error {
+  var buf [8]byte
+  binary.BigEndian.PutUint64(buf[:], n)
+  return self.Write_fixed_bytes(buf[:])
+}
+
+func (self *BinWriter) Write_i64(n int64) error {
+  return self.Write_u64(uint64(n))
+}
+
+func (self *BinWriter) Write_bytes(bytes []byte) error {
+  if err := self.Write_u64(uint64(len(bytes))); err != nil {
+    return err
+  }
+  return self.Write_fixed_bytes(bytes)
+}
+
+func (self *BinWriter) Write_fixed_bytes(fixed []byte) error {
+  if _, err := self.Sink.Write(fixed); err != nil {
+    return Error{Kind: IOErr, Msg: err.Error()}
+  }
+  return nil
+}
+
+/// Writes a Pedersen commitment, always PEDERSEN_COMMITMENT_SIZE bytes.
+func Write_commitment(writer Writer, commit secp.Commitment) error {
+  return writer.Write_fixed_bytes(commit[:])
+}
+
+/// Reads a Pedersen commitment.
+func Read_commitment(reader Reader) (secp.Commitment, error) {
+  var commit secp.Commitment
+  bytes, err := reader.Read_fixed_bytes(secp.PEDERSEN_COMMITMENT_SIZE)
+  if err != nil {
+    return commit, err
+  }
+  copy(commit[:], bytes)
+  return commit, nil
+}
+
+/// Writes a range proof, length prefixed.
+func Write_range_proof(writer Writer, proof secp.RangeProof) error {
+  return writer.Write_bytes(proof.Proof[:proof.ProofLen])
+}
+
+/// Reads a range proof, refusing anything larger than MAX_PROOF_SIZE.
+func Read_range_proof(reader Reader) (secp.RangeProof, error) {
+  p, err := reader.Read_limited_vec(secp.MAX_PROOF_SIZE)
+  if err != nil {
+    return secp.RangeProof{}, err
+  }
+  return secp.RangeProof{Proof: p, ProofLen: len(p)}, nil
+}
+
+/// Writes an aggregated signature in its compact form.
+func Write_signature(writer Writer, sig secp.Signature) error {
+  return writer.Write_fixed_bytes(sig[:])
+}
+
+/// Reads an aggregated signature in its compact form.
+func Read_signature(reader Reader) (secp.Signature, error) {
+  var sig secp.Signature
+  bytes, err := reader.Read_fixed_bytes(secp.AGG_SIGNATURE_SIZE)
+  if err != nil {
+    return sig, err
+  }
+  copy(sig[:], bytes)
+  return sig, nil
+}
+
+/// Reads a blinding factor (kernel offsets).
+func Read_blinding_factor(reader Reader) (keychain.BlindingFactor, error) {
+  var bf keychain.BlindingFactor
+  bytes, err := reader.Read_fixed_bytes(keychain.SECRET_KEY_SIZE)
+  if err != nil {
+    return bf, err
+  }
+  copy(bf[:], bytes)
+  return bf, nil
+}
+
+/// Reads a key identifier.
+func Read_identifier(reader Reader) (keychain.Identifier, error) {
+  var id keychain.Identifier
+  bytes, err := reader.Read_fixed_bytes(keychain.IDENTIFIER_SIZE)
+  if err != nil {
+    return id, err
+  }
+  copy(id[:], bytes)
+  return id, nil
+}
@@ -1,5 +1,9 @@
 package keychain
 
+import (
+  "github.com/kelby/go-grin/secp"
+)
+
 const SECRET_KEY_SIZE = 32
 
 // Size of an identifier in bytes
@@ -9,3 +13,22 @@ type BlindingFactor [SECRET_KEY_SIZE]uint8
 
 // #[derive(Clone, PartialEq, Eq, Ord, Hash, PartialOrd)]
 type Identifier [IDENTIFIER_SIZE]uint8
+
+/// Converts the blinding factor to a secret key, failing when it's zero or
+/// larger than the curve order.
+func (self BlindingFactor) Secret_key() (secp.SecretKey, error) {
+  return secp.Secret_key_from_slice(self[:])
+}
+
+/// A blinding factor from a secret key
+func Blinding_factor_from_secret_key(skey secp.SecretKey) BlindingFactor {
+  return BlindingFactor(skey)
+}
+
+/// Derives the keys of a wallet from its seed.
+type Keychain interface {
+  /// The secret key with the given identifier
+  Derived_key(key_id *Identifier) (secp.SecretKey, error)
+  /// The secp256k1 instance used by the keychain
+  Secp() *secp.Secp256k1
+}
@@ -1,5 +1,9 @@
 package pool
 
+import (
+  "github.com/kelby/go-grin/core/core"
+)
+
 type Pool struct {
   /// Entries in the pool (tx + info + timer) in simple insertion order.
   Entries []PoolEntry
@@ -15,3 +19,35 @@ func New(chain chain.BlockChain, name string) Pool {
     name: name
   }
 }
+
+/// Returns the transactions with a kernel matching one of the short ids of
+/// the compact block, along with the short ids found. The kernels are
+/// rehashed with the block hash and nonce of the compact block.
+func (self *Pool) Retrieve_transactions(cb *core.CompactBlock) ([]core.Transaction, []core.ShortId) {
+  txs := []core.Transaction{}
+  found_ids := []core.ShortId{}
+
+  kern_ids := make(map[core.ShortId]bool, len(cb.Kern_ids))
+  for _, id := range cb.Kern_ids {
+    kern_ids[id] = true
+  }
+
+  // Rehash all entries in the pool using short_ids based on provided hash
+  // and nonce.
+  hash := cb.Hash()
+  for i := range self.Entries {
+    tx := &self.Entries[i].Tx
+    for k := range tx.Kernels {
+      // rehash each kernel to calculate the block specific short_id
+      short_id := tx.Kernels[k].Short_id(&hash, cb.Nonce)
+
+      // if any kernel matches then keep the tx for later
+      if kern_ids[short_id] {
+        txs = append(txs, *tx)
+        found_ids = append(found_ids, short_id)
+        break
+      }
+    }
+  }
+  return txs, found_ids
+}
@@ -1,5 +1,9 @@
 package pool
 
+import (
+  "github.com/kelby/go-grin/core/core"
+)
+
 /// Transaction pool implementation.
 type TransactionPool struct {
   /// Pool Config
@@ -31,3 +35,11 @@ func (self *TransactionPool)add_to_stempool(entry PoolEntry) PoolError {
   // we let the dandelion monitor handle this.
   Ok(())
 }
+
+/// Query the tx pool for all known txs based on kernel short_ids
+/// from the provided compact_block.
+/// Note: does not validate that we return the full set of required txs.
+/// The caller will need to validate that themselves.
+func (self *TransactionPool) Retrieve_transactions(cb *core.CompactBlock) ([]core.Transaction, []core.ShortId) {
+  return self.Txpool.Retrieve_transactions(cb)
+}
@@ -1,3 +1,9 @@
+package pool
+
+import (
+  "github.com/kelby/go-grin/core/core"
+)
+
 const (
   /// Dandelion relay timer
   DANDELION_RELAY_SECS uint64 = 600
@@ -57,7 +63,7 @@ type PoolEntry struct {
   /// Timestamp of when this tx was originally added to the pool.
   Tx_at Timespec
   /// The transaction itself.
-  Tx Transaction
+  Tx core.Transaction
 }
 
 /// Placeholder: the data representing where we heard about a tx from.
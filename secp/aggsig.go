@@ -0,0 +1,228 @@
+package secp
+
+/// Aggregated Schnorr signatures, compatible with the aggsig module of
+/// libsecp256k1-zkp. A signature is the x coordinate of the public nonce R
+/// followed by s = k + e*x, with e = SHA256(R.x || msg). The final nonce
+/// always has a y coordinate that is a quadratic residue, signers negate
+/// their secret nonce when the nonce sum doesn't, so only its x coordinate
+/// needs to be known by verifiers.
+///
+/// Multi-party signing goes as follows: each party creates a secret nonce
+/// and shares the matching public nonce, the public nonces are summed up and
+/// each party computes a partial signature against that sum, the partial
+/// signatures then get added together into the final signature, valid for
+/// the sum of the public keys.
+
+import (
+  "crypto/sha256"
+
+  "github.com/decred/dcrd/dcrec/secp256k1/v4"
+)
+
+/// Creates a new secret nonce, to be used once for a single (partial)
+/// signature.
+func (self *Secp256k1) Export_secnonce_single() (SecretKey, error) {
+  return Random_secret_key(), nil
+}
+
+/// Single-signer (plain old Schnorr, sans-multisig) signature creation, also
+/// used for partial signatures.
+///
+/// The secret nonce is generated when nil. The public nonce, when provided,
+/// is the one committed to in the challenge e (the sum of all the public
+/// nonces in the multi-party case). The final nonce sum, when provided,
+/// determines whether the secret nonce needs negating and defaults to the
+/// public nonce.
+func (self *Secp256k1) Sign_single(msg *Message, seckey *SecretKey, secnonce *SecretKey, pubnonce *PublicKey, final_nonce_sum *PublicKey) (Signature, error) {
+  x, err := seckey.scalar()
+  if err != nil {
+    return Signature{}, err
+  }
+  nonce := Random_secret_key()
+  if secnonce != nil {
+    nonce = *secnonce
+  }
+  k, err := nonce.scalar()
+  if err != nil {
+    return Signature{}, err
+  }
+
+  var own_nonce secp256k1.JacobianPoint
+  secp256k1.ScalarBaseMultNonConst(&k, &own_nonce)
+  nonce_for_e := own_nonce
+  if pubnonce != nil {
+    nonce_for_e = pubnonce.point
+  }
+  nonce_sum := nonce_for_e
+  if final_nonce_sum != nil {
+    nonce_sum = final_nonce_sum.point
+  }
+  if is_infinity(&nonce_for_e) || is_infinity(&nonce_sum) {
+    return Signature{}, PartialSigFailure
+  }
+
+  // the final nonce must have a quadratic residue y
+  if !has_quad_y(&nonce_sum) {
+    k.Negate()
+  }
+
+  e := schnorr_challenge(&nonce_for_e, msg)
+  var s secp256k1.ModNScalar
+  s.Mul2(&e, &x).Add(&k)
+
+  var sig Signature
+  x_bytes(&own_nonce, sig[:32])
+  s.PutBytesUnchecked(sig[32:])
+  return sig, nil
+}
+
+/// Single-signer signature verification. The public nonce, when provided, is
+/// used to compute the challenge e instead of the signature nonce, which is
+/// the case of the partial signatures. Partial signatures are also allowed a
+/// nonce with a non quadratic residue y coordinate.
+func (self *Secp256k1) Verify_single(sig *Signature, msg *Message, pubnonce *PublicKey, pubkey *PublicKey, is_partial bool) bool {
+  var r secp256k1.FieldVal
+  if overflow := r.SetByteSlice(sig[:32]); overflow {
+    return false
+  }
+  var s secp256k1.ModNScalar
+  if overflow := s.SetByteSlice(sig[32:]); overflow {
+    return false
+  }
+  if is_infinity(&pubkey.point) {
+    return false
+  }
+
+  var e secp256k1.ModNScalar
+  if pubnonce != nil {
+    e = schnorr_challenge(&pubnonce.point, msg)
+  } else {
+    e = challenge_from_x(sig[:32], msg)
+  }
+
+  // R = s*G - e*P
+  e.Negate()
+  var s_g, e_p, nonce secp256k1.JacobianPoint
+  secp256k1.ScalarBaseMultNonConst(&s, &s_g)
+  secp256k1.ScalarMultNonConst(&e, &pubkey.point, &e_p)
+  secp256k1.AddNonConst(&s_g, &e_p, &nonce)
+  if is_infinity(&nonce) {
+    return false
+  }
+  if !is_partial && !has_quad_y(&nonce) {
+    return false
+  }
+  nonce.ToAffine()
+  return nonce.X.Equals(&r)
+}
+
+/// Adds the partial signatures together into the final signature, valid for
+/// the sum of the public keys and the given nonce sum.
+func (self *Secp256k1) Add_signatures_single(sigs []*Signature, nonce_sum *PublicKey) (Signature, error) {
+  if is_infinity(&nonce_sum.point) {
+    return Signature{}, InvalidSignature
+  }
+  var s secp256k1.ModNScalar
+  for _, sig := range sigs {
+    var part secp256k1.ModNScalar
+    if overflow := part.SetByteSlice(sig[32:]); overflow {
+      return Signature{}, InvalidSignature
+    }
+    s.Add(&part)
+  }
+
+  var sig Signature
+  x_bytes(&nonce_sum.point, sig[:32])
+  s.PutBytesUnchecked(sig[32:])
+  return sig, nil
+}
+
+/// The Schnorr challenge e = SHA256(R.x || msg)
+func schnorr_challenge(nonce *secp256k1.JacobianPoint, msg *Message) secp256k1.ModNScalar {
+  var x [32]byte
+  x_bytes(nonce, x[:])
+  return challenge_from_x(x[:], msg)
+}
+
+func challenge_from_x(x []byte, msg *Message) secp256k1.ModNScalar {
+  h := sha256.New()
+  h.Write(x)
+  h.Write(msg[:])
+  var e secp256k1.ModNScalar
+  e.SetByteSlice(h.Sum(nil))
+  return e
+}
+
+/// Writes the x coordinate of the point into the 32 bytes out
+func x_bytes(p *secp256k1.JacobianPoint, out []byte) {
+  var affine secp256k1.JacobianPoint
+  affine.Set(p)
+  affine.ToAffine()
+  affine.X.PutBytesUnchecked(out)
+}
+
+/// Whether the y coordinate of the point is a quadratic residue
+func has_quad_y(p *secp256k1.JacobianPoint) bool {
+  var affine secp256k1.JacobianPoint
+  affine.Set(p)
+  affine.ToAffine()
+  return is_quad(&affine.Y)
+}
+
+/// Verifies many complete (non partial) signatures at once, each against its
+/// own message and public key. All the verification equations
+/// s_i*G - e_i*P_i - R_i = 0 are combined with random weights into a single
+/// multi-exponentiation.
+func (self *Secp256k1) Verify_batch(sigs []Signature, msgs []Message, pubkeys []PublicKey) bool {
+  if len(sigs) != len(msgs) || len(sigs) != len(pubkeys) {
+    return false
+  }
+  if len(sigs) == 0 {
+    return true
+  }
+
+  scalars := make([]secp256k1.ModNScalar, 0, 2*len(sigs)+1)
+  points := make([]secp256k1.JacobianPoint, 0, 2*len(sigs)+1)
+  var s_sum secp256k1.ModNScalar
+  for i := range sigs {
+    var s secp256k1.ModNScalar
+    if overflow := s.SetByteSlice(sigs[i][32:]); overflow {
+      return false
+    }
+    nonce, ok := lift_x(sigs[i][:32])
+    if !ok || is_infinity(&pubkeys[i].point) {
+      return false
+    }
+    e := challenge_from_x(sigs[i][:32], &msgs[i])
+    weight := random_scalar()
+
+    s.Mul(&weight)
+    s_sum.Add(&s)
+    e.Mul(&weight).Negate()
+    scalars = append(scalars, e, *weight.Negate())
+    points = append(points, pubkeys[i].point, nonce)
+  }
+  scalars = append(scalars, s_sum)
+  points = append(points, generator_g())
+
+  result := multiexp(scalars, points)
+  return is_infinity(&result)
+}
+
+/// The point with the given x coordinate and a quadratic residue y, as
+/// signature nonces are.
+func lift_x(x []byte) (secp256k1.JacobianPoint, bool) {
+  var p secp256k1.JacobianPoint
+  if overflow := p.X.SetByteSlice(x); overflow {
+    return p, false
+  }
+  // the square root we get back is always the quadratic residue one
+  var y2 secp256k1.FieldVal
+  y2.SquareVal(&p.X).Mul(&p.X).AddInt(7).Normalize()
+  if !p.Y.SquareRootVal(&y2) {
+    return p, false
+  }
+  p.Y.Normalize()
+  p.Z.SetInt(1)
+  return p, true
+}
@@ -0,0 +1,163 @@
+package secp
+
+import (
+  "encoding/hex"
+  "testing"
+)
+
+func test_message(b byte) Message {
+  var msg Message
+  for i := range msg {
+    msg[i] = b
+  }
+  return msg
+}
+
+func Test_sign_single_reference(t *testing.T) {
+  // secp256k1_aggsig_sign_single of libsecp256k1-zkp with secret key
+  // 0x11.., secret nonce 0x22.. and message 0x33.., no extra data and no
+  // other nonce or public key committed to
+  secp := New()
+  var seckey, secnonce SecretKey
+  for i := range seckey {
+    seckey[i] = 0x11
+    secnonce[i] = 0x22
+  }
+  msg := test_message(0x33)
+
+  pubkey, err := Public_key_from_secret_key(&seckey)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if hex.EncodeToString(pubkey.Serialize_vec()) != "034f355bdcb7cc0af728ef3cceb9615d90684bb5b2ca5f859ab0f0b704075871aa" {
+    t.Fatalf("wrong public key %x", pubkey.Serialize_vec())
+  }
+
+  sig, err := secp.Sign_single(&msg, &seckey, &secnonce, nil, nil)
+  if err != nil {
+    t.Fatal(err)
+  }
+  expected := "466d7fcae563e5cb09a0d1870bb580344804617879a14949cf22285f1bae3f27" +
+    "d39d4fed2102182e56f157d9b49aa6ddeb2d5996c1c1bcfc3a0d30d720961757"
+  if hex.EncodeToString(sig[:]) != expected {
+    t.Fatalf("signature %x, expected %s", sig, expected)
+  }
+  if !secp.Verify_single(&sig, &msg, nil, &pubkey, false) {
+    t.Fatal("reference signature rejected")
+  }
+}
+
+func Test_sign_single(t *testing.T) {
+  secp := New()
+  seckey := Random_secret_key()
+  pubkey, _ := Public_key_from_secret_key(&seckey)
+  msg := test_message(1)
+
+  sig, err := secp.Sign_single(&msg, &seckey, nil, nil, nil)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if !secp.Verify_single(&sig, &msg, nil, &pubkey, false) {
+    t.Fatal("valid signature rejected")
+  }
+  wrong := test_message(2)
+  if secp.Verify_single(&sig, &wrong, nil, &pubkey, false) {
+    t.Fatal("signature verified for another message")
+  }
+  other_key := Random_secret_key()
+  other, _ := Public_key_from_secret_key(&other_key)
+  if secp.Verify_single(&sig, &msg, nil, &other, false) {
+    t.Fatal("signature verified for another public key")
+  }
+}
+
+func Test_aggsig_two_parties(t *testing.T) {
+  secp := New()
+  msg := test_message(7)
+
+  // each party has its key and nonce, only the public parts are shared
+  seckeys := []SecretKey{Random_secret_key(), Random_secret_key()}
+  secnonces := make([]SecretKey, 2)
+  pubkeys := make([]*PublicKey, 2)
+  pubnonces := make([]*PublicKey, 2)
+  for i := range seckeys {
+    nonce, err := secp.Export_secnonce_single()
+    if err != nil {
+      t.Fatal(err)
+    }
+    secnonces[i] = nonce
+    pubkey, _ := Public_key_from_secret_key(&seckeys[i])
+    pubnonce, _ := Public_key_from_secret_key(&secnonces[i])
+    pubkeys[i] = &pubkey
+    pubnonces[i] = &pubnonce
+  }
+  nonce_sum, err := Public_key_from_combination(pubnonces)
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  parts := make([]*Signature, 2)
+  for i := range seckeys {
+    sig, err := secp.Sign_single(&msg, &seckeys[i], &secnonces[i], &nonce_sum, &nonce_sum)
+    if err != nil {
+      t.Fatal(err)
+    }
+    if !secp.Verify_single(&sig, &msg, &nonce_sum, pubkeys[i], true) {
+      t.Fatalf("partial signature %d rejected", i)
+    }
+    parts[i] = &sig
+  }
+  // a partial signature isn't valid for the other party
+  if secp.Verify_single(parts[0], &msg, &nonce_sum, pubkeys[1], true) {
+    t.Fatal("partial signature verified for the other party")
+  }
+
+  final_sig, err := secp.Add_signatures_single(parts, &nonce_sum)
+  if err != nil {
+    t.Fatal(err)
+  }
+  pubkey_sum, err := Public_key_from_combination(pubkeys)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if !secp.Verify_single(&final_sig, &msg, nil, &pubkey_sum, false) {
+    t.Fatal("final signature rejected")
+  }
+  wrong := test_message(8)
+  if secp.Verify_single(&final_sig, &wrong, nil, &pubkey_sum, false) {
+    t.Fatal("final signature verified for another message")
+  }
+}
+
+func Test_verify_batch(t *testing.T) {
+  secp := New()
+  sigs := []Signature{}
+  msgs := []Message{}
+  pubkeys := []PublicKey{}
+  for i := 0; i < 5; i++ {
+    seckey := Random_secret_key()
+    pubkey, _ := Public_key_from_secret_key(&seckey)
+    msg := test_message(byte(i))
+    sig, err := secp.Sign_single(&msg, &seckey, nil, nil, nil)
+    if err != nil {
+      t.Fatal(err)
+    }
+    sigs = append(sigs, sig)
+    msgs = append(msgs, msg)
+    pubkeys = append(pubkeys, pubkey)
+  }
+  if !secp.Verify_batch(sigs, msgs, pubkeys) {
+    t.Fatal("valid batch rejected")
+  }
+  if !secp.Verify_batch(nil, nil, nil) {
+    t.Fatal("empty batch rejected")
+  }
+
+  msgs[3] = test_message(42)
+  if secp.Verify_batch(sigs, msgs, pubkeys) {
+    t.Fatal("batch with a wrong message verified")
+  }
+  if secp.Verify_batch(sigs[:2], msgs, pubkeys) {
+    t.Fatal("batch with mismatched lengths verified")
+  }
+}
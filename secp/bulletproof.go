@@ -0,0 +1,963 @@
+package secp
+
+/// Bulletproofs range proofs (Bünz, Bootle, Boneh, Poelstra, Wuille and
+/// Maxwell), proving that a Pedersen commitment holds a 64 bits value. Port
+/// of the libsecp256k1-zkp implementation: same generators, same
+/// Fiat-Shamir challenges and same 675 bytes encoding.
+///
+/// The proof is made of the (negated) tau_x and mu scalars, the A, S, T1, T2
+/// points and an inner product argument on l(x) and r(x). The points are
+/// only given by their x coordinate, a bit vector telling for each of them
+/// whether its y coordinate is a quadratic residue or not.
+///
+/// The alpha and rho blinding scalars, as well as the s_L and s_R vectors,
+/// are derived from the rewind nonce and the value and message are embedded
+/// in alpha. Anyone knowing the rewind nonce can hence recompute
+/// alpha = -mu - rho*x and get them back.
+
+import (
+  "crypto/hmac"
+  "crypto/rand"
+  "crypto/sha256"
+  "encoding/binary"
+  "encoding/hex"
+  "math/bits"
+  "sync"
+
+  "github.com/decred/dcrd/dcrec/secp256k1/v4"
+)
+
+/// Number of bits of the values proven to be in range
+const BULLET_PROOF_BITS = 64
+
+/// Number of generators libsecp256k1-zkp creates, the G_i being the first
+/// half and the H_i the second half.
+const bullet_proof_generators = 256
+
+/// Number of a and b scalars ending the inner product argument (the
+/// IP_AB_SCALARS of libsecp256k1-zkp), the last rounds being replaced by the
+/// scalars themselves.
+const ip_ab_scalars = 4
+
+/// Number of rounds of the inner product argument, from BULLET_PROOF_BITS
+/// scalars down to ip_ab_scalars/2 of each vector
+const inner_product_rounds = 5
+
+/// The size of the inner product argument: the dot product, the L and R x
+/// coordinates, the final a and b scalars and the L and R y bit vector
+const INNER_PRODUCT_PROOF_SIZE = 32*(1+2*inner_product_rounds+ip_ab_scalars) + (2*inner_product_rounds+7)/8
+
+/// The size of a single (64 bits) bullet proof
+const SINGLE_BULLET_PROOF_SIZE = 2*32 + 1 + 4*32 + INNER_PRODUCT_PROOF_SIZE
+
+/// The generator H used for the value in Pedersen commitments, same as the
+/// one of libsecp256k1-zkp.
+var generator_h_x = "50929b74c1a04954b78b4b6035e97a5e078a5a0f28ec96d547bfee9ace803ac0"
+var generator_h_y = "31d3c6863973926e049e637cb1b5f40a36dac28af1766968c30c2313f3a38904"
+
+/// The value generator H as a point
+func generator_h() secp256k1.JacobianPoint {
+  var h secp256k1.JacobianPoint
+  h.X.SetByteSlice(must_decode_hex(generator_h_x))
+  h.Y.SetByteSlice(must_decode_hex(generator_h_y))
+  h.Z.SetInt(1)
+  return h
+}
+
+/// The standard generator G, used for the blinding factors
+func generator_g() secp256k1.JacobianPoint {
+  var g secp256k1.JacobianPoint
+  var one secp256k1.ModNScalar
+  one.SetInt(1)
+  secp256k1.ScalarBaseMultNonConst(&one, &g)
+  return g
+}
+
+/// Generators used by the bullet proofs vector commitments and inner product
+/// argument, the blinding generator being the standard G.
+type bulletproof_generators struct {
+  G [BULLET_PROOF_BITS]secp256k1.JacobianPoint
+  H [BULLET_PROOF_BITS]secp256k1.JacobianPoint
+}
+
+var generators_once sync.Once
+var generators_cache *bulletproof_generators
+
+/// The bullet proof generators are only computed once and shared between
+/// all the Secp256k1 contexts.
+func shared_generators() *bulletproof_generators {
+  generators_once.Do(func() {
+    generators_cache = new_bulletproof_generators()
+  })
+  return generators_cache
+}
+
+/// Same derivation as secp256k1_bulletproof_generators_create: an RFC6979
+/// HMAC-SHA256 generator seeded with the coordinates of G gives the keys of
+/// the successive generators.
+func new_bulletproof_generators() *bulletproof_generators {
+  g := generator_g()
+  g.ToAffine()
+  var seed [64]byte
+  g.X.PutBytesUnchecked(seed[:32])
+  g.Y.PutBytesUnchecked(seed[32:])
+
+  gens := &bulletproof_generators{}
+  rng := new_rfc6979_hmac_sha256(seed[:])
+  // only the G_i and H_i for 64 bits values are needed, skipping the rest
+  for i := 0; i < bullet_proof_generators/2+BULLET_PROOF_BITS; i++ {
+    key := rng.generate()
+    switch {
+    case i < BULLET_PROOF_BITS:
+      gens.G[i] = generator_generate(key[:])
+    case i >= bullet_proof_generators/2:
+      gens.H[i-bullet_proof_generators/2] = generator_generate(key[:])
+    }
+  }
+  return gens
+}
+
+/// RFC6979 HMAC-SHA256 deterministic random generator, as in libsecp256k1.
+type rfc6979_hmac_sha256 struct {
+  v     [32]byte
+  k     [32]byte
+  retry bool
+}
+
+func new_rfc6979_hmac_sha256(key []byte) *rfc6979_hmac_sha256 {
+  rng := &rfc6979_hmac_sha256{}
+  for i := range rng.v {
+    rng.v[i] = 0x01
+  }
+  for _, b := range []byte{0x00, 0x01} {
+    rng.k = hmac_sha256(rng.k[:], rng.v[:], []byte{b}, key)
+    rng.v = hmac_sha256(rng.k[:], rng.v[:])
+  }
+  return rng
+}
+
+func (self *rfc6979_hmac_sha256) generate() [32]byte {
+  if self.retry {
+    self.k = hmac_sha256(self.k[:], self.v[:], []byte{0x00})
+    self.v = hmac_sha256(self.k[:], self.v[:])
+  }
+  self.v = hmac_sha256(self.k[:], self.v[:])
+  self.retry = true
+  return self.v
+}
+
+func hmac_sha256(key []byte, data ...[]byte) [32]byte {
+  mac := hmac.New(sha256.New, key)
+  for _, d := range data {
+    mac.Write(d)
+  }
+  var out [32]byte
+  copy(out[:], mac.Sum(nil))
+  return out
+}
+
+/// Shallue-van de Woestijne constants: c = sqrt(-3) and d = (c - 1) / 2
+var svdw_c = "0a2d2ba93507f1df233770c2a797962cc61f6d15da14ecd47d8d27ae1cd5f852"
+var svdw_d = "851695d49a83f8ef919bb86153cbcb16630fb68aed0a766a3ec693d68e6afa40"
+
+/// Derives a generator nobody knows the discrete log of from a 32 bytes key,
+/// as secp256k1_generator_generate: the sum of two points hashed to the
+/// curve.
+func generator_generate(key []byte) secp256k1.JacobianPoint {
+  var gen secp256k1.JacobianPoint
+  for _, prefix := range []string{"1st generation: ", "2nd generation: "} {
+    h := sha256.New()
+    h.Write([]byte(prefix))
+    h.Write(key)
+    var t secp256k1.FieldVal
+    t.SetByteSlice(h.Sum(nil))
+    t.Normalize()
+    p := shallue_van_de_woestijne(&t)
+    add_point(&gen, &p)
+  }
+  gen.ToAffine()
+  return gen
+}
+
+/// Maps a field element to a curve point, from "Indifferentiable Hashing to
+/// Barreto-Naehrig Curves" (Fouque and Tibouchi):
+///
+///   w = c * t / (1 + b + t^2)
+///   x1 = d - t*w
+///   x2 = -(x1 + 1)
+///   x3 = 1 + 1/w^2
+///
+/// x being the first of x1, x2, x3 on the curve, y the quadratic residue
+/// root, negated when t is odd.
+func shallue_van_de_woestijne(t *secp256k1.FieldVal) secp256k1.JacobianPoint {
+  var c, d secp256k1.FieldVal
+  c.SetByteSlice(must_decode_hex(svdw_c))
+  d.SetByteSlice(must_decode_hex(svdw_d))
+
+  var w, wd secp256k1.FieldVal
+  wd.SquareVal(t).AddInt(8).Normalize()
+  wd.Inverse()
+  w.Mul2(&c, t).Mul(&wd).Normalize()
+
+  var x1, x2, x3, tmp secp256k1.FieldVal
+  tmp.Mul2(t, &w).Negate(1)
+  x1.Add2(&d, &tmp).Normalize()
+  x2.Set(&x1).AddInt(1).Negate(2).Normalize()
+  x3.SquareVal(&w).Inverse().AddInt(1).Normalize()
+
+  var p secp256k1.JacobianPoint
+  for _, x := range []*secp256k1.FieldVal{&x1, &x2, &x3} {
+    var y2 secp256k1.FieldVal
+    y2.SquareVal(x).Mul(x).AddInt(7).Normalize()
+    // the square root we get back is always the quadratic residue one
+    if p.Y.SquareRootVal(&y2) {
+      p.X.Set(x)
+      break
+    }
+  }
+  p.Y.Normalize()
+  if t.IsOdd() {
+    p.Y.Negate(1).Normalize()
+  }
+  p.Z.SetInt(1)
+  return p
+}
+
+/// Two scalars derived from a 32 bytes seed and an index with the ChaCha20
+/// block function, as secp256k1_scalar_chacha20. The block is recomputed
+/// with an increasing counter until both scalars are below the curve order.
+func scalar_chacha20(seed []byte, idx uint64) (secp256k1.ModNScalar, secp256k1.ModNScalar) {
+  var key [8]uint32
+  for i := range key {
+    key[i] = binary.LittleEndian.Uint32(seed[4*i:])
+  }
+
+  var r1, r2 secp256k1.ModNScalar
+  for over_count := uint32(0); ; over_count++ {
+    init := [16]uint32{
+      0x61707865, 0x3320646e, 0x79622d32, 0x6b206574,
+      key[0], key[1], key[2], key[3], key[4], key[5], key[6], key[7],
+      uint32(idx), uint32(idx >> 32), 0, over_count,
+    }
+    x := init
+    quarter_round := func(a, b, c, d int) {
+      x[a] += x[b]
+      x[d] = bits.RotateLeft32(x[d]^x[a], 16)
+      x[c] += x[d]
+      x[b] = bits.RotateLeft32(x[b]^x[c], 12)
+      x[a] += x[b]
+      x[d] = bits.RotateLeft32(x[d]^x[a], 8)
+      x[c] += x[d]
+      x[b] = bits.RotateLeft32(x[b]^x[c], 7)
+    }
+    for n := 0; n < 10; n++ {
+      quarter_round(0, 4, 8, 12)
+      quarter_round(1, 5, 9, 13)
+      quarter_round(2, 6, 10, 14)
+      quarter_round(3, 7, 11, 15)
+      quarter_round(0, 5, 10, 15)
+      quarter_round(1, 6, 11, 12)
+      quarter_round(2, 7, 8, 13)
+      quarter_round(3, 4, 9, 14)
+    }
+
+    // the scalars are the (little endian) key stream, read big endian
+    var b1, b2 [32]byte
+    for i := 0; i < 8; i++ {
+      binary.LittleEndian.PutUint32(b1[4*i:], x[i]+init[i])
+      binary.LittleEndian.PutUint32(b2[4*i:], x[8+i]+init[8+i])
+    }
+    over1 := r1.SetBytes(&b1)
+    over2 := r2.SetBytes(&b2)
+    if over1 == 0 && over2 == 0 {
+      return r1, r2
+    }
+  }
+}
+
+/// A 64 bits bullet proof, deserialized. The tau_x and mu scalars are kept
+/// negated, as serialized.
+type bulletproof struct {
+  taux secp256k1.ModNScalar
+  mu   secp256k1.ModNScalar
+  A    secp256k1.JacobianPoint
+  S    secp256k1.JacobianPoint
+  T1   secp256k1.JacobianPoint
+  T2   secp256k1.JacobianPoint
+  /// the inner product argument, t being the dot product <l(x), r(x)>
+  t secp256k1.ModNScalar
+  a [ip_ab_scalars / 2]secp256k1.ModNScalar
+  b [ip_ab_scalars / 2]secp256k1.ModNScalar
+  L [inner_product_rounds]secp256k1.JacobianPoint
+  R [inner_product_rounds]secp256k1.JacobianPoint
+}
+
+/// Serializes the proof: tau_x, mu, the A, S, T1, T2 points, then the inner
+/// product argument with t, the a and b scalars and the L and R points.
+func (self *bulletproof) serialize() []byte {
+  out := make([]byte, 0, SINGLE_BULLET_PROOF_SIZE)
+  out = append_scalars(out, &self.taux, &self.mu)
+  out = append_points(out, []*secp256k1.JacobianPoint{&self.A, &self.S, &self.T1, &self.T2})
+  out = append_scalars(out, &self.t)
+  for i := range self.a {
+    out = append_scalars(out, &self.a[i])
+  }
+  for i := range self.b {
+    out = append_scalars(out, &self.b[i])
+  }
+  lr := []*secp256k1.JacobianPoint{}
+  for i := range self.L {
+    lr = append(lr, &self.L[i], &self.R[i])
+  }
+  return append_points(out, lr)
+}
+
+func append_scalars(out []byte, scalars ...*secp256k1.ModNScalar) []byte {
+  for _, s := range scalars {
+    b := s.Bytes()
+    out = append(out, b[:]...)
+  }
+  return out
+}
+
+/// Points are serialized as a bit vector, bit i set when the y coordinate of
+/// point i isn't a quadratic residue, followed by all the x coordinates.
+func append_points(out []byte, points []*secp256k1.JacobianPoint) []byte {
+  bitvec := make([]byte, (len(points)+7)/8)
+  xs := make([]byte, 32*len(points))
+  for i, p := range points {
+    if !has_quad_y(p) {
+      bitvec[i/8] |= 1 << uint(i%8)
+    }
+    x_bytes(p, xs[32*i:])
+  }
+  return append(append(out, bitvec...), xs...)
+}
+
+/// Deserializes a proof, failing on anything that isn't exactly a valid
+/// single bullet proof.
+func parse_bulletproof(proof *RangeProof) (*bulletproof, error) {
+  if proof.ProofLen != SINGLE_BULLET_PROOF_SIZE || len(proof.Proof) < proof.ProofLen {
+    return nil, InvalidRangeProof
+  }
+  data := proof.Proof[:proof.ProofLen]
+  bp := &bulletproof{}
+
+  next_scalars := func(scalars ...*secp256k1.ModNScalar) error {
+    for _, s := range scalars {
+      if overflow := s.SetByteSlice(data[:32]); overflow {
+        return InvalidRangeProof
+      }
+      data = data[32:]
+    }
+    return nil
+  }
+  next_points := func(points ...*secp256k1.JacobianPoint) error {
+    bitvec := data[:(len(points)+7)/8]
+    data = data[len(bitvec):]
+    for i, p := range points {
+      point, ok := lift_x(data[:32])
+      if !ok {
+        return InvalidRangeProof
+      }
+      if bitvec[i/8]&(1<<uint(i%8)) != 0 {
+        negate_point(&point)
+      }
+      *p = point
+      data = data[32:]
+    }
+    return nil
+  }
+
+  if err := next_scalars(&bp.taux, &bp.mu); err != nil {
+    return nil, err
+  }
+  if err := next_points(&bp.A, &bp.S, &bp.T1, &bp.T2); err != nil {
+    return nil, err
+  }
+  if err := next_scalars(&bp.t); err != nil {
+    return nil, err
+  }
+  for i := range bp.a {
+    if err := next_scalars(&bp.a[i]); err != nil {
+      return nil, err
+    }
+  }
+  for i := range bp.b {
+    if err := next_scalars(&bp.b[i]); err != nil {
+      return nil, err
+    }
+  }
+  lr := []*secp256k1.JacobianPoint{}
+  for i := range bp.L {
+    lr = append(lr, &bp.L[i], &bp.R[i])
+  }
+  if err := next_points(lr...); err != nil {
+    return nil, err
+  }
+  return bp, nil
+}
+
+/// Fiat-Shamir transcript, the running 32 bytes commitment of
+/// libsecp256k1-zkp. Each challenge is read from the commitment after the
+/// new proof elements are hashed in.
+type transcript struct {
+  commit [32]byte
+}
+
+/// Starts a transcript for a proof on the given commitment, committing to
+/// the value generator and the extra data as well.
+func new_transcript(v *secp256k1.JacobianPoint, extra_data []byte) *transcript {
+  t := &transcript{}
+  h := generator_h()
+  t.update(v, &h)
+  if extra_data != nil {
+    t.mix(extra_data)
+  }
+  return t
+}
+
+/// Hashes a pair of points in, as secp256k1_bulletproof_update_commit.
+func (self *transcript) update(l *secp256k1.JacobianPoint, r *secp256k1.JacobianPoint) {
+  var parity byte
+  if !has_quad_y(l) {
+    parity |= 2
+  }
+  if !has_quad_y(r) {
+    parity |= 1
+  }
+  var lx, rx [32]byte
+  x_bytes(l, lx[:])
+  x_bytes(r, rx[:])
+  h := sha256.New()
+  h.Write(self.commit[:])
+  h.Write([]byte{parity})
+  h.Write(lx[:])
+  h.Write(rx[:])
+  copy(self.commit[:], h.Sum(nil))
+}
+
+/// Hashes raw data in.
+func (self *transcript) mix(data []byte) {
+  h := sha256.New()
+  h.Write(self.commit[:])
+  h.Write(data)
+  copy(self.commit[:], h.Sum(nil))
+}
+
+/// The current challenge, rejected when it overflows or is zero.
+func (self *transcript) challenge() (secp256k1.ModNScalar, error) {
+  var c secp256k1.ModNScalar
+  if overflow := c.SetByteSlice(self.commit[:]); overflow || c.IsZero() {
+    return c, InvalidRangeProof
+  }
+  return c, nil
+}
+
+/// The challenges of a proof, as recomputed by the verifier.
+type bulletproof_challenges struct {
+  y  secp256k1.ModNScalar
+  z  secp256k1.ModNScalar
+  x  secp256k1.ModNScalar
+  ux secp256k1.ModNScalar
+  u  [inner_product_rounds]secp256k1.ModNScalar
+}
+
+func (self *bulletproof) challenges(v *secp256k1.JacobianPoint, extra_data []byte) (bulletproof_challenges, error) {
+  var c bulletproof_challenges
+  t := new_transcript(v, extra_data)
+  for _, step := range []struct {
+    update func()
+    out    *secp256k1.ModNScalar
+  }{
+    {func() { t.update(&self.A, &self.S) }, &c.y},
+    {func() { t.update(&self.A, &self.S) }, &c.z},
+    {func() { t.update(&self.T1, &self.T2) }, &c.x},
+    {func() { t.mix(append_scalars(nil, &self.taux, &self.mu)); t.mix(append_scalars(nil, &self.t)) }, &c.ux},
+  } {
+    step.update()
+    ch, err := t.challenge()
+    if err != nil {
+      return c, err
+    }
+    *step.out = ch
+  }
+  for i := 0; i < inner_product_rounds; i++ {
+    t.update(&self.L[i], &self.R[i])
+    ch, err := t.challenge()
+    if err != nil {
+      return c, err
+    }
+    c.u[i] = ch
+  }
+  return c, nil
+}
+
+/// Builds the proof, see the paper for the notations.
+func (self *bulletproof_generators) prove(value uint64, gamma *secp256k1.ModNScalar, commit *Commitment, rewind_nonce *SecretKey, private_nonce *SecretKey, extra_data []byte, message []byte) (*bulletproof, error) {
+  n := BULLET_PROOF_BITS
+  bp := &bulletproof{}
+  g := generator_g()
+  h := generator_h()
+  v, err := parse_commitment(commit)
+  if err != nil {
+    return nil, err
+  }
+  t := new_transcript(&v, extra_data)
+
+  alpha, rho := scalar_chacha20(rewind_nonce[:], 0)
+  tau1, tau2 := scalar_chacha20(private_nonce[:], 1)
+  // the value (and message) is subtracted from alpha, to be found in the
+  // negated mu
+  embedded := embed_value(value, message)
+  alpha.Add(embedded.Negate())
+
+  var one, minus_one secp256k1.ModNScalar
+  one.SetInt(1)
+  minus_one.NegateVal(&one)
+
+  a_l := make([]secp256k1.ModNScalar, n)
+  a_r := make([]secp256k1.ModNScalar, n)
+  s_l := make([]secp256k1.ModNScalar, n)
+  s_r := make([]secp256k1.ModNScalar, n)
+  for i := 0; i < n; i++ {
+    if (value>>uint(i))&1 == 1 {
+      a_l[i].SetInt(1)
+    } else {
+      a_r[i].Set(&minus_one)
+    }
+    s_l[i], s_r[i] = scalar_chacha20(rewind_nonce[:], uint64(i+2))
+  }
+
+  // A = alpha*G + <a_L, G_i> + <a_R, H_i>
+  // S = rho*G + <s_L, G_i> + <s_R, H_i>
+  scalars := make([]secp256k1.ModNScalar, 0, 2*n+1)
+  points := make([]secp256k1.JacobianPoint, 0, 2*n+1)
+  scalars = append(append(append(scalars, alpha), a_l...), a_r...)
+  points = append(append(append(points, g), self.G[:]...), self.H[:]...)
+  bp.A = multiexp(scalars, points)
+  scalars = append(append(append(scalars[:0], rho), s_l...), s_r...)
+  bp.S = multiexp(scalars, points)
+
+  t.update(&bp.A, &bp.S)
+  y, err := t.challenge()
+  if err != nil {
+    return nil, err
+  }
+  t.update(&bp.A, &bp.S)
+  z, err := t.challenge()
+  if err != nil {
+    return nil, err
+  }
+  var z2 secp256k1.ModNScalar
+  z2.SquareVal(&z)
+
+  // l(X) = (a_L - z) + s_L*X
+  // r(X) = y^n o (a_R + z + s_R*X) + z^2*2^n
+  l0 := make([]secp256k1.ModNScalar, n)
+  l1 := s_l
+  r0 := make([]secp256k1.ModNScalar, n)
+  r1 := make([]secp256k1.ModNScalar, n)
+  y_pow := one
+  two_pow := one
+  var minus_z secp256k1.ModNScalar
+  minus_z.NegateVal(&z)
+  for i := 0; i < n; i++ {
+    l0[i].Add2(&a_l[i], &minus_z)
+
+    var tmp secp256k1.ModNScalar
+    tmp.Add2(&a_r[i], &z).Mul(&y_pow)
+    var z2_two secp256k1.ModNScalar
+    z2_two.Mul2(&z2, &two_pow)
+    r0[i].Add2(&tmp, &z2_two)
+    r1[i].Mul2(&y_pow, &s_r[i])
+
+    y_pow.Mul(&y)
+    two_pow.Add(&two_pow)
+  }
+
+  // t(X) = t0 + t1*X + t2*X^2
+  t1 := inner_product(l0, r1)
+  t1_b := inner_product(l1, r0)
+  t1.Add(&t1_b)
+  t2 := inner_product(l1, r1)
+
+  bp.T1 = multiexp([]secp256k1.ModNScalar{t1, tau1}, []secp256k1.JacobianPoint{h, g})
+  bp.T2 = multiexp([]secp256k1.ModNScalar{t2, tau2}, []secp256k1.JacobianPoint{h, g})
+
+  t.update(&bp.T1, &bp.T2)
+  x, err := t.challenge()
+  if err != nil {
+    return nil, err
+  }
+  var x2 secp256k1.ModNScalar
+  x2.SquareVal(&x)
+
+  // tau_x = tau2*x^2 + tau1*x + z^2*gamma, negated
+  var tmp secp256k1.ModNScalar
+  bp.taux.Mul2(&tau2, &x2)
+  tmp.Mul2(&tau1, &x)
+  bp.taux.Add(&tmp)
+  tmp.Mul2(&z2, gamma)
+  bp.taux.Add(&tmp).Negate()
+
+  // mu = alpha + rho*x, negated
+  tmp.Mul2(&rho, &x)
+  bp.mu.Add2(&alpha, &tmp).Negate()
+
+  l := make([]secp256k1.ModNScalar, n)
+  r := make([]secp256k1.ModNScalar, n)
+  for i := 0; i < n; i++ {
+    tmp.Mul2(&l1[i], &x)
+    l[i].Add2(&l0[i], &tmp)
+    tmp.Mul2(&r1[i], &x)
+    r[i].Add2(&r0[i], &tmp)
+  }
+  bp.t = inner_product(l, r)
+
+  // the inner product argument starts from the transcript with tau_x and
+  // mu, then hashes the dot product in
+  t.mix(append_scalars(nil, &bp.taux, &bp.mu))
+  t.mix(append_scalars(nil, &bp.t))
+  ux, err := t.challenge()
+  if err != nil {
+    return nil, err
+  }
+
+  // inner product argument on G_i and H'_i = y^-i * H_i, the dot product
+  // being committed to with ux*G
+  gs := make([]secp256k1.JacobianPoint, n)
+  hs := make([]secp256k1.JacobianPoint, n)
+  var y_inv secp256k1.ModNScalar
+  y_inv.InverseValNonConst(&y)
+  y_inv_pow := one
+  for i := 0; i < n; i++ {
+    gs[i] = self.G[i]
+    secp256k1.ScalarMultNonConst(&y_inv_pow, &self.H[i], &hs[i])
+    y_inv_pow.Mul(&y_inv)
+  }
+
+  // each round folds the even and odd positions together
+  a, b := l, r
+  for round := 0; round < inner_product_rounds; round++ {
+    half := len(a) / 2
+    var c_l, c_r secp256k1.ModNScalar
+    l_scalars := make([]secp256k1.ModNScalar, 0, 2*half+1)
+    l_points := make([]secp256k1.JacobianPoint, 0, 2*half+1)
+    r_scalars := make([]secp256k1.ModNScalar, 0, 2*half+1)
+    r_points := make([]secp256k1.JacobianPoint, 0, 2*half+1)
+    for j := 0; j < half; j++ {
+      tmp.Mul2(&a[2*j], &b[2*j+1])
+      c_l.Add(&tmp)
+      tmp.Mul2(&a[2*j+1], &b[2*j])
+      c_r.Add(&tmp)
+      // L = <a_even, G_odd> + <b_odd, H_even> + ux*<a_even, b_odd>*G
+      l_scalars = append(l_scalars, a[2*j], b[2*j+1])
+      l_points = append(l_points, gs[2*j+1], hs[2*j])
+      // R = <a_odd, G_even> + <b_even, H_odd> + ux*<a_odd, b_even>*G
+      r_scalars = append(r_scalars, a[2*j+1], b[2*j])
+      r_points = append(r_points, gs[2*j], hs[2*j+1])
+    }
+    c_l.Mul(&ux)
+    c_r.Mul(&ux)
+    bp.L[round] = multiexp(append(l_scalars, c_l), append(l_points, g))
+    bp.R[round] = multiexp(append(r_scalars, c_r), append(r_points, g))
+
+    t.update(&bp.L[round], &bp.R[round])
+    u, err := t.challenge()
+    if err != nil {
+      return nil, err
+    }
+    var u_inv secp256k1.ModNScalar
+    u_inv.InverseValNonConst(&u)
+
+    for j := 0; j < half; j++ {
+      var lo, hi secp256k1.ModNScalar
+      // a' = a_even*u + a_odd*u^-1
+      lo.Mul2(&a[2*j], &u)
+      hi.Mul2(&a[2*j+1], &u_inv)
+      a[j].Add2(&lo, &hi)
+      // b' = b_even*u^-1 + b_odd*u
+      lo.Mul2(&b[2*j], &u_inv)
+      hi.Mul2(&b[2*j+1], &u)
+      b[j].Add2(&lo, &hi)
+      // G' = G_even*u^-1 + G_odd*u, H' = H_even*u + H_odd*u^-1
+      gs[j] = multiexp([]secp256k1.ModNScalar{u_inv, u}, []secp256k1.JacobianPoint{gs[2*j], gs[2*j+1]})
+      hs[j] = multiexp([]secp256k1.ModNScalar{u, u_inv}, []secp256k1.JacobianPoint{hs[2*j], hs[2*j+1]})
+    }
+    a, b, gs, hs = a[:half], b[:half], gs[:half], hs[:half]
+  }
+  copy(bp.a[:], a)
+  copy(bp.b[:], b)
+  return bp, nil
+}
+
+/// The (scalar, point) terms whose sum is the point at infinity if and only
+/// if the proofs are valid. For each proof, both the polynomial commitment
+/// check and the inner product check are combined with a random weight, so a
+/// batch of proofs is verified by adding all their terms together. The
+/// scalars applying to the shared generators (G_i, H_i, G and H) are summed
+/// across proofs, which keeps the final multi-exponentiation small.
+type verification_terms struct {
+  g_scalars [BULLET_PROOF_BITS]secp256k1.ModNScalar
+  h_scalars [BULLET_PROOF_BITS]secp256k1.ModNScalar
+  g_scalar  secp256k1.ModNScalar
+  h_scalar  secp256k1.ModNScalar
+  /// terms specific to each proof (V, T1, T2, A, S, L_j and R_j)
+  scalars []secp256k1.ModNScalar
+  points  []secp256k1.JacobianPoint
+}
+
+/// Adds the terms of a proof to the batch, all weighted by a fresh random
+/// scalar.
+func (self *verification_terms) add_proof(commit *Commitment, proof *RangeProof, extra_data []byte) error {
+  n := BULLET_PROOF_BITS
+  bp, err := parse_bulletproof(proof)
+  if err != nil {
+    return err
+  }
+  v, err := parse_commitment(commit)
+  if err != nil {
+    return err
+  }
+  ch, err := bp.challenges(&v, extra_data)
+  if err != nil {
+    return err
+  }
+  weight := random_scalar()
+
+  var one, z2, z3, x2 secp256k1.ModNScalar
+  one.SetInt(1)
+  z2.SquareVal(&ch.z)
+  z3.Mul2(&z2, &ch.z)
+  x2.SquareVal(&ch.x)
+
+  // delta(y, z) = (z - z^2) * <1, y^n> - z^3 * <1, 2^n>
+  var sum_y, sum_two, y_pow, two_pow secp256k1.ModNScalar
+  y_pow.SetInt(1)
+  two_pow.SetInt(1)
+  for i := 0; i < n; i++ {
+    sum_y.Add(&y_pow)
+    sum_two.Add(&two_pow)
+    y_pow.Mul(&ch.y)
+    two_pow.Add(&two_pow)
+  }
+  var delta, tmp secp256k1.ModNScalar
+  tmp.NegateVal(&z2).Add(&ch.z)
+  delta.Mul2(&tmp, &sum_y)
+  tmp.Mul2(&z3, &sum_two)
+  delta.Add(tmp.Negate())
+
+  u_inv := make([]secp256k1.ModNScalar, inner_product_rounds)
+  u_sq := make([]secp256k1.ModNScalar, inner_product_rounds)
+  u_inv_sq := make([]secp256k1.ModNScalar, inner_product_rounds)
+  for j := 0; j < inner_product_rounds; j++ {
+    u_inv[j].InverseValNonConst(&ch.u[j])
+    u_sq[j].SquareVal(&ch.u[j])
+    u_inv_sq[j].SquareVal(&u_inv[j])
+  }
+
+  accumulate := func(acc *secp256k1.ModNScalar, s secp256k1.ModNScalar) {
+    s.Mul(&weight)
+    acc.Add(&s)
+  }
+  add := func(s secp256k1.ModNScalar, p secp256k1.JacobianPoint) {
+    s.Mul(&weight)
+    self.scalars = append(self.scalars, s)
+    self.points = append(self.points, p)
+  }
+
+  // The inner product check, each G_i (resp. H_i) ending up in the final
+  // a_k (resp. b_k) for k = i >> rounds, with s_i the product of the u_j
+  // (or their inverse, depending on bit j of i) it was folded with:
+  // <a_k * s_i + z, G_i> + <(b_k * s_i^-1 - z^2*2^i) * y^-i - z, H_i>
+  //   + (ux*(<a, b> - t) - mu)*G - A - x*S - sum(u_j^2*L_j + u_j^-2*R_j)
+  var y_inv, y_inv_pow secp256k1.ModNScalar
+  y_inv.InverseValNonConst(&ch.y)
+  y_inv_pow.SetInt(1)
+  two_pow.SetInt(1)
+  for i := 0; i < n; i++ {
+    var s, s_inv secp256k1.ModNScalar
+    s.SetInt(1)
+    s_inv.SetInt(1)
+    for j := 0; j < inner_product_rounds; j++ {
+      if (i>>uint(j))&1 == 1 {
+        s.Mul(&ch.u[j])
+        s_inv.Mul(&u_inv[j])
+      } else {
+        s.Mul(&u_inv[j])
+        s_inv.Mul(&ch.u[j])
+      }
+    }
+    k := i >> inner_product_rounds
+
+    var g_coeff secp256k1.ModNScalar
+    g_coeff.Mul2(&bp.a[k], &s).Add(&ch.z)
+    accumulate(&self.g_scalars[i], g_coeff)
+
+    var h_coeff, z2_two secp256k1.ModNScalar
+    z2_two.Mul2(&z2, &two_pow).Negate()
+    h_coeff.Mul2(&bp.b[k], &s_inv).Add(&z2_two).Mul(&y_inv_pow)
+    tmp.NegateVal(&ch.z)
+    h_coeff.Add(&tmp)
+    accumulate(&self.h_scalars[i], h_coeff)
+
+    y_inv_pow.Mul(&y_inv)
+    two_pow.Add(&two_pow)
+  }
+
+  var ab secp256k1.ModNScalar
+  for k := range bp.a {
+    tmp.Mul2(&bp.a[k], &bp.b[k])
+    ab.Add(&tmp)
+  }
+
+  // The polynomial check (tau_x and mu being negated in the proof),
+  // weighted by c:
+  // c * ((t - delta)*H - tau_x*G - z^2*V - x*T1 - x^2*T2)
+  c := random_scalar()
+
+  // G: ux*(<a, b> - t) - mu - c*tau_x
+  var g_coeff secp256k1.ModNScalar
+  tmp.NegateVal(&bp.t)
+  g_coeff.Add2(&ab, &tmp).Mul(&ch.ux)
+  tmp.NegateVal(&bp.mu)
+  g_coeff.Add(&tmp)
+  tmp.Mul2(&c, &bp.taux).Negate()
+  g_coeff.Add(&tmp)
+  accumulate(&self.g_scalar, g_coeff)
+
+  // H: c * (t - delta)
+  var h_coeff secp256k1.ModNScalar
+  tmp.NegateVal(&delta)
+  h_coeff.Add2(&bp.t, &tmp).Mul(&c)
+  accumulate(&self.h_scalar, h_coeff)
+
+  tmp.Mul2(&c, &z2).Negate()
+  add(tmp, v)
+  tmp.Mul2(&c, &ch.x).Negate()
+  add(tmp, bp.T1)
+  tmp.Mul2(&c, &x2).Negate()
+  add(tmp, bp.T2)
+
+  tmp.NegateVal(&one)
+  add(tmp, bp.A)
+  tmp.NegateVal(&ch.x)
+  add(tmp, bp.S)
+
+  for j := 0; j < inner_product_rounds; j++ {
+    tmp.NegateVal(&u_sq[j])
+    add(tmp, bp.L[j])
+    tmp.NegateVal(&u_inv_sq[j])
+    add(tmp, bp.R[j])
+  }
+
+  return nil
+}
+
+/// Runs the multi-exponentiation over all the accumulated terms, the proofs
+/// are all valid if the result is the point at infinity.
+func (self *verification_terms) check(gens *bulletproof_generators) bool {
+  scalars := make([]secp256k1.ModNScalar, 0, 2*BULLET_PROOF_BITS+2+len(self.scalars))
+  points := make([]secp256k1.JacobianPoint, 0, 2*BULLET_PROOF_BITS+2+len(self.points))
+  scalars = append(append(scalars, self.g_scalars[:]...), self.h_scalars[:]...)
+  points = append(append(points, gens.G[:]...), gens.H[:]...)
+  scalars = append(scalars, self.g_scalar, self.h_scalar)
+  points = append(points, generator_g(), generator_h())
+  scalars = append(scalars, self.scalars...)
+  points = append(points, self.points...)
+
+  result := multiexp(scalars, points)
+  return is_infinity(&result)
+}
+
+/// Recovers the value and message embedded in alpha, given the rewind nonce.
+func (self *bulletproof_generators) rewind(commit *Commitment, nonce *SecretKey, extra_data []byte, proof *RangeProof) (uint64, []byte, error) {
+  bp, err := parse_bulletproof(proof)
+  if err != nil {
+    return 0, nil, err
+  }
+  v, err := parse_commitment(commit)
+  if err != nil {
+    return 0, nil, err
+  }
+  ch, err := bp.challenges(&v, extra_data)
+  if err != nil {
+    return 0, nil, err
+  }
+
+  // the embedded value is -mu + alpha + rho*x, mu being negated already
+  alpha, rho := scalar_chacha20(nonce[:], 0)
+  var embedded secp256k1.ModNScalar
+  rho.Mul(&ch.x)
+  embedded.Add2(&bp.mu, &rho).Add(&alpha)
+
+  data := embedded.Bytes()
+  for _, b := range data[:32-BULLET_PROOF_MSG_SIZE-8] {
+    if b != 0 {
+      return 0, nil, InvalidRangeProof
+    }
+  }
+  message := make([]byte, BULLET_PROOF_MSG_SIZE)
+  copy(message, data[32-BULLET_PROOF_MSG_SIZE-8:24])
+  value := binary.BigEndian.Uint64(data[24:])
+  return value, message, nil
+}
+
+/// Encodes the message and value as a scalar: 4 zero bytes, the message
+/// (zero padded) and the value (big endian).
+func embed_value(value uint64, message []byte) secp256k1.ModNScalar {
+  var buf [32]byte
+  copy(buf[32-BULLET_PROOF_MSG_SIZE-8:24], message)
+  binary.BigEndian.PutUint64(buf[24:], value)
+  var s secp256k1.ModNScalar
+  s.SetBytes(&buf)
+  return s
+}
+
+/// A random non zero scalar
+func random_scalar() secp256k1.ModNScalar {
+  var s secp256k1.ModNScalar
+  for s.IsZero() {
+    var buf [32]byte
+    if _, err := rand.Read(buf[:]); err != nil {
+      panic(err)
+    }
+    s.SetBytes(&buf)
+  }
+  return s
+}
+
+/// A u64 as a scalar
+func scalar_from_u64(value uint64) secp256k1.ModNScalar {
+  var buf [32]byte
+  binary.BigEndian.PutUint64(buf[24:], value)
+  var s secp256k1.ModNScalar
+  s.SetBytes(&buf)
+  return s
+}
+
+/// Inner product of two scalar vectors of the same length
+func inner_product(a []secp256k1.ModNScalar, b []secp256k1.ModNScalar) secp256k1.ModNScalar {
+  var sum, tmp secp256k1.ModNScalar
+  for i := range a {
+    tmp.Mul2(&a[i], &b[i])
+    sum.Add(&tmp)
+  }
+  return sum
+}
+
+/// Adds p to sum, in place
+func add_point(sum *secp256k1.JacobianPoint, p *secp256k1.JacobianPoint) {
+  var result secp256k1.JacobianPoint
+  secp256k1.AddNonConst(sum, p, &result)
+  *sum = result
+}
+
+func must_decode_hex(s string) []byte {
+  bytes, err := hex.DecodeString(s)
+  if err != nil {
+    panic(err)
+  }
+  return bytes
+}
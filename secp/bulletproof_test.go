@@ -0,0 +1,222 @@
+package secp
+
+import (
+  "bytes"
+  "encoding/hex"
+  "testing"
+
+  "github.com/decred/dcrd/dcrec/secp256k1/v4"
+)
+
+func Test_svdw_constants(t *testing.T) {
+  var c, d, tmp secp256k1.FieldVal
+  c.SetByteSlice(must_decode_hex(svdw_c))
+  d.SetByteSlice(must_decode_hex(svdw_d))
+
+  // c^2 = -3
+  tmp.SquareVal(&c).AddInt(3).Normalize()
+  if !tmp.IsZero() {
+    t.Fatal("c isn't a square root of -3")
+  }
+  // 2*d = c - 1
+  tmp.Set(&d).MulInt(2).AddInt(1).Negate(3).Add(&c).Normalize()
+  if !tmp.IsZero() {
+    t.Fatal("d isn't (c - 1) / 2")
+  }
+}
+
+func Test_scalar_chacha20(t *testing.T) {
+  // the all zero key and nonce ChaCha20 key stream, read as big endian
+  r1, r2 := scalar_chacha20(make([]byte, 32), 0)
+  b1, b2 := r1.Bytes(), r2.Bytes()
+  if hex.EncodeToString(b1[:]) != "76b8e0ada0f13d90405d6ae55386bd28bdd219b8a08ded1aa836efcc8b770dc7" {
+    t.Fatalf("wrong first scalar %x", b1)
+  }
+  if hex.EncodeToString(b2[:]) != "da41597c5157488d7724e03fb8d84a376a43b8f41518a11cc387b669b2ee6586" {
+    t.Fatalf("wrong second scalar %x", b2)
+  }
+}
+
+func Test_bulletproof_generators(t *testing.T) {
+  // x coordinates from secp256k1_bulletproof_generators_create(ctx,
+  // &secp256k1_generator_const_g, 256), the H_i starting at 128
+  gens := shared_generators()
+  for _, test := range []struct {
+    name     string
+    point    *secp256k1.JacobianPoint
+    expected string
+  }{
+    {"G_0", &gens.G[0], "b34d5fa6b8f3d13849ce5191b7f67618fe5bd12a88b20eac338945667fb33056"},
+    {"G_63", &gens.G[63], "b80205909a989c879f9ca37e01a0e42dee2630dfb584031705dd24fb3170f790"},
+    {"H_0", &gens.H[0], "2224027aaeed035cdcd5deb0b905e2168147133a291d59ea43e83f01b86de45a"},
+    {"H_63", &gens.H[63], "16b8c3f3772a3a8a5a1f355bfa8be20bb7045ead383a7ac5663fe455088cbee8"},
+  } {
+    var x [32]byte
+    x_bytes(test.point, x[:])
+    if hex.EncodeToString(x[:]) != test.expected {
+      t.Errorf("%s x coordinate %x, expected %s", test.name, x, test.expected)
+    }
+  }
+}
+
+/// Proof of 12345678 from secp256k1_bulletproof_rangeproof_prove of
+/// libsecp256k1-zkp, called the way rust-secp256k1-zkp bullet_proof does:
+/// blinding factor 0x44.., rewind nonce 0x55.., private nonce 0x66.., extra
+/// data "extra" and message "abcdefghijklmnopqrst".
+const reference_commit = "08f7ed3041eed81cbc457a5adec0309eaf5633d841e67fab449127e589c04b81a1"
+const reference_proof = "10c38378a1dc250c79749b4128486beaa1bb21f81c7cff434bd33a041da1b6e5" +
+  "8dc6cfa43ca8f51f3f4a7c39d21ef463993c302ab3916a438f95e5a0f13014a202" +
+  "23792c77a802e2b1bf5e25aaa79cf6d88ce639cf00b4f19f520abaa5bf41827f48" +
+  "a1f8dafbec02e33fcf89a4b4b1da48f5adabecc7571f29cf3d9a7529db3b161186" +
+  "da027296c2a1804284c85f53a9b65911ac5aef711edffb5c0c8327478c4c279e30" +
+  "8f9fa420aae08c6609ceeacb6e5a96ec0b088fb347ff3c22fe33e626662f6ea313" +
+  "64d48d9d5225ee509e895ee313acdc3e2591ddcd04548481a9aabac15e80458976" +
+  "dd285f53e14818b034f8a24716d488ebec911ae7f57f656c978f2a3df533a45ec1" +
+  "6da4e9a90144ef268b4c6cf956ca4fc01ca730d0c87f197df98e582c42ab854511" +
+  "aa1648fab1b68744c2fc79fed0e8092745540e545f420c11c678c22742007aace1" +
+  "32efab452efcdc88138b28b7e92636b14d01e1ebb0ba911761025fa4383de3b501" +
+  "a271446b3bfab333c19272ab78e1e795730f29cf82c5ce49b49821a920a4380e89" +
+  "bce73d9eb74493439a28ce17a94032fd5024bb1e4d33e8e04417cbf8668bd373fe" +
+  "4df3963083640938d994d61bbb14db5c5b03f1976723b36d9f7526a49d88655231" +
+  "9ab1bc8c0746a4ec6067016a12e6cef79db532df459faca50cbac4f226af5841e5" +
+  "4657da074dbcd205f656159953ae018d55bcb820524d34d05503d22f34f7c469a1" +
+  "bd15f10017c9f910c8a03b07abdf2f6abadab971c7ab317617276381d8920702b9" +
+  "53a081ba08733f69d7317134b0cb30eb7dc64d7ba827e2fc7cf348468501516c6f" +
+  "7bb0b3479882573947f9b5a47abfa18702c99821886cb7e702c2c423aa281f51b4" +
+  "3907d03d861a5f00bd190d9a08b0c2b66886722881b802d953501cb9d2ae8be887" +
+  "890b4c7680fd95fce2f2a2e489b6ad04"
+
+func Test_bullet_proof_reference(t *testing.T) {
+  secp := New()
+  var blind, rewind_nonce, private_nonce SecretKey
+  for i := range blind {
+    blind[i] = 0x44
+    rewind_nonce[i] = 0x55
+    private_nonce[i] = 0x66
+  }
+  message := []byte("abcdefghijklmnopqrst")
+  extra_data := []byte("extra")
+
+  var commit Commitment
+  copy(commit[:], must_decode_hex(reference_commit))
+  if own, _ := secp.Commit(12345678, blind); own != commit {
+    t.Fatalf("commitment %x, expected %s", own, reference_commit)
+  }
+  proof_bytes := must_decode_hex(reference_proof)
+  proof := RangeProof{Proof: proof_bytes, ProofLen: len(proof_bytes)}
+
+  if _, err := secp.Verify_bullet_proof(commit, proof, extra_data); err != nil {
+    t.Fatalf("reference proof rejected: %s", err)
+  }
+  info, err := secp.Rewind_bullet_proof(commit, rewind_nonce, extra_data, proof)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if info.Value != 12345678 || !bytes.Equal(info.Message, message) {
+    t.Fatalf("rewound %d %q from the reference proof", info.Value, info.Message)
+  }
+
+  // proving is deterministic given the nonces, we get the same bytes
+  own, err := secp.Bullet_proof(12345678, blind, rewind_nonce, private_nonce, extra_data, message)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if !bytes.Equal(own.Proof, proof_bytes) {
+    t.Fatalf("proof %x, expected %s", own.Proof, reference_proof)
+  }
+}
+
+func Test_bullet_proof(t *testing.T) {
+  secp := New()
+  blind := Random_secret_key()
+  rewind_nonce := Random_secret_key()
+  private_nonce := Random_secret_key()
+  message := []byte("hello bulletproofs!!")
+  extra_data := []byte("extra")
+
+  for _, value := range []uint64{0, 1, 12345678, ^uint64(0)} {
+    commit, err := secp.Commit(value, blind)
+    if err != nil {
+      t.Fatal(err)
+    }
+    proof, err := secp.Bullet_proof(value, blind, rewind_nonce, private_nonce, extra_data, message)
+    if err != nil {
+      t.Fatal(err)
+    }
+    if proof.ProofLen != SINGLE_BULLET_PROOF_SIZE || SINGLE_BULLET_PROOF_SIZE != 675 {
+      t.Fatalf("proof of %d bytes, expected 675", proof.ProofLen)
+    }
+    if _, err := secp.Verify_bullet_proof(commit, proof, extra_data); err != nil {
+      t.Fatalf("valid proof of %d rejected: %s", value, err)
+    }
+
+    // the rewind nonce gets the value and message back
+    info, err := secp.Rewind_bullet_proof(commit, rewind_nonce, extra_data, proof)
+    if err != nil {
+      t.Fatal(err)
+    }
+    if !info.Success || info.Value != value || !bytes.Equal(info.Message, message) {
+      t.Fatalf("rewound %d %q, expected %d %q", info.Value, info.Message, value, message)
+    }
+    if _, err := secp.Rewind_bullet_proof(commit, private_nonce, extra_data, proof); err == nil {
+      t.Fatal("rewound with the wrong nonce")
+    }
+
+    // the proof is bound to its commitment and extra data
+    other, _ := secp.Commit(value+1, blind)
+    if _, err := secp.Verify_bullet_proof(other, proof, extra_data); err == nil {
+      t.Fatal("proof verified against another commitment")
+    }
+    if _, err := secp.Verify_bullet_proof(commit, proof, nil); err == nil {
+      t.Fatal("proof verified without its extra data")
+    }
+  }
+}
+
+func Test_bullet_proof_tampered(t *testing.T) {
+  secp := New()
+  blind := Random_secret_key()
+  commit, _ := secp.Commit(42, blind)
+  proof, err := secp.Bullet_proof(42, blind, Random_secret_key(), Random_secret_key(), nil, nil)
+  if err != nil {
+    t.Fatal(err)
+  }
+  // flip a bit in tau_x, mu, the bit vectors, a point and the final scalars
+  for _, pos := range []int{0, 40, 64, 70, 200, 300, 330, 674} {
+    tampered := RangeProof{Proof: append([]byte{}, proof.Proof...), ProofLen: proof.ProofLen}
+    tampered.Proof[pos] ^= 1
+    if _, err := secp.Verify_bullet_proof(commit, tampered, nil); err == nil {
+      t.Fatalf("proof tampered at %d verified", pos)
+    }
+  }
+  short := RangeProof{Proof: proof.Proof, ProofLen: proof.ProofLen - 1}
+  if _, err := secp.Verify_bullet_proof(commit, short, nil); err == nil {
+    t.Fatal("truncated proof verified")
+  }
+}
+
+func Test_bullet_proof_multi(t *testing.T) {
+  secp := New()
+  commits := []Commitment{}
+  proofs := []RangeProof{}
+  for value := uint64(1); value <= 4; value++ {
+    blind := Random_secret_key()
+    commit, _ := secp.Commit(value, blind)
+    proof, err := secp.Bullet_proof(value, blind, Random_secret_key(), Random_secret_key(), nil, nil)
+    if err != nil {
+      t.Fatal(err)
+    }
+    commits = append(commits, commit)
+    proofs = append(proofs, proof)
+  }
+  if _, err := secp.Verify_bullet_proof_multi(commits, proofs, nil); err != nil {
+    t.Fatal(err)
+  }
+
+  // swapping two proofs fails the batch on the first one
+  proofs[1], proofs[2] = proofs[2], proofs[1]
+  _, err := secp.Verify_bullet_proof_multi(commits, proofs, nil)
+  if batch_err, ok := err.(BatchProofError); !ok || batch_err.Index != 1 {
+    t.Fatalf("expected a batch error on proof 1, got %v", err)
+  }
+}
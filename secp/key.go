@@ -0,0 +1,162 @@
+package secp
+
+import (
+  "crypto/rand"
+
+  "github.com/decred/dcrd/dcrec/secp256k1/v4"
+)
+
+/// Secret 256-bit key used as `x` in an ECDSA signature
+type SecretKey [SECRET_KEY_SIZE]byte
+
+/// The number 1 encoded as a secret key
+var ONE_KEY = SecretKey{31: 1}
+
+/// A Secp256k1 public key, a point on the curve
+type PublicKey struct {
+  point secp256k1.JacobianPoint
+}
+
+/// Creates a new random secret key
+func Random_secret_key() SecretKey {
+  for {
+    var key SecretKey
+    if _, err := rand.Read(key[:]); err != nil {
+      panic(err)
+    }
+    var s secp256k1.ModNScalar
+    if !s.SetByteSlice(key[:]) && !s.IsZero() {
+      return key
+    }
+  }
+}
+
+/// Converts a 32 byte slice to a secret key, rejecting zero and values
+/// larger than the curve order
+func Secret_key_from_slice(data []byte) (SecretKey, error) {
+  var key SecretKey
+  if len(data) != SECRET_KEY_SIZE {
+    return key, InvalidSecretKey
+  }
+  copy(key[:], data)
+  if _, err := key.scalar(); err != nil {
+    return key, err
+  }
+  return key, nil
+}
+
+/// The secret key as a scalar modulo the curve order
+func (self *SecretKey) scalar() (secp256k1.ModNScalar, error) {
+  var s secp256k1.ModNScalar
+  if overflow := s.SetByteSlice(self[:]); overflow || s.IsZero() {
+    return s, InvalidSecretKey
+  }
+  return s, nil
+}
+
+/// Adds one secret key to another, modulo the curve order
+func (self *SecretKey) Add_assign(other *SecretKey) error {
+  a, err := self.scalar()
+  if err != nil {
+    return err
+  }
+  b, err := other.scalar()
+  if err != nil {
+    return err
+  }
+  a.Add(&b)
+  if a.IsZero() {
+    return InvalidSecretKey
+  }
+  *self = a.Bytes()
+  return nil
+}
+
+/// Multiplies one secret key by another, modulo the curve order
+func (self *SecretKey) Mul_assign(other *SecretKey) error {
+  a, err := self.scalar()
+  if err != nil {
+    return err
+  }
+  b, err := other.scalar()
+  if err != nil {
+    return err
+  }
+  a.Mul(&b)
+  *self = a.Bytes()
+  return nil
+}
+
+/// Computes the public key corresponding to a secret key
+func Public_key_from_secret_key(sk *SecretKey) (PublicKey, error) {
+  var pk PublicKey
+  s, err := sk.scalar()
+  if err != nil {
+    return pk, err
+  }
+  secp256k1.ScalarBaseMultNonConst(&s, &pk.point)
+  return pk, nil
+}
+
+/// Creates a public key directly from its compressed serialization
+func Public_key_from_slice(data []byte) (PublicKey, error) {
+  var pk PublicKey
+  point, err := parse_point(data)
+  if err != nil {
+    return pk, InvalidPublicKey
+  }
+  pk.point = point
+  return pk, nil
+}
+
+/// Serializes the key in compressed form
+func (self *PublicKey) Serialize_vec() []byte {
+  return serialize_point(&self.point)
+}
+
+/// Adds the public keys together
+func Public_key_from_combination(keys []*PublicKey) (PublicKey, error) {
+  var sum PublicKey
+  for _, key := range keys {
+    add_point(&sum.point, &key.point)
+  }
+  if is_infinity(&sum.point) {
+    return sum, InvalidPublicKey
+  }
+  return sum, nil
+}
+
+/// Whether the point is the point at infinity
+func is_infinity(p *secp256k1.JacobianPoint) bool {
+  return (p.X.IsZero() && p.Y.IsZero()) || p.Z.IsZero()
+}
+
+/// Serializes a point in the usual 33 bytes compressed form
+func serialize_point(p *secp256k1.JacobianPoint) []byte {
+  var affine secp256k1.JacobianPoint
+  affine.Set(p)
+  affine.ToAffine()
+  out := make([]byte, COMPRESSED_PUBLIC_KEY_SIZE)
+  out[0] = 0x02
+  if affine.Y.IsOdd() {
+    out[0] = 0x03
+  }
+  affine.X.PutBytesUnchecked(out[1:])
+  return out
+}
+
+/// Parses a point from its 33 bytes compressed form
+func parse_point(data []byte) (secp256k1.JacobianPoint, error) {
+  var p secp256k1.JacobianPoint
+  if len(data) != COMPRESSED_PUBLIC_KEY_SIZE || (data[0] != 0x02 && data[0] != 0x03) {
+    return p, InvalidPublicKey
+  }
+  if overflow := p.X.SetByteSlice(data[1:]); overflow {
+    return p, InvalidPublicKey
+  }
+  if !secp256k1.DecompressY(&p.X, data[0] == 0x03, &p.Y) {
+    return p, InvalidPublicKey
+  }
+  p.Z.SetInt(1)
+  return p, nil
+}
@@ -0,0 +1,95 @@
+package secp
+
+import (
+  "github.com/decred/dcrd/dcrec/secp256k1/v4"
+)
+
+/// Below this number of terms, the bucket method doesn't pay off.
+const pippenger_threshold = 16
+
+/// Computes sum(scalars[i] * points[i]).
+func multiexp(scalars []secp256k1.ModNScalar, points []secp256k1.JacobianPoint) secp256k1.JacobianPoint {
+  if len(scalars) < pippenger_threshold {
+    return multiexp_naive(scalars, points)
+  }
+  return multiexp_pippenger(scalars, points)
+}
+
+/// Multi-exponentiation one term at a time.
+func multiexp_naive(scalars []secp256k1.ModNScalar, points []secp256k1.JacobianPoint) secp256k1.JacobianPoint {
+  var sum, term secp256k1.JacobianPoint
+  for i := range scalars {
+    secp256k1.ScalarMultNonConst(&scalars[i], &points[i], &term)
+    add_point(&sum, &term)
+  }
+  return sum
+}
+
+/// Pippenger's bucket method: the scalars are split in windows of c bits,
+/// for each window every point is added to the bucket of its digit, the
+/// buckets are then summed up with a running sum so that bucket k counts k
+/// times.
+func multiexp_pippenger(scalars []secp256k1.ModNScalar, points []secp256k1.JacobianPoint) secp256k1.JacobianPoint {
+  c := pippenger_window(len(scalars))
+  bytes := make([][32]byte, len(scalars))
+  for i := range scalars {
+    bytes[i] = scalars[i].Bytes()
+  }
+
+  windows := (256 + c - 1) / c
+  buckets := make([]secp256k1.JacobianPoint, 1<<uint(c))
+  var result secp256k1.JacobianPoint
+  for w := windows - 1; w >= 0; w-- {
+    for k := 0; k < c; k++ {
+      add_point(&result, &result)
+    }
+
+    for k := range buckets {
+      buckets[k] = secp256k1.JacobianPoint{}
+    }
+    for i := range bytes {
+      if digit := window_digit(&bytes[i], w*c, c); digit != 0 {
+        add_point(&buckets[digit], &points[i])
+      }
+    }
+
+    var running, window_sum secp256k1.JacobianPoint
+    for k := len(buckets) - 1; k > 0; k-- {
+      add_point(&running, &buckets[k])
+      add_point(&window_sum, &running)
+    }
+    add_point(&result, &window_sum)
+  }
+  return result
+}
+
+/// A window size that roughly minimizes the number of point additions.
+func pippenger_window(n int) int {
+  switch {
+  case n < 32:
+    return 3
+  case n < 128:
+    return 4
+  case n < 512:
+    return 5
+  case n < 2048:
+    return 6
+  case n < 8192:
+    return 7
+  }
+  return 8
+}
+
+/// The c bits of a big-endian 256 bits scalar starting at bit offset (from
+/// the least significant bit).
+func window_digit(scalar *[32]byte, offset int, c int) int {
+  digit := 0
+  for k := c - 1; k >= 0; k-- {
+    bit := offset + k
+    digit <<= 1
+    if bit < 256 && (scalar[31-bit/8]>>uint(bit%8))&1 == 1 {
+      digit |= 1
+    }
+  }
+  return digit
+}
@@ -2,23 +2,26 @@
 // Use of this source code is governed by a GNU GENERAL PUBLIC LICENSE v3
 // license that can be found in the LICENSE file.
 
-package pedersen
+package secp
 
 import (
   "io"
   "fmt"
+
+  "github.com/decred/dcrd/dcrec/secp256k1/v4"
 )
 
-type Commitment []byte
+/// A Pedersen commitment, always PEDERSEN_COMMITMENT_SIZE bytes on the wire.
+type Commitment [PEDERSEN_COMMITMENT_SIZE]byte
 
 // Bytes implements p2p Message interface
 func (c *Commitment) Bytes() []byte {
-  return *c
+  return c[:]
 }
 
 // Read implements p2p Message interface
 func (c *Commitment) Read(r io.Reader) error {
-  _, err := io.ReadFull(r, *c)
+  _, err := io.ReadFull(r, c[:])
 
   return err
 }
@@ -28,9 +31,270 @@ func (p Commitment) String() string {
   return fmt.Sprintf("%#v", p)
 }
 
+/// Converts a commitment to a public key, the commitment point being a valid
+/// public key when the commitment sums to zero (commits to a zero value).
+func (self *Commitment) To_pubkey() (PublicKey, error) {
+  var pk PublicKey
+  point, err := parse_commitment(self)
+  if err != nil || is_infinity(&point) {
+    return pk, InvalidPublicKey
+  }
+  pk.point = point
+  return pk, nil
+}
+
 type RangeProof struct {
   // The proof itself, at most 5134 bytes long
   Proof []byte // max size MAX_PROOF_SIZE
   // The length of the proof
   ProofLen int
 }
+
+/// The range that was proven
+type ProofRange struct {
+  /// Min value that was proven
+  Min uint64
+  /// Max value that was proven
+  Max uint64
+}
+
+/// Information about a valid proof after rewinding it.
+type ProofInfo struct {
+  /// Whether the proof is valid or not
+  Success bool
+  /// Value that was used by the commitment
+  Value uint64
+  /// Message embedded in the proof
+  Message []byte
+  /// Min value that was proven
+  Min uint64
+  /// Max value that was proven
+  Max uint64
+}
+
+/// Creates a pedersen commitment from a value and a blinding factor, that is
+/// blind*G + value*H.
+func (self *Secp256k1) Commit(value uint64, blind SecretKey) (Commitment, error) {
+  b, err := blinding_scalar(&blind)
+  if err != nil {
+    return Commitment{}, err
+  }
+  v := scalar_from_u64(value)
+
+  var blind_g, value_h, commit secp256k1.JacobianPoint
+  secp256k1.ScalarBaseMultNonConst(&b, &blind_g)
+  h := generator_h()
+  secp256k1.ScalarMultNonConst(&v, &h, &value_h)
+  secp256k1.AddNonConst(&blind_g, &value_h, &commit)
+  return serialize_commitment(&commit), nil
+}
+
+/// Convenience method to commit to a value with a zero blinding factor.
+func (self *Secp256k1) Commit_value(value uint64) (Commitment, error) {
+  return self.Commit(value, SecretKey{})
+}
+
+/// Computes the sum of multiple positive and negative pedersen commitments.
+func (self *Secp256k1) Commit_sum(positive []Commitment, negative []Commitment) (Commitment, error) {
+  sum, err := sum_commitments(positive, negative)
+  if err != nil {
+    return Commitment{}, err
+  }
+  return serialize_commitment(&sum), nil
+}
+
+/// Taking vectors of positive and negative commitments, verify that the sum
+/// of the positive commitments equals the sum of the negative ones.
+func (self *Secp256k1) Verify_commit_sum(positive []Commitment, negative []Commitment) bool {
+  sum, err := sum_commitments(positive, negative)
+  return err == nil && is_infinity(&sum)
+}
+
+/// Computes the sum of multiple positive and negative blinding factors.
+func (self *Secp256k1) Blind_sum(positive []SecretKey, negative []SecretKey) (SecretKey, error) {
+  var sum secp256k1.ModNScalar
+  for i := range positive {
+    b, err := blinding_scalar(&positive[i])
+    if err != nil {
+      return SecretKey{}, err
+    }
+    sum.Add(&b)
+  }
+  for i := range negative {
+    b, err := blinding_scalar(&negative[i])
+    if err != nil {
+      return SecretKey{}, err
+    }
+    sum.Add(b.Negate())
+  }
+  return sum.Bytes(), nil
+}
+
+/// Produces a bullet proof for the provided value, relying on the blinding
+/// factor and value. The rewind nonce allows the holder of the nonce to later
+/// recover the value and the embedded message (up to BULLET_PROOF_MSG_SIZE
+/// bytes), the private nonce is used for all other randomness of the proof.
+func (self *Secp256k1) Bullet_proof(value uint64, blind SecretKey, rewind_nonce SecretKey, private_nonce SecretKey, extra_data []byte, message []byte) (RangeProof, error) {
+  if len(message) > BULLET_PROOF_MSG_SIZE {
+    return RangeProof{}, InvalidRangeProof
+  }
+  gamma, err := blinding_scalar(&blind)
+  if err != nil {
+    return RangeProof{}, err
+  }
+  commit, err := self.Commit(value, blind)
+  if err != nil {
+    return RangeProof{}, err
+  }
+
+  proof, err := self.generators.prove(value, &gamma, &commit, &rewind_nonce, &private_nonce, extra_data, message)
+  if err != nil {
+    return RangeProof{}, err
+  }
+  bytes := proof.serialize()
+  return RangeProof{Proof: bytes, ProofLen: len(bytes)}, nil
+}
+
+/// Verify with bullet proof that a committed value is positive
+func (self *Secp256k1) Verify_bullet_proof(commit Commitment, proof RangeProof, extra_data []byte) (ProofRange, error) {
+  terms := &verification_terms{}
+  if err := terms.add_proof(&commit, &proof, extra_data); err != nil {
+    return ProofRange{}, err
+  }
+  if !terms.check(self.generators) {
+    return ProofRange{}, InvalidRangeProof
+  }
+  return ProofRange{Min: 0, Max: ^uint64(0)}, nil
+}
+
+/// Verify a batch of bullet proofs at once, in a single multi-exponentiation
+/// which is a lot faster than verifying them one by one. The extra data, if
+/// not nil, must have one entry per proof. When the batch is rejected, the
+/// proofs are checked one by one to report the first invalid one with a
+/// BatchProofError.
+func (self *Secp256k1) Verify_bullet_proof_multi(commits []Commitment, proofs []RangeProof, extra_data [][]byte) (ProofRange, error) {
+  if len(commits) != len(proofs) || (extra_data != nil && len(extra_data) != len(proofs)) {
+    return ProofRange{}, InvalidRangeProof
+  }
+  extra := func(i int) []byte {
+    if extra_data == nil {
+      return nil
+    }
+    return extra_data[i]
+  }
+
+  terms := &verification_terms{}
+  for i := range proofs {
+    if err := terms.add_proof(&commits[i], &proofs[i], extra(i)); err != nil {
+      return ProofRange{}, BatchProofError{Index: i, Err: err}
+    }
+  }
+  if terms.check(self.generators) {
+    return ProofRange{Min: 0, Max: ^uint64(0)}, nil
+  }
+
+  // the batch is invalid, find the culprit
+  for i := range proofs {
+    if _, err := self.Verify_bullet_proof(commits[i], proofs[i], extra(i)); err != nil {
+      return ProofRange{}, BatchProofError{Index: i, Err: err}
+    }
+  }
+  return ProofRange{}, InvalidRangeProof
+}
+
+/// Rewind a bullet proof to get the value and message back out, using the
+/// rewind nonce the proof was built with.
+func (self *Secp256k1) Rewind_bullet_proof(commit Commitment, nonce SecretKey, extra_data []byte, proof RangeProof) (ProofInfo, error) {
+  value, message, err := self.generators.rewind(&commit, &nonce, extra_data, &proof)
+  if err != nil {
+    return ProofInfo{Success: false}, err
+  }
+  return ProofInfo{
+    Success: true,
+    Value:   value,
+    Message: message,
+    Min:     0,
+    Max:     ^uint64(0),
+  }, nil
+}
+
+/// Blinding factors may be zero, but not larger than the curve order.
+func blinding_scalar(blind *SecretKey) (secp256k1.ModNScalar, error) {
+  var s secp256k1.ModNScalar
+  if overflow := s.SetByteSlice(blind[:]); overflow {
+    return s, InvalidSecretKey
+  }
+  return s, nil
+}
+
+/// Sum of the positive commitments minus the negative ones, as a point
+func sum_commitments(positive []Commitment, negative []Commitment) (secp256k1.JacobianPoint, error) {
+  var sum secp256k1.JacobianPoint
+  for i := range positive {
+    p, err := parse_commitment(&positive[i])
+    if err != nil {
+      return sum, err
+    }
+    add_point(&sum, &p)
+  }
+  for i := range negative {
+    p, err := parse_commitment(&negative[i])
+    if err != nil {
+      return sum, err
+    }
+    negate_point(&p)
+    add_point(&sum, &p)
+  }
+  return sum, nil
+}
+
+/// Serializes a commitment the same way libsecp256k1-zkp does, the first byte
+/// being 0x08 when the y coordinate is a quadratic residue and 0x09 when it
+/// isn't. The point at infinity (zero commitment) is all zeroes.
+func serialize_commitment(p *secp256k1.JacobianPoint) Commitment {
+  var commit Commitment
+  if is_infinity(p) {
+    return commit
+  }
+  var affine secp256k1.JacobianPoint
+  affine.Set(p)
+  affine.ToAffine()
+
+  commit[0] = 0x08
+  if !is_quad(&affine.Y) {
+    commit[0] = 0x09
+  }
+  affine.X.PutBytesUnchecked(commit[1:])
+  return commit
+}
+
+/// Parses a commitment into its point.
+func parse_commitment(commit *Commitment) (secp256k1.JacobianPoint, error) {
+  var p secp256k1.JacobianPoint
+  if *commit == (Commitment{}) {
+    return p, nil
+  }
+  if commit[0] != 0x08 && commit[0] != 0x09 {
+    return p, InvalidCommit
+  }
+  p, ok := lift_x(commit[1:])
+  if !ok {
+    return p, InvalidCommit
+  }
+  if commit[0] == 0x09 {
+    p.Y.Negate(1).Normalize()
+  }
+  p.Z.SetInt(1)
+  return p, nil
+}
+
+/// Whether a field element is a quadratic residue (has a square root)
+func is_quad(y *secp256k1.FieldVal) bool {
+  var root secp256k1.FieldVal
+  return root.SquareRootVal(y)
+}
+
+/// Negates a point in place
+func negate_point(p *secp256k1.JacobianPoint) {
+  p.Y.Normalize().Negate(1).Normalize()
+}
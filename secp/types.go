@@ -0,0 +1,126 @@
+package secp
+
+import (
+  "fmt"
+)
+
+/// The size of a secret key
+const SECRET_KEY_SIZE = 32
+
+/// The size of a compressed public key
+const COMPRESSED_PUBLIC_KEY_SIZE = 33
+
+/// The size of a Pedersen commitment
+const PEDERSEN_COMMITMENT_SIZE = 33
+
+/// The max size of a range proof
+const MAX_PROOF_SIZE = 5134
+
+/// The size of an aggregated signature in its compact form
+const AGG_SIGNATURE_SIZE = 64
+
+/// The size of a message to sign
+const MESSAGE_SIZE = 32
+
+/// The size of the message that can be embedded (and recovered on rewind) in
+/// a bullet proof
+const BULLET_PROOF_MSG_SIZE = 20
+
+/// An aggregated Schnorr signature, compact serialization.
+type Signature [AGG_SIGNATURE_SIZE]byte
+
+/// A (hashed) message to sign, always MESSAGE_SIZE bytes.
+type Message [MESSAGE_SIZE]byte
+
+/// Converts a 32 byte slice to a message
+func Message_from_slice(data []byte) (Message, error) {
+  var msg Message
+  if len(data) != MESSAGE_SIZE {
+    return msg, InvalidMessage
+  }
+  copy(msg[:], data)
+  return msg, nil
+}
+
+type Error int
+
+/// An ECDSA / Pedersen error
+const (
+  /// A `Secp256k1` was used for an operation, but it was not created to
+  /// support this (so necessary precomputations have not been done)
+  IncapableContext Error = iota
+  /// Signature failed verification
+  IncorrectSignature
+  /// Badly sized message ("messages" are actually fixed-sized digests; see the
+  /// `MESSAGE_SIZE` constant)
+  InvalidMessage
+  /// Bad public key
+  InvalidPublicKey
+  /// Bad commit
+  InvalidCommit
+  /// Bad signature
+  InvalidSignature
+  /// Bad secret key
+  InvalidSecretKey
+  /// Bad recovery id
+  InvalidRecoveryId
+  /// Summing commitments led to incorrect result
+  IncorrectCommitSum
+  /// Range proof is invalid
+  InvalidRangeProof
+  /// Error creating partial signature
+  PartialSigFailure
+)
+
+func (self Error) Error() string {
+  switch self {
+  case IncapableContext:
+    return "secp: context does not have sufficient capabilities"
+  case IncorrectSignature:
+    return "secp: signature failed verification"
+  case InvalidMessage:
+    return "secp: message was not 32 bytes (do you need to hash?)"
+  case InvalidPublicKey:
+    return "secp: malformed public key"
+  case InvalidCommit:
+    return "secp: malformed commitment"
+  case InvalidSignature:
+    return "secp: malformed signature"
+  case InvalidSecretKey:
+    return "secp: malformed or out-of-range secret key"
+  case InvalidRecoveryId:
+    return "secp: bad recovery id"
+  case IncorrectCommitSum:
+    return "secp: invalid commitment sum"
+  case InvalidRangeProof:
+    return "secp: invalid range proof"
+  case PartialSigFailure:
+    return "secp: partial sig (aggsig) failure"
+  }
+  return "secp: unknown error"
+}
+
+/// Error returned when a batch of range proofs is rejected, identifies the
+/// first invalid proof of the batch.
+type BatchProofError struct {
+  /// Index of the invalid proof in the batch
+  Index int
+  /// The underlying error
+  Err error
+}
+
+func (self BatchProofError) Error() string {
+  return fmt.Sprintf("%s (proof %d of the batch)", self.Err.Error(), self.Index)
+}
+
+/// The secp256k1 engine, used to execute all signature and commitment
+/// operations. Everything is implemented in pure Go, the context only holds
+/// the precomputed generators.
+type Secp256k1 struct {
+  generators *bulletproof_generators
+}
+
+/// Creates a new Secp256k1 context, precomputing the bulletproof generators.
+func New() *Secp256k1 {
+  return &Secp256k1{generators: shared_generators()}
+}
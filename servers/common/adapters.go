@@ -0,0 +1,79 @@
+package common
+
+/// Adapters connecting new block, new transaction, and accepted transaction
+/// events to consumers of those events.
+
+import (
+  "github.com/kelby/go-grin/core/core"
+)
+
+/// The part of the chain the adapter needs to accept blocks.
+type ChainView interface {
+  /// Gets a block header by hash
+  Get_block_header(h *core.Hash) (core.BlockHeader, error)
+  /// Processes a single block, returning an error if it's rejected.
+  Process_block(b core.Block) error
+}
+
+/// The part of the transaction pool the adapter needs to hydrate compact
+/// blocks.
+type TxPoolView interface {
+  /// Query the tx pool for all known txs based on kernel short_ids
+  /// from the provided compact_block.
+  Retrieve_transactions(cb *core.CompactBlock) ([]core.Transaction, []core.ShortId)
+}
+
+/// The part of the peers the adapter needs to request full blocks.
+type PeersView interface {
+  /// Asks the peer at the given address for the full block.
+  Send_block_request(addr string, h *core.Hash) error
+}
+
+/// Implementation of the NetAdapter for the blockchain. Gets notified when new
+/// blocks and transactions are received and forwards to the chain and pool
+/// implementations.
+type NetToChainAdapter struct {
+  Chain   ChainView
+  Tx_pool TxPoolView
+  Peers   PeersView
+}
+
+/// A compact block has been received from the peer at addr. The block is
+/// hydrated from the transactions of our pool and processed, if some of its
+/// transactions are missing (or the hydrated block doesn't validate) we fall
+/// back to requesting the full block from the peer.
+/// Returns whether the peer should be kept.
+func (self *NetToChainAdapter) Compact_block_received(cb core.CompactBlock, addr string) bool {
+  bhash := cb.Hash()
+
+  if len(cb.Kern_ids) == 0 {
+    // push the freshly hydrated block through the chain pipeline
+    block := core.Hydrate_from(&cb, []core.Transaction{})
+    return self.process_block(block)
+  }
+
+  txs, _ := self.Tx_pool.Retrieve_transactions(&cb)
+  block := core.Hydrate_from(&cb, txs)
+
+  prev, err := self.Chain.Get_block_header(&cb.Header.Previous)
+  if err != nil {
+    // we don't know the previous block, the full block will be dealt with
+    // as an orphan
+    return self.request_block(addr, &bhash)
+  }
+
+  if _, err := block.Validate(&prev.Total_kernel_offset, &prev.Total_kernel_sum); err != nil {
+    // some transactions are missing from our pool, or the block is invalid,
+    // either way ask for the full block
+    return self.request_block(addr, &bhash)
+  }
+  return self.process_block(block)
+}
+
+func (self *NetToChainAdapter) process_block(b core.Block) bool {
+  return self.Chain.Process_block(b) == nil
+}
+
+func (self *NetToChainAdapter) request_block(addr string, h *core.Hash) bool {
+  return self.Peers.Send_block_request(addr, h) == nil
+}
@@ -0,0 +1,42 @@
+package common
+
+/// Server types
+
+/// Stratum (mining server) configuration
+// #[derive(Debug, Clone, Serialize, Deserialize)]
+type StratumServerConfig struct {
+  /// Run a stratum mining server (the only way to communicate to mine this
+  /// node via grin-miner)
+  Enable_stratum_server bool
+  /// If enabled, the address and port to listen on
+  Stratum_server_addr string
+  /// How long to spend mining a block before trying again
+  Attempt_time_per_block uint32
+  /// Minimum difficulty for worker shares
+  Minimum_share_difficulty uint64
+  /// Base address to the HTTP wallet receiver
+  Wallet_listener_url string
+  /// Attributes the reward to a random private key instead of contacting the
+  /// wallet receiver.
+  Burn_reward bool
+  /// Cuckoo cycle solver used by the internal miner, either "ref" for the
+  /// reference miner or "lean" for the lean edge trimming one. Defaults to
+  /// "ref" when empty.
+  Miner_plugin string
+  /// Number of threads of the lean miner, all the available CPUs when zero
+  Miner_threads int
+}
+
+/// Default stratum server configuration
+func Stratum_server_config_default() StratumServerConfig {
+  return StratumServerConfig{
+    Enable_stratum_server:    false,
+    Stratum_server_addr:      "127.0.0.1:13416",
+    Attempt_time_per_block:   15,
+    Minimum_share_difficulty: 1,
+    Wallet_listener_url:      "http://127.0.0.1:13415",
+    Burn_reward:              false,
+    Miner_plugin:             "ref",
+    Miner_threads:            0,
+  }
+}
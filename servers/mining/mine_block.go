@@ -1,6 +1,18 @@
-/// Serializer that outputs the pre-pow part of the header,
-/// including the nonce (last 8 bytes) that can be sent off
-/// to the miner to mutate at will
-type HeaderPrePowWriter struct {
-  Pre_pow []byte
+package mining
+
+import (
+  "bytes"
+
+  ser "github.com/kelby/go-grin/core"
+  "github.com/kelby/go-grin/core/core"
+)
+
+/// Serializes the pre-pow part of the header, including the nonce (last 8
+/// bytes) that can be sent off to the miner to mutate at will
+func Header_pre_pow(header *core.BlockHeader) ([]byte, error) {
+  var buf bytes.Buffer
+  if err := header.Write_pre_pow(&ser.BinWriter{Sink: &buf}); err != nil {
+    return nil, err
+  }
+  return buf.Bytes(), nil
 }
@@ -1,10 +1,77 @@
+package mining
+
+/// Mining service, gets a block to mine, and based on mining configuration
+/// chooses a version of the cuckoo miner to mine the block and produce a valid
+/// header with its proof-of-work. Any valid mined blocks are submitted to the
+/// network.
+
+import (
+  "log"
+  "sync/atomic"
+  "time"
+
+  "github.com/kelby/go-grin/chain"
+  global "github.com/kelby/go-grin/core"
+  "github.com/kelby/go-grin/core/core"
+  "github.com/kelby/go-grin/core/pow"
+  "github.com/kelby/go-grin/pool"
+  "github.com/kelby/go-grin/servers/common"
+)
+
 type Miner struct {
-  Config StratumServerConfig
+  Config common.StratumServerConfig
   Chain chain.Chain
   Tx_pool pool.TransactionPool
-  Stop AtomicBool
+  Stop atomic.Bool
+  /// The cuckoo cycle solver, either the reference or the lean trimming
+  /// miner (see pow.New_solver)
+  Solver pow.Solver
 
   // Just to hold the port we're on, so this miner can be identified
   // while watching debug output
   Debug_output_id string
 }
+
+/// Creates a new miner, its cuckoo cycle solver being picked by name from
+/// the Miner_plugin of the config ("ref" when not set). Fails on unknown
+/// plugin names.
+func New_miner(config common.StratumServerConfig, chain chain.Chain, tx_pool pool.TransactionPool) (*Miner, error) {
+  plugin := config.Miner_plugin
+  if plugin == "" {
+    plugin = "ref"
+  }
+  solver, err := pow.New_solver(plugin, global.Proofsize(), global.Min_sizeshift(), config.Miner_threads)
+  if err != nil {
+    return nil, err
+  }
+  return &Miner{
+    Config:  config,
+    Chain:   chain,
+    Tx_pool: tx_pool,
+    Solver:  solver,
+  }, nil
+}
+
+/// The inner part of mining loop for the internal miner, kept around mostly
+/// for automated testing purposes. Looks for a pow for at most
+/// attempt_time_per_block seconds on the same block (to give a chance to new
+/// transactions) and as long as the miner isn't stopped.
+func (self *Miner) Inner_mining_loop(b *core.Block, head *core.BlockHeader, attempt_time_per_block uint32) bool {
+  deadline := time.Now().Add(time.Duration(attempt_time_per_block) * time.Second)
+  target := b.Header.Total_difficulty.Sub(head.Total_difficulty)
+
+  for !self.Stop.Load() && time.Now().Before(deadline) {
+    pow_hash := b.Header.Pre_pow_hash()
+    if proof, err := self.Solver.Solve(pow_hash[:], &self.Stop); err == nil {
+      if proof.To_difficulty().Cmp(target) >= 0 {
+        b.Header.Pow = proof
+        stats := self.Solver.Stats()
+        log.Printf("(Server ID: %s) Found valid proof of work at height %d, %.2f graphs/sec, %.4f sols/sec",
+          self.Debug_output_id, b.Header.Height, stats.Graphs_per_sec(), stats.Sols_per_sec())
+        return true
+      }
+    }
+    b.Header.Nonce += 1
+  }
+  return false
+}
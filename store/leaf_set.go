@@ -0,0 +1,203 @@
+package store
+
+/// The Grin leaf_set implementation.
+/// Compact (roaring) bitmap representing the set of leaf positions
+/// that exist and are not currently pruned in the MMR.
+
+import (
+  "bufio"
+  "fmt"
+  "log"
+  "os"
+
+  "github.com/RoaringBitmap/roaring"
+
+  "github.com/kelby/go-grin/core/core"
+)
+
+/// Compact (roaring) bitmap representing the set of positions of
+/// leaves that are currently unpruned in the MMR.
+type LeafSet struct {
+  Path string
+  Bitmap *roaring.Bitmap
+  /// The bitmap as of the last flush, restored on discard
+  Bitmap_bak *roaring.Bitmap
+}
+
+/// Open the leaf_set file.
+/// The content of the file will be read in memory for fast checking.
+func Open_leaf_set(path string) (*LeafSet, error) {
+  bitmap := roaring.New()
+  if _, err := os.Stat(path); err == nil {
+    if bitmap, err = read_bitmap(path); err != nil {
+      return nil, err
+    }
+  } else if !os.IsNotExist(err) {
+    return nil, err
+  }
+  return &LeafSet{
+    Path:       path,
+    Bitmap:     bitmap,
+    Bitmap_bak: bitmap.Clone(),
+  }, nil
+}
+
+/// Copies a snapshot of the leaf_set file into the primary leaf_set file.
+func Copy_snapshot(path string, cp_path string) error {
+  if _, err := os.Stat(cp_path); os.IsNotExist(err) {
+    log.Printf("leaf_set: rewound leaf file not found: %s", cp_path)
+    return nil
+  }
+  bitmap, err := read_bitmap(cp_path)
+  if err != nil {
+    return err
+  }
+  return write_bitmap(path, bitmap)
+}
+
+/// Calculate the set of unpruned leaves up to and including the cutoff_pos.
+/// Only applicable for the output MMR.
+func (self *LeafSet) unpruned_pre_cutoff(cutoff_pos uint64, prune_list *PruneList) *roaring.Bitmap {
+  bitmap := roaring.New()
+  for pos := uint64(1); pos <= cutoff_pos; pos++ {
+    if core.Is_leaf(pos) && !prune_list.Is_pruned(pos) {
+      bitmap.Add(uint32(pos))
+    }
+  }
+  return bitmap
+}
+
+/// Calculate the set of pruned positions up to and including the
+/// cutoff_pos. Uses both the leaf_set and the prune_list to determine
+/// prunedness. The leaf_set is first rewound using the block input and
+/// output bitmaps so outputs spent after the cutoff aren't compacted.
+func (self *LeafSet) Removed_pre_cutoff(cutoff_pos uint64, rewind_add_pos *roaring.Bitmap, rewind_rm_pos *roaring.Bitmap, prune_list *PruneList) *roaring.Bitmap {
+  bitmap := self.Bitmap.Clone()
+
+  // now "rewind" using the rewind_add_pos and rewind_rm_pos bitmaps passed in
+  if rewind_add_pos != nil {
+    bitmap.AndNot(rewind_add_pos)
+  }
+  if rewind_rm_pos != nil {
+    bitmap.Or(rewind_rm_pos)
+  }
+
+  // invert bitmap for the leaf pos and return the resulting bitmap
+  bitmap.Flip(1, cutoff_pos+1)
+  bitmap.And(self.unpruned_pre_cutoff(cutoff_pos, prune_list))
+  return bitmap
+}
+
+/// Rewinds the leaf_set back to a previous state. Removes all the leaves
+/// added after the rewind point (rewind_add_pos) and adds back the ones
+/// spent since then (rewind_rm_pos, the inputs of the rewound blocks).
+func (self *LeafSet) Rewind(rewind_add_pos *roaring.Bitmap, rewind_rm_pos *roaring.Bitmap) {
+  // first remove pos from leaf_set that were
+  // added after the point we are rewinding to
+  if rewind_add_pos != nil {
+    self.Bitmap.AndNot(rewind_add_pos)
+  }
+  // then add back output pos to the leaf_set
+  // that were removed
+  if rewind_rm_pos != nil {
+    self.Bitmap.Or(rewind_rm_pos)
+  }
+}
+
+/// Append a new position to the leaf_set.
+func (self *LeafSet) Add(pos uint64) {
+  self.Bitmap.Add(uint32(pos))
+}
+
+/// Remove the provided position from the leaf_set.
+func (self *LeafSet) Remove(pos uint64) {
+  self.Bitmap.Remove(uint32(pos))
+}
+
+/// Saves the leaf_set file tagged with block hash as filename suffix.
+/// Needed during fast-sync as the receiving node cannot rewind
+/// after receiving the txhashset zip file.
+func (self *LeafSet) Snapshot(header *core.BlockHeader) error {
+  cp_bitmap := self.Bitmap.Clone()
+  cp_bitmap.RunOptimize()
+  cp_path := fmt.Sprintf("%s.%s", self.Path, header.Hash().To_hex())
+  return write_bitmap(cp_path, cp_bitmap)
+}
+
+/// Flush the leaf_set to file.
+func (self *LeafSet) Flush() error {
+  // first run the optimization step on the bitmap
+  self.Bitmap.RunOptimize()
+
+  // write the updated bitmap file to disk
+  if err := write_bitmap(self.Path, self.Bitmap); err != nil {
+    return err
+  }
+
+  // make sure our backup in memory is up to date
+  self.Bitmap_bak = self.Bitmap.Clone()
+  return nil
+}
+
+/// Discard any pending changes.
+func (self *LeafSet) Discard() {
+  self.Bitmap = self.Bitmap_bak.Clone()
+}
+
+/// Whether the leaf_set includes the provided position.
+func (self *LeafSet) Includes(pos uint64) bool {
+  return self.Bitmap.Contains(uint32(pos))
+}
+
+/// Number of positions stored in the leaf_set.
+func (self *LeafSet) Len() uint64 {
+  return self.Bitmap.GetCardinality()
+}
+
+/// Is the leaf_set empty.
+func (self *LeafSet) Is_empty() bool {
+  return self.Len() == 0
+}
+
+/// Reads a serialized roaring bitmap from the file at path.
+func read_bitmap(path string) (*roaring.Bitmap, error) {
+  file, err := os.Open(path)
+  if err != nil {
+    return nil, err
+  }
+  defer file.Close()
+
+  bitmap := roaring.New()
+  if _, err := bitmap.ReadFrom(bufio.NewReader(file)); err != nil {
+    return nil, err
+  }
+  return bitmap, nil
+}
+
+/// Writes the serialized bitmap to the file at path. The bitmap is first
+/// written to a temporary file renamed over the target once synced, so a
+/// crash never leaves a partially written bitmap behind.
+func write_bitmap(path string, bitmap *roaring.Bitmap) error {
+  tmp_path := path + ".tmp"
+  file, err := os.Create(tmp_path)
+  if err != nil {
+    return err
+  }
+  writer := bufio.NewWriter(file)
+  if _, err := bitmap.WriteTo(writer); err != nil {
+    file.Close()
+    return err
+  }
+  if err := writer.Flush(); err != nil {
+    file.Close()
+    return err
+  }
+  if err := file.Sync(); err != nil {
+    file.Close()
+    return err
+  }
+  if err := file.Close(); err != nil {
+    return err
+  }
+  return os.Rename(tmp_path, path)
+}
@@ -0,0 +1,214 @@
+package store
+
+import (
+  "fmt"
+  "math/rand"
+  "os"
+  "path/filepath"
+  "reflect"
+  "testing"
+
+  "github.com/RoaringBitmap/roaring"
+
+  "github.com/kelby/go-grin/core/core"
+)
+
+func open_test_leaf_set(t *testing.T, path string) *LeafSet {
+  t.Helper()
+  leaf_set, err := Open_leaf_set(path)
+  if err != nil {
+    t.Fatal(err)
+  }
+  return leaf_set
+}
+
+func Test_leaf_set_add_remove(t *testing.T) {
+  leaf_set := open_test_leaf_set(t, filepath.Join(t.TempDir(), "pmmr_leaf.bin"))
+  if !leaf_set.Is_empty() {
+    t.Fatal("new leaf set not empty")
+  }
+  for _, pos := range []uint64{1, 2, 4, 5, 8} {
+    leaf_set.Add(pos)
+  }
+  leaf_set.Remove(2)
+  leaf_set.Remove(3)
+  if leaf_set.Len() != 4 {
+    t.Fatalf("leaf set of %d positions, expected 4", leaf_set.Len())
+  }
+  for pos, included := range map[uint64]bool{1: true, 2: false, 3: false, 4: true, 5: true, 8: true, 9: false} {
+    if leaf_set.Includes(pos) != included {
+      t.Fatalf("includes(%d) = %t, expected %t", pos, !included, included)
+    }
+  }
+}
+
+func Test_leaf_set_flush_discard(t *testing.T) {
+  path := filepath.Join(t.TempDir(), "pmmr_leaf.bin")
+  leaf_set := open_test_leaf_set(t, path)
+  leaf_set.Add(1)
+  leaf_set.Add(2)
+  leaf_set.Add(4)
+  if err := leaf_set.Flush(); err != nil {
+    t.Fatal(err)
+  }
+
+  // pending changes get discarded back to the flushed state
+  leaf_set.Add(5)
+  leaf_set.Remove(1)
+  leaf_set.Discard()
+  if !reflect.DeepEqual(leaf_set.Bitmap.ToArray(), []uint32{1, 2, 4}) {
+    t.Fatalf("leaf set %v after discard, expected [1 2 4]", leaf_set.Bitmap.ToArray())
+  }
+
+  // and the flushed state is what's read back from disk
+  leaf_set.Remove(2)
+  reopened := open_test_leaf_set(t, path)
+  if !reflect.DeepEqual(reopened.Bitmap.ToArray(), []uint32{1, 2, 4}) {
+    t.Fatalf("reopened leaf set %v, expected [1 2 4]", reopened.Bitmap.ToArray())
+  }
+  if err := leaf_set.Flush(); err != nil {
+    t.Fatal(err)
+  }
+  reopened = open_test_leaf_set(t, path)
+  if !reflect.DeepEqual(reopened.Bitmap.ToArray(), []uint32{1, 4}) {
+    t.Fatalf("reopened leaf set %v, expected [1 4]", reopened.Bitmap.ToArray())
+  }
+  reopened.Add(8)
+  reopened.Discard()
+  if reopened.Includes(8) {
+    t.Fatal("reopened leaf set didn't discard back to the file content")
+  }
+}
+
+func Test_leaf_set_rewind(t *testing.T) {
+  leaf_set := open_test_leaf_set(t, filepath.Join(t.TempDir(), "pmmr_leaf.bin"))
+  for _, pos := range []uint64{1, 2, 4, 5, 8, 9} {
+    leaf_set.Add(pos)
+  }
+  leaf_set.Remove(2)
+  leaf_set.Remove(5)
+
+  // rewinding the block that added 8 and 9 and spent 5
+  leaf_set.Rewind(roaring.BitmapOf(8, 9), roaring.BitmapOf(5))
+  if !reflect.DeepEqual(leaf_set.Bitmap.ToArray(), []uint32{1, 4, 5}) {
+    t.Fatalf("rewound leaf set %v, expected [1 4 5]", leaf_set.Bitmap.ToArray())
+  }
+  leaf_set.Rewind(nil, nil)
+  if leaf_set.Len() != 3 {
+    t.Fatal("empty rewind changed the leaf set")
+  }
+}
+
+/// Brute force removed_pre_cutoff: the leaves up to the cutoff neither in
+/// the rewound leaf set nor pruned.
+func naive_removed_pre_cutoff(leaves map[uint64]bool, cutoff_pos uint64, rewind_add_pos map[uint64]bool, rewind_rm_pos map[uint64]bool, prune_list *PruneList) []uint32 {
+  removed := []uint32{}
+  for pos := uint64(1); pos <= cutoff_pos; pos++ {
+    in_leaf_set := leaves[pos] && !rewind_add_pos[pos] || rewind_rm_pos[pos]
+    if core.Is_leaf(pos) && !prune_list.Is_pruned(pos) && !in_leaf_set {
+      removed = append(removed, uint32(pos))
+    }
+  }
+  return removed
+}
+
+func Test_leaf_set_removed_pre_cutoff(t *testing.T) {
+  rng := rand.New(rand.NewSource(1))
+  const n_leaves = 64
+  max := uint64(2*n_leaves - 1)
+
+  for round := 0; round < 50; round++ {
+    leaf_set := open_test_leaf_set(t, filepath.Join(t.TempDir(), "pmmr_leaf.bin"))
+    prune_list := Prune_list_empty()
+    leaves := map[uint64]bool{}
+    add_pos := map[uint64]bool{}
+    rm_pos := map[uint64]bool{}
+    rewind_add_pos := roaring.New()
+    rewind_rm_pos := roaring.New()
+
+    for leaf := uint64(1); leaf <= n_leaves; leaf++ {
+      pos := core.Insertion_to_pmmr_index(leaf)
+      leaf_set.Add(pos)
+      leaves[pos] = true
+      switch rng.Intn(6) {
+      case 0, 1:
+        // spent, and maybe pruned already
+        leaf_set.Remove(pos)
+        delete(leaves, pos)
+        if rng.Intn(2) == 0 {
+          prune_list.Add(pos)
+        } else if rng.Intn(2) == 0 {
+          // or spent by a block we rewind
+          rewind_rm_pos.Add(uint32(pos))
+          rm_pos[pos] = true
+        }
+      case 2:
+        // added by a block we rewind
+        rewind_add_pos.Add(uint32(pos))
+        add_pos[pos] = true
+      }
+    }
+    prune_list.Flush()
+
+    cutoff_pos := uint64(rng.Intn(int(max))) + 1
+    removed := leaf_set.Removed_pre_cutoff(cutoff_pos, rewind_add_pos, rewind_rm_pos, prune_list)
+    expected := naive_removed_pre_cutoff(leaves, cutoff_pos, add_pos, rm_pos, prune_list)
+    if !reflect.DeepEqual(removed.ToArray(), expected) {
+      t.Fatalf("removed_pre_cutoff(%d) = %v, expected %v", cutoff_pos, removed.ToArray(), expected)
+    }
+
+    // the leaf set itself isn't rewound
+    if leaf_set.Len() != uint64(len(leaves)) {
+      t.Fatal("removed_pre_cutoff changed the leaf set")
+    }
+    removed = leaf_set.Removed_pre_cutoff(cutoff_pos, nil, nil, prune_list)
+    expected = naive_removed_pre_cutoff(leaves, cutoff_pos, nil, nil, prune_list)
+    if !reflect.DeepEqual(removed.ToArray(), expected) {
+      t.Fatalf("removed_pre_cutoff(%d) without rewind = %v, expected %v", cutoff_pos, removed.ToArray(), expected)
+    }
+  }
+}
+
+func Test_leaf_set_snapshot(t *testing.T) {
+  path := filepath.Join(t.TempDir(), "pmmr_leaf.bin")
+  leaf_set := open_test_leaf_set(t, path)
+  for _, pos := range []uint64{1, 2, 4, 5} {
+    leaf_set.Add(pos)
+  }
+  if err := leaf_set.Flush(); err != nil {
+    t.Fatal(err)
+  }
+
+  // snapshot the current state, then move on
+  header := core.Default()
+  if err := leaf_set.Snapshot(&header); err != nil {
+    t.Fatal(err)
+  }
+  cp_path := fmt.Sprintf("%s.%s", path, header.Hash().To_hex())
+  if _, err := os.Stat(cp_path); err != nil {
+    t.Fatal(err)
+  }
+  leaf_set.Remove(1)
+  leaf_set.Add(8)
+  if err := leaf_set.Flush(); err != nil {
+    t.Fatal(err)
+  }
+
+  // copying the snapshot back restores its state
+  if err := Copy_snapshot(path, cp_path); err != nil {
+    t.Fatal(err)
+  }
+  restored := open_test_leaf_set(t, path)
+  if !reflect.DeepEqual(restored.Bitmap.ToArray(), []uint32{1, 2, 4, 5}) {
+    t.Fatalf("restored leaf set %v, expected [1 2 4 5]", restored.Bitmap.ToArray())
+  }
+
+  // a missing snapshot leaves the leaf set file alone
+  if err := Copy_snapshot(path, cp_path+".missing"); err != nil {
+    t.Fatal(err)
+  }
+  restored = open_test_leaf_set(t, path)
+  if restored.Len() != 4 {
+    t.Fatal("missing snapshot changed the leaf set")
+  }
+}
@@ -14,9 +14,9 @@ package store
 type  PMMRBackend struct {
   Data_dir string
   Prunable bool
-  Hash_file AppendOnlyFile
-  Data_file AppendOnlyFile
-  Leaf_set LeafSet
-  Prune_list PruneList
+  Hash_file *AppendOnlyFile
+  Data_file *AppendOnlyFile
+  Leaf_set *LeafSet
+  Prune_list *PruneList
   marker marker.PhantomData<PMMRable>
 }
@@ -0,0 +1,251 @@
+package store
+
+/// The Grin "Prune List" implementation.
+/// Implemented as a compact (roaring) bitmap of pruned root positions.
+///
+/// Maintains a set of pruned root node positions that define the pruned
+/// and compacted "gaps" in the MMR data and hash files.
+/// The root itself is maintained in the hash file, but all positions beneath
+/// the root are compacted away. All positions to the right of a pruned node
+/// must be shifted the appropriate amount when reading from the hash and data
+/// files.
+
+import (
+  "log"
+  "os"
+
+  "github.com/RoaringBitmap/roaring"
+
+  "github.com/kelby/go-grin/core/core"
+)
+
+/// Maintains a list of previously pruned nodes in PMMR, compacting the list
+/// as parents get pruned and allowing checking whether a leaf is pruned.
+/// Given a node's position, computes how much it should get shifted given
+/// the subtrees that have been pruned before.
+///
+/// The PruneList is useful when we want to compact the hash file or data
+/// file, we need to know the positions of all the nodes that will be
+/// shifted.
+type PruneList struct {
+  /// File the list gets persisted to, in memory only when empty
+  Path string
+  /// Bitmap representing pruned root node positions.
+  Bitmap *roaring.Bitmap
+  /// Bitmap representing all pruned node positions (everything under the
+  /// pruned roots).
+  pruned_cache *roaring.Bitmap
+  /// Cumulated shift for each pruned root, in the bitmap order
+  shift_cache []uint64
+  /// Cumulated leaf shift for each pruned root, in the bitmap order
+  leaf_shift_cache []uint64
+}
+
+/// Instantiate a new prune list from the provided path and bitmap.
+func New_prune_list(path string, bitmap *roaring.Bitmap) *PruneList {
+  prune_list := &PruneList{
+    Path:   path,
+    Bitmap: bitmap,
+  }
+  prune_list.Init_caches()
+  return prune_list
+}
+
+/// Instantiate a new empty prune list.
+func Prune_list_empty() *PruneList {
+  return New_prune_list("", roaring.New())
+}
+
+/// Open an existing prune_list or create a new one.
+func Open_prune_list(path string) (*PruneList, error) {
+  bitmap := roaring.New()
+  if _, err := os.Stat(path); err == nil {
+    if bitmap, err = read_bitmap(path); err != nil {
+      return nil, err
+    }
+  } else if !os.IsNotExist(err) {
+    return nil, err
+  }
+
+  // the shift and pruned caches get built from the bitmap read from disk
+  prune_list := New_prune_list(path, bitmap)
+  if !prune_list.Is_empty() {
+    log.Printf("prune_list: bitmap %d pos (%d bytes), pruned_cache %d pos, shift_cache %d, leaf_shift_cache %d",
+      prune_list.Bitmap.GetCardinality(), prune_list.Bitmap.GetSizeInBytes(),
+      prune_list.pruned_cache.GetCardinality(), len(prune_list.shift_cache),
+      len(prune_list.leaf_shift_cache))
+  }
+  return prune_list, nil
+}
+
+/// Builds the pruned, shift and leaf shift caches from the bitmap of
+/// pruned roots.
+func (self *PruneList) Init_caches() {
+  self.build_shift_cache()
+  self.build_leaf_shift_cache()
+  self.build_pruned_cache()
+}
+
+/// Save the prune_list to disk.
+func (self *PruneList) Flush() error {
+  // run the optimization step on the bitmap
+  self.Bitmap.RunOptimize()
+
+  // write the updated bitmap file to disk
+  if self.Path != "" {
+    if err := write_bitmap(self.Path, self.Bitmap); err != nil {
+      return err
+    }
+  }
+
+  // rebuild our "shift caches" here as we are flushing changes to disk
+  // and the contents of our prune_list has likely changed
+  self.Init_caches()
+  return nil
+}
+
+/// Return the total shift from all entries in the prune_list.
+/// This is the shift we need to account for when adding new entries to our
+/// PMMR.
+func (self *PruneList) Get_total_shift() uint64 {
+  if self.Bitmap.IsEmpty() {
+    return 0
+  }
+  return self.Get_shift(uint64(self.Bitmap.Maximum()))
+}
+
+/// Return the total leaf_shift from all entries in the prune_list.
+/// This is the leaf_shift we need to account for when adding new entries to
+/// our PMMR.
+func (self *PruneList) Get_total_leaf_shift() uint64 {
+  if self.Bitmap.IsEmpty() {
+    return 0
+  }
+  return self.Get_leaf_shift(uint64(self.Bitmap.Maximum()))
+}
+
+/// Computes by how many positions a node at pos should be shifted given the
+/// number of nodes that have already been pruned before it.
+/// Note: the node at pos may be pruned and may be compacted away itself and
+/// the caller needs to be aware of this. The shift caches are only up to
+/// date as of the last flush.
+func (self *PruneList) Get_shift(pos uint64) uint64 {
+  return cached_shift(self.Bitmap, self.shift_cache, pos)
+}
+
+/// As above, but only returning the number of leaf nodes to skip for a
+/// given leaf. Helpful if, for instance, data for each leaf is being stored
+/// separately in a continuous flat-file.
+func (self *PruneList) Get_leaf_shift(pos uint64) uint64 {
+  return cached_shift(self.Bitmap, self.leaf_shift_cache, pos)
+}
+
+/// Looks up the cumulated shift of the last pruned root at or before pos,
+/// ranking pos in the bitmap of pruned roots.
+func cached_shift(bitmap *roaring.Bitmap, cache []uint64, pos uint64) uint64 {
+  if bitmap.IsEmpty() || len(cache) == 0 {
+    return 0
+  }
+  idx := bitmap.Rank(uint32(pos))
+  if idx == 0 {
+    return 0
+  }
+  if idx > uint64(len(cache)) {
+    return cache[len(cache)-1]
+  }
+  return cache[idx-1]
+}
+
+/// Each pruned root compacts away all the nodes beneath it.
+func (self *PruneList) build_shift_cache() {
+  self.shift_cache = make([]uint64, 0, self.Bitmap.GetCardinality())
+  prev_shift := uint64(0)
+  for _, pos := range self.Bitmap.ToArray() {
+    height := core.Bintree_postorder_height(uint64(pos))
+    prev_shift += 2 * ((1 << height) - 1)
+    self.shift_cache = append(self.shift_cache, prev_shift)
+  }
+}
+
+/// Each pruned root (other than a lone leaf) compacts away all the leaves
+/// beneath it.
+func (self *PruneList) build_leaf_shift_cache() {
+  self.leaf_shift_cache = make([]uint64, 0, self.Bitmap.GetCardinality())
+  prev_shift := uint64(0)
+  for _, pos := range self.Bitmap.ToArray() {
+    height := core.Bintree_postorder_height(uint64(pos))
+    if height > 0 {
+      prev_shift += 1 << height
+    }
+    self.leaf_shift_cache = append(self.leaf_shift_cache, prev_shift)
+  }
+}
+
+/// Every position under a pruned root (the root included) is pruned, the
+/// subtree of a root spans from its leftmost leaf up to the root itself.
+func (self *PruneList) build_pruned_cache() {
+  self.pruned_cache = roaring.New()
+  for _, pos := range self.Bitmap.ToArray() {
+    leftmost := core.Bintree_leftmost(uint64(pos))
+    self.pruned_cache.AddRange(leftmost, uint64(pos)+1)
+  }
+}
+
+/// Push the node at the provided position in the prune list. Compacts the
+/// list if pruning the additional node means a parent can get pruned as
+/// well.
+func (self *PruneList) Add(pos uint64) {
+  if pos == 0 {
+    panic("prune list 1-indexed, 0 not valid pos")
+  }
+
+  current := pos
+  for {
+    parent, sibling := core.Family(current)
+    self.pruned_cache.Add(uint32(current))
+    if self.Bitmap.Contains(uint32(sibling)) || self.pruned_cache.Contains(uint32(sibling)) {
+      // both children are pruned, the parent replaces the sibling root
+      self.Bitmap.Remove(uint32(sibling))
+      current = parent
+    } else {
+      self.Bitmap.Add(uint32(current))
+      break
+    }
+  }
+}
+
+/// Number of entries in the prune_list.
+func (self *PruneList) Len() uint64 {
+  return self.Bitmap.GetCardinality()
+}
+
+/// Is the prune_list empty?
+func (self *PruneList) Is_empty() bool {
+  return self.Bitmap.IsEmpty()
+}
+
+/// Convert the prune_list to a vec of pos.
+func (self *PruneList) To_vec() []uint64 {
+  vec := []uint64{}
+  for _, pos := range self.Bitmap.ToArray() {
+    vec = append(vec, uint64(pos))
+  }
+  return vec
+}
+
+/// Is the pos pruned?
+/// Assumes the pruned_cache is fully built and up to date.
+func (self *PruneList) Is_pruned(pos uint64) bool {
+  if pos == 0 {
+    panic("prune list 1-indexed, 0 not valid pos")
+  }
+  return self.pruned_cache.Contains(uint32(pos))
+}
+
+/// Is the pos the root of a pruned subtree?
+func (self *PruneList) Is_pruned_root(pos uint64) bool {
+  if pos == 0 {
+    panic("prune list 1-indexed, 0 not valid pos")
+  }
+  return self.Bitmap.Contains(uint32(pos))
+}
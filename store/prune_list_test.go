@@ -0,0 +1,193 @@
+package store
+
+import (
+  "math/rand"
+  "path/filepath"
+  "reflect"
+  "testing"
+
+  "github.com/kelby/go-grin/core/core"
+)
+
+/// Brute force model of a prune list: the set of all the pruned positions,
+/// a parent being pruned as soon as both its children are.
+type naive_prune_list struct {
+  pruned map[uint64]bool
+}
+
+func (self *naive_prune_list) add(pos uint64) {
+  self.pruned[pos] = true
+  for {
+    parent, sibling := core.Family(pos)
+    if !self.pruned[sibling] {
+      return
+    }
+    self.pruned[parent] = true
+    pos = parent
+  }
+}
+
+func (self *naive_prune_list) is_root(pos uint64) bool {
+  parent, _ := core.Family(pos)
+  return self.pruned[pos] && !self.pruned[parent]
+}
+
+/// Nodes compacted away (all but the roots) up to pos.
+func (self *naive_prune_list) shift(pos uint64) uint64 {
+  shift := uint64(0)
+  for root := uint64(1); root <= pos; root++ {
+    if !self.is_root(root) {
+      continue
+    }
+    for n := core.Bintree_leftmost(root); n < root; n++ {
+      if self.pruned[n] {
+        shift++
+      }
+    }
+  }
+  return shift
+}
+
+/// Leaves compacted away up to pos, a lone pruned leaf keeping its data.
+func (self *naive_prune_list) leaf_shift(pos uint64) uint64 {
+  shift := uint64(0)
+  for root := uint64(1); root <= pos; root++ {
+    if !self.is_root(root) || core.Is_leaf(root) {
+      continue
+    }
+    for n := core.Bintree_leftmost(root); n < root; n++ {
+      if core.Is_leaf(n) && self.pruned[n] {
+        shift++
+      }
+    }
+  }
+  return shift
+}
+
+func (self *naive_prune_list) roots(max uint64) []uint64 {
+  roots := []uint64{}
+  for pos := uint64(1); pos <= max; pos++ {
+    if self.is_root(pos) {
+      roots = append(roots, pos)
+    }
+  }
+  return roots
+}
+
+func check_prune_list(t *testing.T, prune_list *PruneList, naive *naive_prune_list, max uint64) {
+  t.Helper()
+  roots := naive.roots(max)
+  if !reflect.DeepEqual(prune_list.To_vec(), roots) {
+    t.Fatalf("pruned roots %v, expected %v", prune_list.To_vec(), roots)
+  }
+  for pos := uint64(1); pos <= max; pos++ {
+    if prune_list.Is_pruned(pos) != naive.pruned[pos] {
+      t.Fatalf("is_pruned(%d) = %t, expected %t", pos, prune_list.Is_pruned(pos), naive.pruned[pos])
+    }
+    if prune_list.Is_pruned_root(pos) != naive.is_root(pos) {
+      t.Fatalf("is_pruned_root(%d) = %t, expected %t", pos, prune_list.Is_pruned_root(pos), naive.is_root(pos))
+    }
+    if shift := prune_list.Get_shift(pos); shift != naive.shift(pos) {
+      t.Fatalf("get_shift(%d) = %d, expected %d", pos, shift, naive.shift(pos))
+    }
+    if shift := prune_list.Get_leaf_shift(pos); shift != naive.leaf_shift(pos) {
+      t.Fatalf("get_leaf_shift(%d) = %d, expected %d", pos, shift, naive.leaf_shift(pos))
+    }
+  }
+  if shift := prune_list.Get_total_shift(); shift != naive.shift(max) {
+    t.Fatalf("get_total_shift() = %d, expected %d", shift, naive.shift(max))
+  }
+  if shift := prune_list.Get_total_leaf_shift(); shift != naive.leaf_shift(max) {
+    t.Fatalf("get_total_leaf_shift() = %d, expected %d", shift, naive.leaf_shift(max))
+  }
+}
+
+func Test_prune_list_basics(t *testing.T) {
+  prune_list := Prune_list_empty()
+  if !prune_list.Is_empty() || prune_list.Get_total_shift() != 0 || prune_list.Get_shift(10) != 0 {
+    t.Fatal("empty prune list not empty")
+  }
+
+  // a lone leaf is its own root and shifts nothing
+  prune_list.Add(1)
+  prune_list.Flush()
+  if !reflect.DeepEqual(prune_list.To_vec(), []uint64{1}) || prune_list.Get_shift(2) != 0 {
+    t.Fatalf("wrong prune list %v", prune_list.To_vec())
+  }
+
+  // its sibling compacts both leaves under their parent
+  prune_list.Add(2)
+  prune_list.Flush()
+  if !reflect.DeepEqual(prune_list.To_vec(), []uint64{3}) {
+    t.Fatalf("wrong prune list %v", prune_list.To_vec())
+  }
+  if prune_list.Get_shift(3) != 2 || prune_list.Get_leaf_shift(3) != 2 || !prune_list.Is_pruned(1) {
+    t.Fatal("wrong shifts for a compacted parent")
+  }
+
+  // up to the next level
+  prune_list.Add(4)
+  prune_list.Add(5)
+  prune_list.Flush()
+  if !reflect.DeepEqual(prune_list.To_vec(), []uint64{7}) || prune_list.Len() != 1 {
+    t.Fatalf("wrong prune list %v", prune_list.To_vec())
+  }
+  if prune_list.Get_shift(7) != 6 || prune_list.Get_leaf_shift(7) != 4 {
+    t.Fatal("wrong shifts for a compacted subtree")
+  }
+}
+
+func Test_prune_list_random(t *testing.T) {
+  rng := rand.New(rand.NewSource(1))
+  // leaves of a full MMR, so all the parents are within its size
+  const n_leaves = 64
+  max := uint64(2*n_leaves - 1)
+
+  for round := 0; round < 50; round++ {
+    prune_list := Prune_list_empty()
+    naive := &naive_prune_list{pruned: map[uint64]bool{}}
+    for _, leaf := range rng.Perm(n_leaves)[:rng.Intn(n_leaves)+1] {
+      pos := core.Insertion_to_pmmr_index(uint64(leaf + 1))
+      prune_list.Add(pos)
+      naive.add(pos)
+
+      // the shift caches are only up to date as of the last flush
+      if rng.Intn(4) == 0 {
+        prune_list.Flush()
+        check_prune_list(t, prune_list, naive, max)
+      }
+    }
+    prune_list.Flush()
+    check_prune_list(t, prune_list, naive, max)
+  }
+}
+
+func Test_prune_list_save_open(t *testing.T) {
+  path := filepath.Join(t.TempDir(), "pmmr_prun.bin")
+  prune_list, err := Open_prune_list(path)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if !prune_list.Is_empty() {
+    t.Fatal("new prune list not empty")
+  }
+
+  naive := &naive_prune_list{pruned: map[uint64]bool{}}
+  for _, leaf := range []uint64{1, 2, 4, 8, 9, 10, 11, 12, 20} {
+    pos := core.Insertion_to_pmmr_index(leaf)
+    prune_list.Add(pos)
+    naive.add(pos)
+  }
+  if err := prune_list.Flush(); err != nil {
+    t.Fatal(err)
+  }
+
+  reopened, err := Open_prune_list(path)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if !reflect.DeepEqual(reopened.To_vec(), prune_list.To_vec()) {
+    t.Fatalf("reopened prune list %v, expected %v", reopened.To_vec(), prune_list.To_vec())
+  }
+  check_prune_list(t, reopened, naive, 63)
+}
@@ -1,5 +1,15 @@
 package store
 
+/// Common storage-related types
+
+import (
+  "bufio"
+  "errors"
+  "io"
+  "os"
+  "syscall"
+)
+
 /// Wrapper for a file that can be read at any position (random read) but for
 /// which writes are append only. Reads are backed by a memory map (mmap(2)),
 /// relying on the operating system for fast access and caching. The memory
@@ -8,11 +18,239 @@ package store
 /// Despite being append-only, the file can still be pruned and truncated. The
 /// former simply happens by rewriting it, ignoring some of the data. The
 /// latter by truncating the underlying file and re-creating the mmap.
+///
+/// Appended data is only kept in memory until flushed, so a crash between an
+/// append and a flush leaves the file as it was at the last flush.
 type AppendOnlyFile struct {
   Path string
-  File File
-  Mmap memmap.Mmap
-  Buffer_start usize
+  File *os.File
+  Mmap []byte
+  /// Offset in the file of the first byte of the buffer, also the size of
+  /// the file as far as reads are concerned
+  Buffer_start int
+  /// Data appended since the last flush
   Buffer []uint8
-  Buffer_start_bak usize
+  /// Buffer start before a rewind, zero if the file hasn't been rewound
+  /// since the last flush
+  Buffer_start_bak int
+}
+
+/// Open a file (existing or not) as append-only, backed by a mmap.
+func Open_append_only_file(path string) (*AppendOnlyFile, error) {
+  file, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0644)
+  if err != nil {
+    return nil, err
+  }
+  aof := &AppendOnlyFile{
+    Path:   path,
+    File:   file,
+    Buffer: []uint8{},
+  }
+  // if we have a non-empty file then mmap it
+  sz, err := aof.Size()
+  if err != nil {
+    file.Close()
+    return nil, err
+  }
+  if sz > 0 {
+    aof.Buffer_start = int(sz)
+    if err := aof.remap(); err != nil {
+      file.Close()
+      return nil, err
+    }
+  }
+  return aof, nil
+}
+
+/// Append data to the file. Until the append-only file is synced, data is
+/// only written to memory.
+func (self *AppendOnlyFile) Append(buf []byte) {
+  self.Buffer = append(self.Buffer, buf...)
+}
+
+/// Rewinds the data file back to a lower position. The new position needs
+/// to be the one of the first byte the next time data is appended.
+func (self *AppendOnlyFile) Rewind(file_pos uint64) {
+  pos := int(file_pos)
+  if pos >= self.Buffer_start {
+    // still within the data not flushed yet, only the buffer gets truncated
+    if buffer_len := pos - self.Buffer_start; buffer_len < len(self.Buffer) {
+      self.Buffer = self.Buffer[:buffer_len]
+    }
+    return
+  }
+
+  if self.Buffer_start_bak == 0 {
+    self.Buffer_start_bak = self.Buffer_start
+  }
+  self.Buffer = self.Buffer[:0]
+  self.Buffer_start = pos
+}
+
+/// Syncs all writes (fsync), reallocating the memory map to make the newly
+/// written data accessible.
+func (self *AppendOnlyFile) Flush() error {
+  if self.Buffer_start_bak > 0 {
+    // flushing a rewound state, we need to truncate before applying
+    if err := self.File.Truncate(int64(self.Buffer_start)); err != nil {
+      return err
+    }
+    self.Buffer_start_bak = 0
+  }
+
+  if _, err := self.File.Write(self.Buffer); err != nil {
+    return err
+  }
+  if err := self.File.Sync(); err != nil {
+    return err
+  }
+  self.Buffer_start += len(self.Buffer)
+  self.Buffer = []uint8{}
+
+  return self.remap()
+}
+
+/// Discard the current non-flushed data.
+func (self *AppendOnlyFile) Discard() {
+  if self.Buffer_start_bak > 0 {
+    // discarding a rewind, go back to the original buffer start
+    self.Buffer_start = self.Buffer_start_bak
+    self.Buffer_start_bak = 0
+  }
+  self.Buffer = []uint8{}
+}
+
+/// Read length bytes of data at offset from the file. Leverages the memory
+/// map, or the buffer for data that hasn't been flushed yet. Returns an
+/// empty slice if the data isn't there.
+func (self *AppendOnlyFile) Read(offset int, length int) []byte {
+  if offset >= self.Buffer_start {
+    return self.read_from_buffer(offset-self.Buffer_start, length)
+  }
+  // the mmap may extend past a rewind that hasn't been flushed yet
+  if offset+length > self.Buffer_start || offset+length > len(self.Mmap) {
+    return []byte{}
+  }
+  res := make([]byte, length)
+  copy(res, self.Mmap[offset:offset+length])
+  return res
+}
+
+func (self *AppendOnlyFile) read_from_buffer(offset int, length int) []byte {
+  if offset+length > len(self.Buffer) {
+    return []byte{}
+  }
+  res := make([]byte, length)
+  copy(res, self.Buffer[offset:offset+length])
+  return res
+}
+
+/// Saves a copy of the current file content, skipping data at the provided
+/// prune offsets (sorted in increasing order). prune_len is the size of
+/// each pruned element and prune_cb, if provided, gets called with the
+/// bytes of every element skipped. Only flushed data is saved.
+func (self *AppendOnlyFile) Save_prune(target string, prune_offs []uint64, prune_len uint64, prune_cb func([]byte)) error {
+  if prune_len == 0 {
+    return errors.New("prune length must be positive")
+  }
+
+  reader, err := os.Open(self.Path)
+  if err != nil {
+    return err
+  }
+  defer reader.Close()
+
+  file, err := os.Create(target)
+  if err != nil {
+    return err
+  }
+  defer file.Close()
+  writer := bufio.NewWriter(file)
+
+  // the buffer is aligned on prune_len so a pruned element never straddles
+  // two reads
+  buf := make([]byte, prune_len*256)
+  read := uint64(0)
+  prune_pos := 0
+  for {
+    n, err := io.ReadFull(reader, buf)
+    if err == io.EOF {
+      break
+    }
+    if err != nil && err != io.ErrUnexpectedEOF {
+      return err
+    }
+    length := uint64(n)
+
+    // write the buffer, except for the pruned elements falling in the
+    // current span
+    buf_start := uint64(0)
+    for prune_pos < len(prune_offs) && prune_offs[prune_pos] < read+length {
+      prune_at := prune_offs[prune_pos] - read
+      if _, err := writer.Write(buf[buf_start:prune_at]); err != nil {
+        return err
+      }
+      if prune_cb != nil {
+        prune_cb(buf[prune_at : prune_at+prune_len])
+      }
+      buf_start = prune_at + prune_len
+      prune_pos += 1
+    }
+    if _, err := writer.Write(buf[buf_start:length]); err != nil {
+      return err
+    }
+    read += length
+  }
+
+  if err := writer.Flush(); err != nil {
+    return err
+  }
+  return file.Sync()
+}
+
+/// Current size of the file in bytes, flushed data only.
+func (self *AppendOnlyFile) Size() (uint64, error) {
+  info, err := os.Stat(self.Path)
+  if err != nil {
+    return 0, err
+  }
+  return uint64(info.Size()), nil
+}
+
+/// Releases the memory map and closes the underlying file.
+func (self *AppendOnlyFile) Close() error {
+  if err := self.unmap(); err != nil {
+    return err
+  }
+  return self.File.Close()
+}
+
+/// Re-creates the memory map over the whole file. A file must be non-empty
+/// to be memory mapped.
+func (self *AppendOnlyFile) remap() error {
+  if err := self.unmap(); err != nil {
+    return err
+  }
+  info, err := self.File.Stat()
+  if err != nil {
+    return err
+  }
+  if info.Size() == 0 {
+    return nil
+  }
+  mmap, err := syscall.Mmap(int(self.File.Fd()), 0, int(info.Size()), syscall.PROT_READ, syscall.MAP_SHARED)
+  if err != nil {
+    return err
+  }
+  self.Mmap = mmap
+  return nil
+}
+
+func (self *AppendOnlyFile) unmap() error {
+  if self.Mmap == nil {
+    return nil
+  }
+  err := syscall.Munmap(self.Mmap)
+  self.Mmap = nil
+  return err
 }
@@ -0,0 +1,186 @@
+package store
+
+import (
+  "bytes"
+  "os"
+  "path/filepath"
+  "testing"
+)
+
+func open_test_file(t *testing.T, name string) *AppendOnlyFile {
+  aof, err := Open_append_only_file(filepath.Join(t.TempDir(), name))
+  if err != nil {
+    t.Fatal(err)
+  }
+  t.Cleanup(func() { aof.Close() })
+  return aof
+}
+
+func check_file(t *testing.T, aof *AppendOnlyFile, expected string) {
+  t.Helper()
+  content, err := os.ReadFile(aof.Path)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if string(content) != expected {
+    t.Fatalf("file content %q, expected %q", content, expected)
+  }
+  if sz, _ := aof.Size(); sz != uint64(len(expected)) {
+    t.Fatalf("file size %d, expected %d", sz, len(expected))
+  }
+  if read := aof.Read(0, len(expected)); string(read) != expected {
+    t.Fatalf("read %q, expected %q", read, expected)
+  }
+}
+
+func Test_append_flush_read(t *testing.T) {
+  aof := open_test_file(t, "data")
+  aof.Append([]byte("aaaa"))
+  if string(aof.Read(1, 2)) != "aa" {
+    t.Fatal("can't read unflushed data")
+  }
+  if err := aof.Flush(); err != nil {
+    t.Fatal(err)
+  }
+  aof.Append([]byte("bbbb"))
+  if string(aof.Read(2, 2)) != "aa" || string(aof.Read(4, 4)) != "bbbb" {
+    t.Fatal("can't read across flushed and buffered data")
+  }
+  if len(aof.Read(6, 4)) != 0 {
+    t.Fatal("read past the end")
+  }
+  aof.Discard()
+  if len(aof.Read(4, 4)) != 0 {
+    t.Fatal("discarded data still readable")
+  }
+  check_file(t, aof, "aaaa")
+
+  // reopening maps the existing content
+  aof.Append([]byte("cccc"))
+  if err := aof.Flush(); err != nil {
+    t.Fatal(err)
+  }
+  aof.Close()
+  aof, err := Open_append_only_file(aof.Path)
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer aof.Close()
+  check_file(t, aof, "aaaacccc")
+}
+
+func Test_rewind_then_flush(t *testing.T) {
+  // rewinding to the end of the buffered data keeps all of it
+  aof := open_test_file(t, "end")
+  aof.Append([]byte("aaaa"))
+  aof.Append([]byte("bbbb"))
+  aof.Append([]byte("cccc"))
+  aof.Rewind(12)
+  if err := aof.Flush(); err != nil {
+    t.Fatal(err)
+  }
+  check_file(t, aof, "aaaabbbbcccc")
+
+  // rewinding inside the buffer only drops the end of the buffer
+  aof = open_test_file(t, "buffer")
+  aof.Append([]byte("aaaa"))
+  if err := aof.Flush(); err != nil {
+    t.Fatal(err)
+  }
+  aof.Append([]byte("bbbbcccc"))
+  aof.Rewind(8)
+  if string(aof.Read(4, 4)) != "bbbb" || len(aof.Read(8, 4)) != 0 {
+    t.Fatal("wrong buffer after rewind")
+  }
+  if err := aof.Flush(); err != nil {
+    t.Fatal(err)
+  }
+  check_file(t, aof, "aaaabbbb")
+
+  // rewinding into flushed data truncates the file on flush
+  aof = open_test_file(t, "file")
+  aof.Append([]byte("aaaabbbbcccc"))
+  if err := aof.Flush(); err != nil {
+    t.Fatal(err)
+  }
+  aof.Append([]byte("dddd"))
+  aof.Rewind(4)
+  if len(aof.Read(4, 4)) != 0 || string(aof.Read(0, 4)) != "aaaa" {
+    t.Fatal("rewound data still readable")
+  }
+  aof.Append([]byte("eeee"))
+  aof.Rewind(8)
+  if err := aof.Flush(); err != nil {
+    t.Fatal(err)
+  }
+  check_file(t, aof, "aaaaeeee")
+
+  // a discarded rewind leaves the file untouched
+  aof.Rewind(0)
+  aof.Discard()
+  if err := aof.Flush(); err != nil {
+    t.Fatal(err)
+  }
+  check_file(t, aof, "aaaaeeee")
+}
+
+func Test_save_prune(t *testing.T) {
+  aof := open_test_file(t, "data")
+  data := []byte{}
+  for i := 0; i < 2000; i++ {
+    data = append(data, byte(i), byte(i>>8))
+  }
+  aof.Append(data)
+  if err := aof.Flush(); err != nil {
+    t.Fatal(err)
+  }
+
+  // offsets spanning several read buffers, up to the very last element
+  prune_offs := []uint64{0, 2, 510, 512, 1000, 3998}
+  pruned := [][]byte{}
+  target := aof.Path + ".pruned"
+  err := aof.Save_prune(target, prune_offs, 2, func(elmt []byte) {
+    pruned = append(pruned, append([]byte{}, elmt...))
+  })
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  expected := []byte{}
+  expected_pruned := [][]byte{}
+  for offs := uint64(0); offs < uint64(len(data)); offs += 2 {
+    skip := false
+    for _, prune_off := range prune_offs {
+      skip = skip || prune_off == offs
+    }
+    if skip {
+      expected_pruned = append(expected_pruned, data[offs:offs+2])
+    } else {
+      expected = append(expected, data[offs:offs+2]...)
+    }
+  }
+  content, _ := os.ReadFile(target)
+  if !bytes.Equal(content, expected) {
+    t.Fatalf("pruned file of %d bytes, expected %d", len(content), len(expected))
+  }
+  if len(pruned) != len(expected_pruned) {
+    t.Fatalf("%d pruned elements, expected %d", len(pruned), len(expected_pruned))
+  }
+  for i := range pruned {
+    if !bytes.Equal(pruned[i], expected_pruned[i]) {
+      t.Fatalf("pruned element %d is %x, expected %x", i, pruned[i], expected_pruned[i])
+    }
+  }
+
+  // without offsets it's a plain copy
+  if err := aof.Save_prune(target, nil, 2, nil); err != nil {
+    t.Fatal(err)
+  }
+  if content, _ := os.ReadFile(target); !bytes.Equal(content, data) {
+    t.Fatal("copy differs")
+  }
+
+  if err := aof.Save_prune(target, prune_offs, 0, nil); err == nil {
+    t.Fatal("zero prune length accepted")
+  }
+}
@@ -0,0 +1,25 @@
+package util
+
+/// Globally accessible static instance of secp256k1, to avoid
+/// initialization overhead
+
+import (
+  "sync"
+
+  "github.com/kelby/go-grin/secp"
+)
+
+var (
+  secp_instance *secp.Secp256k1
+  secp_once     sync.Once
+)
+
+/// Returns the static instance, creating it (and precomputing the bulletproof
+/// generators) on first use. The instance holds no mutable state so it's safe
+/// to share between goroutines.
+func Static_secp_instance() *secp.Secp256k1 {
+  secp_once.Do(func() {
+    secp_instance = secp.New()
+  })
+  return secp_instance
+}
@@ -1,23 +1,112 @@
 package libtx
 
-import "keychain"
+/// Aggsig helper functions used in transaction creation.. should be only
+/// interface into the underlying secp library
+
+import (
+	"github.com/kelby/go-grin/core/core"
+	"github.com/kelby/go-grin/keychain"
+	"github.com/kelby/go-grin/secp"
+)
 
 type Aggsig interface {
-	Create_secnonce(secp *Secp256k1) (SecretKey, error)
+	/// Creates a new secure nonce (as a SecretKey), guaranteed to be usable
+	/// during aggsig creation.
+	Create_secnonce(secp *secp.Secp256k1) (secp.SecretKey, error)
+
+	/// Calculates a partial signature given the signer's secure key,
+	/// the sum of all public nonces and (optionally) the sum of all public keys.
+	Calculate_partial_sig(secp *secp.Secp256k1, sec_key *secp.SecretKey, sec_nonce *secp.SecretKey, nonce_sum *secp.PublicKey, fee uint64, lock_height uint64) (secp.Signature, error)
+
+	/// Verifies a partial sig given all public nonces used in the round
+	Verify_partial_sig(secp *secp.Secp256k1, sig *secp.Signature, pub_nonce_sum *secp.PublicKey, pubkey *secp.PublicKey, fee uint64, lock_height uint64) error
+
+	/// Creates a single-signer aggsig signature from a key id.
+	Sign_from_key_id(secp *secp.Secp256k1, k keychain.Keychain, msg *secp.Message, key_id *keychain.Identifier) (secp.Signature, error)
+
+	/// Verifies a single-signer kernel signature against the excess commitment.
+	Verify_single_from_commit(secp *secp.Secp256k1, sig *secp.Signature, msg *secp.Message, commit *secp.Commitment) error
+
+	/// Verifies a completed (summed) signature, building the kernel message.
+	Verify_sig_build_msg(secp *secp.Secp256k1, sig *secp.Signature, pubkey *secp.PublicKey, fee uint64, lock_height uint64) error
+
+	/// Verifies an aggsig signature
+	Verify_single(secp *secp.Secp256k1, sig *secp.Signature, msg *secp.Message, pubnonce *secp.PublicKey, pubkey *secp.PublicKey, is_partial bool) bool
+
+	/// Adds signatures
+	Add_signatures(secp *secp.Secp256k1, part_sigs []*secp.Signature, nonce_sum *secp.PublicKey) (secp.Signature, error)
+
+	/// Just a simple sig, creates its own nonce, etc
+	Sign_with_blinding(secp *secp.Secp256k1, msg *secp.Message, blinding *keychain.BlindingFactor) (secp.Signature, error)
+}
+
+/// The Aggsig implementation backed by the secp256k1 aggsig module. The
+/// kernel message is built from the fee and lock height.
+type SecpAggsig struct{}
 
-	Calculate_partial_sig(secp *Secp256k1, sec_key *SecretKey, sec_nonce *SecretKey, nonce_sum *PublicKey, fee uint64, lock_height uint64) (Signature, error)
+var _ Aggsig = SecpAggsig{}
 
-	Verify_partial_sig(secp *Secp256k1, sig *Signature, pub_nonce_sum *PublicKey, pubkey *PublicKey, fee uint64, lock_height uint64) error
+func (SecpAggsig) Create_secnonce(secp_ctx *secp.Secp256k1) (secp.SecretKey, error) {
+	return secp_ctx.Export_secnonce_single()
+}
+
+func (SecpAggsig) Calculate_partial_sig(secp_ctx *secp.Secp256k1, sec_key *secp.SecretKey, sec_nonce *secp.SecretKey, nonce_sum *secp.PublicKey, fee uint64, lock_height uint64) (secp.Signature, error) {
+	msg := core.Kernel_sig_msg(fee, lock_height)
 
-	Sign_from_key_id(secp *Secp256k1, k *keychain.Keychain, msg *Message, key_id *keychain.Identifier) (Signature, error)
+	// The nonce sum is both committed to in e and decides whether our
+	// secret nonce gets negated
+	return secp_ctx.Sign_single(&msg, sec_key, sec_nonce, nonce_sum, nonce_sum)
+}
 
-	Verify_single_from_commit(secp *Secp256k1, sig *Signature, msg *Message, commit *Commitment) error
+func (SecpAggsig) Verify_partial_sig(secp_ctx *secp.Secp256k1, sig *secp.Signature, pub_nonce_sum *secp.PublicKey, pubkey *secp.PublicKey, fee uint64, lock_height uint64) error {
+	msg := core.Kernel_sig_msg(fee, lock_height)
+	if !secp_ctx.Verify_single(sig, &msg, pub_nonce_sum, pubkey, true) {
+		return Error{Kind: Signature, Msg: "Signature validation error"}
+	}
+	return nil
+}
 
-	Verify_sig_build_msg(secp *Secp256k1, sig *Signature, pubkey *PublicKey, fee u64, lock_height u64) error
+func (SecpAggsig) Sign_from_key_id(secp_ctx *secp.Secp256k1, k keychain.Keychain, msg *secp.Message, key_id *keychain.Identifier) (secp.Signature, error) {
+	skey, err := k.Derived_key(key_id)
+	if err != nil {
+		return secp.Signature{}, Error{Kind: Keychain, Msg: err.Error()}
+	}
+	return secp_ctx.Sign_single(msg, &skey, nil, nil, nil)
+}
 
-	Verify_single(secp *Secp256k1, sig *Signature, msg *Message, pubnonce *PublicKey, pubkey *PublicKey, is_partial bool) bool
+func (SecpAggsig) Verify_single_from_commit(secp_ctx *secp.Secp256k1, sig *secp.Signature, msg *secp.Message, commit *secp.Commitment) error {
+	// Note: the commitment is a valid public key only when it commits to a
+	// zero value, which is the case of kernel excesses
+	pubkey, err := commit.To_pubkey()
+	if err != nil {
+		return Error{Kind: Secp, Msg: err.Error()}
+	}
+	if !secp_ctx.Verify_single(sig, msg, nil, &pubkey, false) {
+		return Error{Kind: Signature, Msg: "Signature validation error"}
+	}
+	return nil
+}
 
-	Add_signatures(secp *Secp256k1, part_sigs []*Signature, nonce_sum *PublicKey) (Signature, error)
+func (self SecpAggsig) Verify_sig_build_msg(secp_ctx *secp.Secp256k1, sig *secp.Signature, pubkey *secp.PublicKey, fee uint64, lock_height uint64) error {
+	msg := core.Kernel_sig_msg(fee, lock_height)
+	if !self.Verify_single(secp_ctx, sig, &msg, nil, pubkey, false) {
+		return Error{Kind: Signature, Msg: "Signature validation error"}
+	}
+	return nil
+}
+
+func (SecpAggsig) Verify_single(secp_ctx *secp.Secp256k1, sig *secp.Signature, msg *secp.Message, pubnonce *secp.PublicKey, pubkey *secp.PublicKey, is_partial bool) bool {
+	return secp_ctx.Verify_single(sig, msg, pubnonce, pubkey, is_partial)
+}
+
+func (SecpAggsig) Add_signatures(secp_ctx *secp.Secp256k1, part_sigs []*secp.Signature, nonce_sum *secp.PublicKey) (secp.Signature, error) {
+	return secp_ctx.Add_signatures_single(part_sigs, nonce_sum)
+}
 
-	Sign_with_blinding(secp *Secp256k1, msg *Message, blinding *keychain.BlindingFactor) (Signature, error)
+func (SecpAggsig) Sign_with_blinding(secp_ctx *secp.Secp256k1, msg *secp.Message, blinding *keychain.BlindingFactor) (secp.Signature, error) {
+	skey, err := blinding.Secret_key()
+	if err != nil {
+		return secp.Signature{}, Error{Kind: Secp, Msg: err.Error()}
+	}
+	return secp_ctx.Sign_single(msg, &skey, nil, nil, nil)
 }
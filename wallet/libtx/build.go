@@ -1,6 +1,10 @@
 package libtx
 
+import (
+	"github.com/kelby/go-grin/keychain"
+)
+
 /// Context information available to transaction combinators.
 type Context struct {
-	Keychain Keychain
+	Keychain keychain.Keychain
 }
@@ -0,0 +1,43 @@
+package libtx
+
+import (
+	"fmt"
+)
+
+type ErrorKind int
+
+/// Libwallet error types
+const (
+	/// SECP error
+	Secp ErrorKind = iota
+	/// Keychain error
+	Keychain
+	/// Transaction error
+	Transaction
+	/// Signature error
+	Signature
+	/// Rangeproof error
+	RangeProof
+)
+
+/// Error definition
+type Error struct {
+	Kind ErrorKind
+	Msg  string
+}
+
+func (self Error) Error() string {
+	switch self.Kind {
+	case Secp:
+		return fmt.Sprintf("Secp error: %s", self.Msg)
+	case Keychain:
+		return fmt.Sprintf("Keychain error: %s", self.Msg)
+	case Transaction:
+		return fmt.Sprintf("Transaction error: %s", self.Msg)
+	case Signature:
+		return fmt.Sprintf("Signature error: %s", self.Msg)
+	case RangeProof:
+		return fmt.Sprintf("Rangeproof error: %s", self.Msg)
+	}
+	return "unknown libtx error"
+}